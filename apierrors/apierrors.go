@@ -0,0 +1,181 @@
+// Package apierrors is the error model of the HTTP API. Every failure is an
+// *Error whose Code tells clients what went wrong without parsing messages,
+// and which maps to the HTTP status it is reported with.
+package apierrors
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"upload-service/configs"
+	"upload-service/models"
+	"upload-service/responses"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Code is the stable machine-readable identifier of an error
+type Code string
+
+const (
+	NOT_FOUND              Code = "not_found"
+	VALIDATION             Code = "validation_failed"
+	UNAUTHORIZED           Code = "unauthorized"
+	CONFLICT               Code = "conflict"
+	FORBIDDEN              Code = "forbidden"
+	UNPROCESSABLE          Code = "unprocessable"
+	PAYLOAD_TOO_LARGE      Code = "payload_too_large"
+	UNSUPPORTED_MEDIA_TYPE Code = "unsupported_media_type"
+	GONE                   Code = "gone"
+	RATE_LIMITED           Code = "rate_limited"
+	NOT_IMPLEMENTED        Code = "not_implemented"
+	UPSTREAM_FAILURE       Code = "upstream_failure"
+	INTERNAL               Code = "internal_error"
+)
+
+var statuses = map[Code]int{
+	NOT_FOUND:              http.StatusNotFound,
+	VALIDATION:             http.StatusBadRequest,
+	UNAUTHORIZED:           http.StatusUnauthorized,
+	CONFLICT:               http.StatusConflict,
+	FORBIDDEN:              http.StatusForbidden,
+	UNPROCESSABLE:          http.StatusUnprocessableEntity,
+	PAYLOAD_TOO_LARGE:      http.StatusRequestEntityTooLarge,
+	UNSUPPORTED_MEDIA_TYPE: http.StatusUnsupportedMediaType,
+	GONE:                   http.StatusGone,
+	RATE_LIMITED:           http.StatusTooManyRequests,
+	NOT_IMPLEMENTED:        http.StatusNotImplemented,
+	UPSTREAM_FAILURE:       http.StatusBadGateway,
+	INTERNAL:               http.StatusInternalServerError,
+}
+
+// Status is the HTTP status an error code is reported with
+func (c Code) Status() int {
+	if status, ok := statuses[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is an API failure. Message is shown to clients; Err, when set, is the
+// underlying cause kept for logs and errors.Is/As.
+type Error struct {
+	Code    Code
+	Message string
+	Fields  []models.FieldError
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil && e.Err.Error() != e.Message {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status is the HTTP status the error is reported with
+func (e *Error) Status() int {
+	return e.Code.Status()
+}
+
+// New builds an error with the given code and client-facing message
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap builds an error with the given code, using cause as message
+func Wrap(code Code, cause error) *Error {
+	return &Error{Code: code, Message: cause.Error(), Err: cause}
+}
+
+func NotFound(message string) *Error {
+	return New(NOT_FOUND, message)
+}
+
+func Validation(message string) *Error {
+	return New(VALIDATION, message)
+}
+
+// InvalidFields reports one or more rejected request fields
+func InvalidFields(fields []models.FieldError) *Error {
+	return &Error{Code: VALIDATION, Message: "validation failed", Fields: fields}
+}
+
+func Unauthorized(message string) *Error {
+	return New(UNAUTHORIZED, message)
+}
+
+func Conflict(message string) *Error {
+	return New(CONFLICT, message)
+}
+
+func Forbidden(message string) *Error {
+	return New(FORBIDDEN, message)
+}
+
+func Unprocessable(message string) *Error {
+	return New(UNPROCESSABLE, message)
+}
+
+// Upstream reports a failure of a service we depend on (storage, transcoder,
+// notification service, ...)
+func Upstream(message string, cause error) *Error {
+	return &Error{Code: UPSTREAM_FAILURE, Message: message, Err: cause}
+}
+
+// Internal reports an unexpected failure of our own
+func Internal(message string, cause error) *Error {
+	return &Error{Code: INTERNAL, Message: message, Err: cause}
+}
+
+// From turns any error into an *Error. Missing documents become NOT_FOUND and
+// anything unrecognised becomes INTERNAL, with a generic message since the
+// cause may tell more about our internals than clients should know.
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &Error{Code: NOT_FOUND, Message: "not found", Err: err}
+	}
+	return Internal("internal error", err)
+}
+
+// Write reports err as a JSON ContentResponse with its status and code. The
+// causes of server-side failures are logged rather than sent.
+func Write(rw http.ResponseWriter, err error) {
+	apiErr := From(err)
+	if apiErr.Status() >= http.StatusInternalServerError && apiErr.Err != nil {
+		logger := configs.LogWithContext("api", "error")
+		logger.Error(apiErr.Message, "code", apiErr.Code, "error", apiErr.Err)
+	}
+	data := map[string]interface{}{"data": apiErr.Message}
+	if len(apiErr.Fields) > 0 {
+		data["errors"] = apiErr.Fields
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(apiErr.Status())
+	json.NewEncoder(rw).Encode(responses.ContentResponse{
+		Status:  apiErr.Status(),
+		Message: "error",
+		Code:    string(apiErr.Code),
+		Data:    data,
+	})
+}
+
+// Codes lists every error code, in a stable order
+func Codes() []Code {
+	codes := make([]Code, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	return codes
+}
@@ -0,0 +1,77 @@
+// Package auth verifies who is calling the API: end users with bearer JWTs,
+// and internal services (nginx callbacks, the transcoder, ...) with API keys.
+package auth
+
+import "context"
+
+const ROLE_ADMIN = "admin"
+
+// Scopes a service can be granted (see configs.EnvServiceScopes). A service
+// may only call the endpoints of its scopes.
+const (
+	// act on behalf of any user
+	SCOPE_USERS = "users"
+	// nginx-rtmp publish callbacks and stream lookups
+	SCOPE_STREAMS = "streams"
+	// the external video processor's status updates and uploads
+	SCOPE_PROCESSING = "processing"
+	// one-off migrations and backfills
+	SCOPE_MAINTENANCE = "maintenance"
+)
+
+// Identity is the authenticated caller of a request. Exactly one of UserID
+// and Service is set; Roles come from the user's token and Scopes from the
+// service's configuration.
+type Identity struct {
+	UserID  string
+	Service string
+	Roles   []string
+	Scopes  []string
+}
+
+func (id Identity) IsUser() bool {
+	return id.UserID != ""
+}
+
+func (id Identity) IsService() bool {
+	return id.Service != ""
+}
+
+func (id Identity) HasRole(role string) bool {
+	for _, r := range id.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (id Identity) HasScope(scope string) bool {
+	for _, s := range id.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// WithIdentity returns a copy of ctx carrying id
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the caller stored by the authentication middleware,
+// and false for anonymous requests
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(Identity)
+	return id, ok
+}
+
+// UserID returns the authenticated user's ID, or "" when the caller isn't an
+// authenticated user
+func UserID(ctx context.Context) string {
+	id, _ := FromContext(ctx)
+	return id.UserID
+}
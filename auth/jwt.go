@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"upload-service/configs"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrNoVerificationKey = errors.New("no JWT verification key configured")
+	ErrUnknownKey        = errors.New("token signed with an unknown key")
+	ErrNoUserClaim       = errors.New("token has no user claim")
+)
+
+// Verifier checks bearer tokens signed with HS256 (shared secret) or RS256
+// (a PEM public key or a JWKS)
+type Verifier struct {
+	secret     []byte
+	publicKey  *rsa.PublicKey
+	jwks       map[string]*rsa.PublicKey
+	userClaim  string
+	rolesClaim string
+	parser     *jwt.Parser
+}
+
+// NewVerifierFromEnv builds a Verifier from JWT_SECRET, JWT_PUBLIC_KEY_FILE
+// and JWKS_FILE. Any combination may be set; at least one is required.
+func NewVerifierFromEnv() (*Verifier, error) {
+	v := &Verifier{userClaim: configs.EnvJWTUserClaim(), rolesClaim: configs.EnvJWTRolesClaim()}
+	methods := []string{}
+
+	if secret := configs.EnvJWTSecret(); secret != "" {
+		v.secret = []byte(secret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if path := configs.EnvJWTPublicKeyFile(); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading JWT public key: %w", err)
+		}
+		if v.publicKey, err = jwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+			return nil, fmt.Errorf("parsing JWT public key: %w", err)
+		}
+	}
+	if path := configs.EnvJWKSFile(); path != "" {
+		keys, err := loadJWKS(path)
+		if err != nil {
+			return nil, err
+		}
+		v.jwks = keys
+	}
+	if v.publicKey != nil || len(v.jwks) > 0 {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, ErrNoVerificationKey
+	}
+
+	options := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if issuer := configs.EnvJWTIssuer(); issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+	if audience := configs.EnvJWTAudience(); audience != "" {
+		options = append(options, jwt.WithAudience(audience))
+	}
+	v.parser = jwt.NewParser(options...)
+	return v, nil
+}
+
+// Verify validates a token's signature and claims and returns the user it
+// was issued to
+func (v *Verifier) Verify(raw string) (Identity, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(raw, claims, v.key); err != nil {
+		return Identity{}, err
+	}
+	userID, _ := claims[v.userClaim].(string)
+	if userID == "" {
+		return Identity{}, ErrNoUserClaim
+	}
+	return Identity{UserID: userID, Roles: stringsClaim(claims[v.rolesClaim])}, nil
+}
+
+// stringsClaim reads a claim given either as a single string or a list
+func stringsClaim(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := []string{}
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func (v *Verifier) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		return v.secret, nil
+	case jwt.SigningMethodRS256.Alg():
+		if kid, ok := token.Header["kid"].(string); ok && len(v.jwks) > 0 {
+			if key, ok := v.jwks[kid]; ok {
+				return key, nil
+			}
+			if v.publicKey == nil {
+				return nil, ErrUnknownKey
+			}
+		}
+		if v.publicKey != nil {
+			return v.publicKey, nil
+		}
+		if len(v.jwks) == 1 {
+			for _, key := range v.jwks {
+				return key, nil
+			}
+		}
+		return nil, ErrUnknownKey
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// loadJWKS reads the RSA signing keys of a JSON Web Key Set
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWKS: %w", err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing JWKS: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("JWKS key %q: bad modulus: %w", key.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, fmt.Errorf("JWKS key %q: bad exponent: %w", key.Kid, err)
+		}
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS %s has no RSA signing keys", path)
+	}
+	return keys, nil
+}
@@ -48,6 +48,16 @@ func EnvPicturesBucket() string {
 }
 
 
+// EnvArchiveBucket holds untouched originals (e.g. HEIC masters) that are
+// never served publicly
+func EnvArchiveBucket() string {
+	bucket := os.Getenv("ARCHIVE_BUCKET")
+	if bucket == "" {
+		return EnvRawBucket()
+	}
+	return bucket
+}
+
 func EnvProcessedBucket() string {
 	bucket := os.Getenv("PROCESSED_BUCKET")
 	if bucket == "" {
@@ -1,8 +1,11 @@
 package configs
 
 import (
+	"net"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -43,6 +46,358 @@ func EnvMediaDir() string {
 	return "/tmp/media" // default fallback
 }
 
+// EnvStorageBackend selects where media is stored: s3, local or memory
+func EnvStorageBackend() string {
+	if backend := os.Getenv("STORAGE_BACKEND"); backend != "" {
+		return strings.ToLower(backend)
+	}
+	return "s3" // default fallback
+}
+
+// EnvMaxVideoSize is the largest video accepted by the upload flows, in bytes
+func EnvMaxVideoSize() int64 {
+	if size, err := strconv.ParseInt(os.Getenv("MAX_VIDEO_SIZE"), 10, 64); err == nil && size > 0 {
+		return size
+	}
+	return 20 << 30 // default fallback, 20GB
+}
+
+// EnvTusMaxSize is the largest resumable upload accepted, in bytes
+func EnvTusMaxSize() int64 {
+	if size, err := strconv.ParseInt(os.Getenv("TUS_MAX_SIZE"), 10, 64); err == nil && size > 0 {
+		return size
+	}
+	return EnvMaxVideoSize() // default fallback
+}
+
+// EnvPresignedPartSize is the part size handed out for direct-to-S3 uploads
+func EnvPresignedPartSize() int64 {
+	if size, err := strconv.ParseInt(os.Getenv("PRESIGNED_PART_SIZE"), 10, 64); err == nil && size >= 5<<20 {
+		return size
+	}
+	return 64 << 20 // default fallback, 64MB
+}
+
+// EnvPresignedURLExpiry is how long presigned part URLs stay valid
+func EnvPresignedURLExpiry() time.Duration {
+	if expiry, err := time.ParseDuration(os.Getenv("PRESIGNED_URL_EXPIRY")); err == nil && expiry > 0 {
+		return expiry
+	}
+	return 6 * time.Hour // default fallback
+}
+
+// EnvTusUploadExpiry is how long an unfinished resumable upload is kept
+func EnvTusUploadExpiry() time.Duration {
+	if expiry, err := time.ParseDuration(os.Getenv("TUS_UPLOAD_EXPIRY")); err == nil && expiry > 0 {
+		return expiry
+	}
+	return 24 * time.Hour // default fallback
+}
+
+// EnvContentPurgeAfter is how long deleted content is kept, restorable,
+// before its record and media are removed for good
+func EnvContentPurgeAfter() time.Duration {
+	if after, err := time.ParseDuration(os.Getenv("CONTENT_PURGE_AFTER")); err == nil && after > 0 {
+		return after
+	}
+	return 30 * 24 * time.Hour // default fallback
+}
+
+// EnvImageVariantWidths lists the widths picture derivatives are generated at
+func EnvImageVariantWidths() []int {
+	var widths []int
+	for _, field := range strings.Split(os.Getenv("IMAGE_VARIANT_WIDTHS"), ",") {
+		if w, err := strconv.Atoi(strings.TrimSpace(field)); err == nil && w > 0 {
+			widths = append(widths, w)
+		}
+	}
+	if len(widths) == 0 {
+		return []int{150, 320, 585, 1080, 2048} // default fallback
+	}
+	return widths
+}
+
+// EnvImageVariantFormats lists the encodings of every picture derivative.
+// WebP is opt-in: the encoder is lossless, so photos come out larger as WebP
+// than as JPEG.
+func EnvImageVariantFormats() []string {
+	var formats []string
+	for _, field := range strings.Split(os.Getenv("IMAGE_VARIANT_FORMATS"), ",") {
+		if f := strings.ToLower(strings.TrimSpace(field)); f == "webp" || f == "jpeg" {
+			formats = append(formats, f)
+		}
+	}
+	if len(formats) == 0 {
+		return []string{"jpeg"} // default fallback
+	}
+	return formats
+}
+
+// EnvImageMetadataAllowlist lists the metadata kept on stored pictures;
+// everything else is stripped. Set it to "none" to strip colour profiles too.
+func EnvImageMetadataAllowlist() []string {
+	value := os.Getenv("IMAGE_METADATA_ALLOWLIST")
+	if value == "" {
+		return []string{"icc"} // default fallback
+	}
+	var allowlist []string
+	for _, field := range strings.Split(value, ",") {
+		if f := strings.ToLower(strings.TrimSpace(field)); f != "" && f != "none" {
+			allowlist = append(allowlist, f)
+		}
+	}
+	return allowlist
+}
+
+// EnvHEICDecoder selects how HEIC/HEIF uploads are decoded: "wasm" uses the
+// built-in libheif build, anything else is run as an external converter
+// taking input and output paths (e.g. "heif-convert")
+func EnvHEICDecoder() string {
+	decoder := os.Getenv("HEIC_DECODER")
+	if decoder == "" {
+		return "wasm" // default fallback
+	}
+	return decoder
+}
+
+// EnvBannedHashDistance is how many bits a picture's pHash may differ from a
+// banned hash and still be rejected
+func EnvBannedHashDistance() int {
+	if distance, err := strconv.Atoi(os.Getenv("BANNED_HASH_DISTANCE")); err == nil && distance >= 0 {
+		return distance
+	}
+	return 6 // default fallback
+}
+
+// EnvNearDuplicateDistance is how many bits apart two of a creator's posts
+// may be before they stop being reported as near-duplicates
+func EnvNearDuplicateDistance() int {
+	if distance, err := strconv.Atoi(os.Getenv("NEAR_DUPLICATE_DISTANCE")); err == nil && distance >= 0 {
+		return distance
+	}
+	return 5 // default fallback
+}
+
+// EnvAuthEnabled turns off authentication when AUTH_ENABLED is "false", for
+// local development only
+func EnvAuthEnabled() bool {
+	return os.Getenv("AUTH_ENABLED") != "false"
+}
+
+// EnvJWTSecret is the shared secret HS256 tokens are signed with
+func EnvJWTSecret() string {
+	return os.Getenv("JWT_SECRET")
+}
+
+// EnvJWTPublicKeyFile is a PEM public key RS256 tokens are verified with
+func EnvJWTPublicKeyFile() string {
+	return os.Getenv("JWT_PUBLIC_KEY_FILE")
+}
+
+// EnvJWKSFile is a JSON Web Key Set RS256 tokens are verified with, keys
+// being picked by the token's kid
+func EnvJWKSFile() string {
+	return os.Getenv("JWKS_FILE")
+}
+
+// EnvJWTIssuer, when set, is the only accepted iss claim
+func EnvJWTIssuer() string {
+	return os.Getenv("JWT_ISSUER")
+}
+
+// EnvJWTAudience, when set, must appear in the aud claim
+func EnvJWTAudience() string {
+	return os.Getenv("JWT_AUDIENCE")
+}
+
+// EnvJWTUserClaim is the claim holding the user ID
+func EnvJWTUserClaim() string {
+	if claim := os.Getenv("JWT_USER_CLAIM"); claim != "" {
+		return claim
+	}
+	return "sub" // default fallback
+}
+
+// EnvJWTRolesClaim is the claim listing the user's roles, e.g. "admin"
+func EnvJWTRolesClaim() string {
+	if claim := os.Getenv("JWT_ROLES_CLAIM"); claim != "" {
+		return claim
+	}
+	return "roles" // default fallback
+}
+
+// EnvServiceAPIKeys maps service names to the API keys they authenticate
+// with, configured as "nginx:key1,transcoder:key2"
+func EnvServiceAPIKeys() map[string]string {
+	keys := map[string]string{}
+	for _, entry := range strings.Split(os.Getenv("SERVICE_API_KEYS"), ",") {
+		name, key, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if ok && name != "" && key != "" {
+			keys[name] = key
+		}
+	}
+	return keys
+}
+
+// EnvServiceScopes maps service names to the scopes they are granted (see
+// auth.SCOPE_*), configured as "nginx=streams,processor=processing|users".
+// Services without scopes can't call anything.
+func EnvServiceScopes() map[string][]string {
+	value := os.Getenv("SERVICE_SCOPES")
+	if value == "" {
+		value = "nginx=streams" // default fallback
+	}
+	scopes := map[string][]string{}
+	for _, entry := range strings.Split(value, ",") {
+		name, granted, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" {
+			continue
+		}
+		for _, scope := range strings.Split(granted, "|") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				scopes[name] = append(scopes[name], scope)
+			}
+		}
+	}
+	return scopes
+}
+
+// EnvTrustedProxies lists the networks of the proxies in front of the
+// service, whose X-Forwarded-For entries are believed. Entries are CIDRs or
+// single addresses; the default covers loopback and private networks.
+func EnvTrustedProxies() []*net.IPNet {
+	value := os.Getenv("TRUSTED_PROXIES")
+	if value == "" {
+		value = "127.0.0.0/8,::1/128,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7" // default fallback
+	}
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+// EnvIdempotencyTTL is how long a response is kept for replay to requests
+// repeating its Idempotency-Key
+func EnvIdempotencyTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 24 * time.Hour // default fallback
+}
+
+// EnvIdempotencyMaxBody is the largest request body fingerprinted for an
+// Idempotency-Key, in bytes. Larger plain bodies are refused; larger file
+// parts of multipart uploads are identified by name and size only.
+func EnvIdempotencyMaxBody() int64 {
+	if size, err := strconv.ParseInt(os.Getenv("IDEMPOTENCY_MAX_BODY"), 10, 64); err == nil && size > 0 {
+		return size
+	}
+	return 10 << 20 // default fallback, 10MB
+}
+
+// EnvTranscodeWorkers is how many videos this instance transcodes at once;
+// 0 leaves transcoding to other instances or the external processor. Only
+// set it where ffmpeg and TRANSCODE_SCRIPT are installed.
+func EnvTranscodeWorkers() int {
+	if workers, err := strconv.Atoi(os.Getenv("TRANSCODE_WORKERS")); err == nil && workers >= 0 {
+		return workers
+	}
+	return 0 // default fallback
+}
+
+// EnvTranscodeScript is the script that turns a raw video into an HLS ladder
+func EnvTranscodeScript() string {
+	if script := os.Getenv("TRANSCODE_SCRIPT"); script != "" {
+		return script
+	}
+	return "script/create-vod-hls.sh" // default fallback
+}
+
+// EnvTranscodeMaxAttempts is how often a transcoding job runs before it's
+// given up on
+func EnvTranscodeMaxAttempts() int {
+	if attempts, err := strconv.Atoi(os.Getenv("TRANSCODE_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		return attempts
+	}
+	return 5 // default fallback
+}
+
+// EnvTranscodeTimeout bounds a single transcoding attempt
+func EnvTranscodeTimeout() time.Duration {
+	if timeout, err := time.ParseDuration(os.Getenv("TRANSCODE_TIMEOUT")); err == nil && timeout > 0 {
+		return timeout
+	}
+	return 2 * time.Hour // default fallback
+}
+
+// EnvFFprobePath is the ffprobe binary uploaded videos are probed with
+func EnvFFprobePath() string {
+	if path := os.Getenv("FFPROBE_PATH"); path != "" {
+		return path
+	}
+	return "ffprobe" // default fallback
+}
+
+// VideoLimits caps the videos a tier may upload; zero means no cap
+type VideoLimits struct {
+	MaxDuration   time.Duration
+	MaxResolution int // the shorter side in pixels, e.g. 1080 for 1080p
+}
+
+// EnvVideoTierLimits maps upload tiers to their limits, configured as
+// "default=30m:1080,creator=3h:2160". A user's tier is the first of their
+// roles with limits, "default" otherwise.
+func EnvVideoTierLimits() map[string]VideoLimits {
+	value := os.Getenv("VIDEO_TIER_LIMITS")
+	if value == "" {
+		value = "default=30m:1080,creator=3h:2160" // default fallback
+	}
+	tiers := map[string]VideoLimits{}
+	for _, entry := range strings.Split(value, ",") {
+		name, limits, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" {
+			continue
+		}
+		rawDuration, rawResolution, _ := strings.Cut(limits, ":")
+		duration, err := time.ParseDuration(rawDuration)
+		if err != nil {
+			continue
+		}
+		resolution, err := strconv.Atoi(rawResolution)
+		if err != nil && rawResolution != "" {
+			continue
+		}
+		tiers[name] = VideoLimits{MaxDuration: duration, MaxResolution: resolution}
+	}
+	return tiers
+}
+
+// EnvTranscoderCallbackSecret is the key external transcoders sign their
+// completion callbacks with; without one the callback endpoint is disabled
+func EnvTranscoderCallbackSecret() string {
+	return os.Getenv("TRANSCODER_CALLBACK_SECRET")
+}
+
+// EnvTranscoderCallbackTolerance is how far a callback's timestamp may be
+// from our clock before it's rejected as stale
+func EnvTranscoderCallbackTolerance() time.Duration {
+	if tolerance, err := time.ParseDuration(os.Getenv("TRANSCODER_CALLBACK_TOLERANCE")); err == nil && tolerance > 0 {
+		return tolerance
+	}
+	return 5 * time.Minute // default fallback
+}
+
 func EnvStreamDir() string {
 	if dir := os.Getenv("STREAMDIR"); dir != "" {
 		return dir
@@ -1,6 +1,7 @@
 package configs
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"time"
@@ -94,6 +95,30 @@ func LogWithContext(service, operation string) *logrus.Entry {
 	})
 }
 
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request's correlation ID
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the correlation ID of the request ctx belongs to, or ""
+// outside a request
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// LogWithRequest is LogWithContext for code serving a request: entries also
+// carry the request ID, so one request's lines can be found together
+func LogWithRequest(ctx context.Context, service, operation string) *logrus.Entry {
+	entry := LogWithContext(service, operation)
+	if requestID := RequestID(ctx); requestID != "" {
+		entry = entry.WithField("request_id", requestID)
+	}
+	return entry
+}
+
 // LogDatabaseOperation logs database operations with timing
 func LogDatabaseOperation(operation, collection string, start time.Time, err error) {
 	duration := time.Since(start)
@@ -113,13 +138,14 @@ func LogDatabaseOperation(operation, collection string, start time.Time, err err
 }
 
 // LogHTTPRequest logs HTTP requests
-func LogHTTPRequest(method, path string, statusCode int, duration time.Duration, clientIP string) {
+func LogHTTPRequest(method, path string, statusCode int, duration time.Duration, clientIP, requestID string) {
 	fields := logrus.Fields{
 		"method":      method,
 		"path":        path,
 		"status_code": statusCode,
 		"duration":    duration.String(),
 		"client_ip":   clientIP,
+		"request_id":  requestID,
 		"service":     "http",
 	}
 
@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"context"
+	"time"
+	"upload-service/configs"
+	"upload-service/models"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	AUDIT_METADATA_STRIPPED = "image.metadata_stripped"
+)
+
+func getAuditLogCollection() *mongo.Collection {
+	return configs.GetCollection(configs.DB, "audit_log")
+}
+
+// writeAuditLog stores an audit entry. Failures are logged, with the request
+// ID ctx carries, but never fail the request that triggered them.
+func writeAuditLog(ctx context.Context, action, actorID, subject string, details map[string]interface{}) {
+	entry := models.AuditEntry{
+		Action:    action,
+		ActorID:   actorID,
+		Subject:   subject,
+		Details:   details,
+		CreatedAt: time.Now(),
+	}
+	if _, err := getAuditLogCollection().InsertOne(ctx, entry); err != nil {
+		configs.LogWithRequest(ctx, "audit", "write").Warn("Error writing audit log", "action", action, "subject", subject, "error", err)
+	}
+}
@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+	"upload-service/apierrors"
+	"upload-service/auth"
+	"upload-service/configs"
+	"upload-service/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	AUDIT_ACCESS_DENIED = "authz.denied"
+
+	POLICY_OWNER             = "owner"
+	POLICY_CONTENT_OWNER     = "content-owner"
+	POLICY_REQUEST_RECIPIENT = "request-recipient"
+	POLICY_ADMIN             = "admin"
+	POLICY_SERVICE           = "service"
+
+	ACTION_ACT_AS_USER        = "act as this user"
+	ACTION_PROMOTE_CONTENT    = "promote this content"
+	ACTION_CALL_INTERNAL      = "call internal endpoints"
+	ACTION_EDIT_CONTENT       = "edit this content"
+	ACTION_DELETE_CONTENT     = "delete this content"
+	ACTION_EDIT_COMMENT       = "edit this comment"
+	ACTION_DELETE_COMMENT     = "delete this comment"
+	ACTION_ANSWER_REPOST      = "answer this repost request"
+	ACTION_MODERATE           = "moderate content"
+	ACTION_READ_ALL_FEEDBACK  = "read feedback"
+	ACTION_MANAGE_TRANSCODING = "manage this video's transcoding"
+	ACTION_WATCH_TRANSCODING  = "follow this video's transcoding"
+
+	// replies are followed up at most this many comments to find their post
+	MAX_COMMENT_DEPTH = 20
+)
+
+// policy grants access to some callers. authorize lets a request through
+// when any of the policies it is given does.
+type policy struct {
+	name  string
+	allow func(caller auth.Identity) bool
+}
+
+// userPolicy grants access to one user, under the given policy name
+func userPolicy(name, userID string) policy {
+	return policy{name: name, allow: func(caller auth.Identity) bool {
+		return caller.UserID != "" && caller.UserID == userID
+	}}
+}
+
+func ownerPolicy(ownerID string) policy {
+	return userPolicy(POLICY_OWNER, ownerID)
+}
+
+var adminPolicy = policy{name: POLICY_ADMIN, allow: func(caller auth.Identity) bool {
+	return caller.HasRole(auth.ROLE_ADMIN)
+}}
+
+// servicePolicy grants access to services holding scope
+func servicePolicy(scope string) policy {
+	return policy{name: POLICY_SERVICE + ":" + scope, allow: func(caller auth.Identity) bool {
+		return caller.IsService() && caller.HasScope(scope)
+	}}
+}
+
+// authorize checks the caller against policies. Anonymous callers get a 401;
+// authenticated callers no policy admits get a 403 and an audit entry.
+func authorize(r *http.Request, action, subject string, policies ...policy) error {
+	if !configs.EnvAuthEnabled() {
+		return nil
+	}
+	caller, ok := auth.FromContext(r.Context())
+	if !ok {
+		return apierrors.Unauthorized("authentication required")
+	}
+	names := []string{}
+	for _, p := range policies {
+		if p.allow(caller) {
+			return nil
+		}
+		names = append(names, p.name)
+	}
+
+	actor := caller.UserID
+	if actor == "" {
+		actor = "service:" + caller.Service
+	}
+	ctx, cancel := requestContext(r, 5*time.Second)
+	defer cancel()
+	writeAuditLog(ctx, AUDIT_ACCESS_DENIED, actor, subject, map[string]interface{}{
+		"action":   action,
+		"policies": names,
+		"method":   r.Method,
+		"path":     r.URL.Path,
+	})
+	return apierrors.Forbidden(fmt.Sprintf("not allowed to %s", action))
+}
+
+// authorizeUser checks the caller may act as userID. Users may only act as
+// themselves; internal services granted auth.SCOPE_USERS act on behalf of any
+// user.
+func authorizeUser(r *http.Request, userID string) error {
+	return authorize(r, ACTION_ACT_AS_USER, userID, ownerPolicy(userID), servicePolicy(auth.SCOPE_USERS))
+}
+
+// requireService rejects callers other than internal services granted scope,
+// for callbacks and maintenance endpoints
+func requireService(r *http.Request, scope string) error {
+	return authorize(r, ACTION_CALL_INTERNAL, r.URL.Path, servicePolicy(scope))
+}
+
+// requireAdmin rejects callers without the admin role
+func requireAdmin(r *http.Request, action string) error {
+	return authorize(r, action, r.URL.Path, adminPolicy)
+}
+
+// postedBy is who a post belongs to on their profile: the reposter for
+// reposts, which keep the original's author as UserID
+func postedBy(content *models.Content) string {
+	if isRepost(content) {
+		return content.Poster
+	}
+	return content.UserID
+}
+
+// authorizeContentOwner loads content for an owner-only action; admins pass
+// too
+func authorizeContentOwner(ctx context.Context, r *http.Request, action string, contentID primitive.ObjectID) (models.Content, error) {
+	content := models.Content{}
+	if err := getContentCollection().FindOne(ctx, bson.M{"_id": contentID}).Decode(&content); err != nil {
+		return content, apierrors.From(err)
+	}
+	if err := authorize(r, action, contentID.Hex(), ownerPolicy(content.UserID), adminPolicy); err != nil {
+		return content, err
+	}
+	return content, nil
+}
+
+// authorizeComment loads a comment and checks the caller may perform action
+// on it: its author and admins always may, and when contentOwnerMay is set so
+// may the owner of the post it was left on
+func authorizeComment(ctx context.Context, r *http.Request, action string, commentID primitive.ObjectID, contentOwnerMay bool) (models.Comment, error) {
+	comment := models.Comment{}
+	if err := getCommentsCollection().FindOne(ctx, bson.M{"_id": commentID}).Decode(&comment); err != nil {
+		return comment, apierrors.From(err)
+	}
+	policies := []policy{ownerPolicy(comment.UserID), adminPolicy}
+	if contentOwnerMay {
+		if owner, err := commentContentOwner(ctx, comment); err == nil {
+			policies = append(policies, userPolicy(POLICY_CONTENT_OWNER, owner))
+		} else {
+			configs.LogWithRequest(r.Context(), "authz", "comment-owner").Warn("Error resolving comment content owner", "comment_id", commentID.Hex(), "error", err)
+		}
+	}
+	if err := authorize(r, action, commentID.Hex(), policies...); err != nil {
+		return comment, err
+	}
+	return comment, nil
+}
+
+// commentContentOwner returns the owner of the post a comment belongs to,
+// following replies up to their top-level comment for comments the backfill
+// hasn't reached yet
+func commentContentOwner(ctx context.Context, comment models.Comment) (string, error) {
+	contentID := comment.ContentID
+	for depth := 0; contentID == "" && depth < MAX_COMMENT_DEPTH; depth++ {
+		if !comment.ReplyToComment {
+			contentID = comment.ReplyTo
+			break
+		}
+		parentID, err := primitive.ObjectIDFromHex(comment.ReplyTo)
+		if err != nil {
+			return "", err
+		}
+		parent := models.Comment{}
+		if err := getCommentsCollection().FindOne(ctx, bson.M{"_id": parentID}).Decode(&parent); err != nil {
+			return "", err
+		}
+		comment = parent
+		contentID = comment.ContentID
+	}
+
+	oID, err := primitive.ObjectIDFromHex(contentID)
+	if err != nil {
+		return "", err
+	}
+	content := models.Content{}
+	if err := getContentCollection().FindOne(ctx, bson.M{"_id": oID}).Decode(&content); err != nil {
+		return "", err
+	}
+	return content.UserID, nil
+}
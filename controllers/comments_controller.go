@@ -8,6 +8,8 @@ import (
 	"net/url"
 	"strconv"
 	"time"
+	"upload-service/apierrors"
+	"upload-service/auth"
 	"upload-service/configs"
 	"upload-service/models"
 
@@ -23,17 +25,21 @@ func getCommentsCollection() *mongo.Collection {
 
 func AddComment() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := requestContext(r, 10*time.Second)
 		defer cancel()
 		vars := mux.Vars(r)
 		userID := vars["UserID"]
 		replyTo := vars["ReplyTo"]
 		commentTxtEncoded := vars["Comment"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		commentTxtDecoded, _ := url.QueryUnescape(commentTxtEncoded)
 
 		replyToComment, err := strconv.ParseBool(vars["ReplyToComment"])
 		if err != nil {
-			errorResponse(rw, err, 200)
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
 			return
 		}
 		comment := models.Comment{
@@ -46,7 +52,7 @@ func AddComment() http.HandlerFunc {
 
 		res, err := getCommentsCollection().InsertOne(ctx, comment)
 		if err != nil {
-			errorResponse(rw, err, 200)
+			errorResponse(rw, err)
 			return
 		}
 		oCID, err := primitive.ObjectIDFromHex(replyTo)
@@ -69,17 +75,21 @@ func AddComment() http.HandlerFunc {
 
 func AddCommentWithBody() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := requestContext(r, 10*time.Second)
 		defer cancel()
 		vars := mux.Vars(r)
 		userID := vars["UserID"]
 		replyTo := vars["ReplyTo"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 
 		commentBody := models.CommentBody{}
 
 		err := json.NewDecoder(r.Body).Decode(&commentBody)
 		if err != nil {
-			errorResponse(rw, fmt.Errorf("bad request"), 200)
+			errorResponse(rw, apierrors.Validation("bad request"))
 			return
 		}
 		commentTxtEncoded := commentBody.Comment
@@ -88,7 +98,7 @@ func AddCommentWithBody() http.HandlerFunc {
 
 		replyToComment, err := strconv.ParseBool(vars["ReplyToComment"])
 		if err != nil {
-			errorResponse(rw, err, 200)
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
 			return
 		}
 		comment := models.Comment{
@@ -101,7 +111,7 @@ func AddCommentWithBody() http.HandlerFunc {
 
 		res, err := getCommentsCollection().InsertOne(ctx, comment)
 		if err != nil {
-			errorResponse(rw, err, 200)
+			errorResponse(rw, err)
 			return
 		}
 		oCID, err := primitive.ObjectIDFromHex(replyTo)
@@ -124,7 +134,7 @@ func AddCommentWithBody() http.HandlerFunc {
 
 func AddCommentWithBodyWithOtherUserID() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := requestContext(r, 10*time.Second)
 		defer cancel()
 
 		vars := mux.Vars(r)
@@ -132,22 +142,26 @@ func AddCommentWithBodyWithOtherUserID() http.HandlerFunc {
 		ownerUserID := vars["OwnerUserID"]
 		contentID := vars["ContentID"]
 		replyTo := vars["ReplyTo"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 
 		isReply, err := strconv.ParseBool(vars["IsReply"])
 		if err != nil {
-			errorResponse(rw, fmt.Errorf("invalid IsReply value: %v", err), http.StatusBadRequest)
+			errorResponse(rw, apierrors.Validation(fmt.Sprintf("invalid IsReply value: %v", err)))
 			return
 		}
 
 		var commentBody models.CommentBody
 		if err := json.NewDecoder(r.Body).Decode(&commentBody); err != nil {
-			errorResponse(rw, fmt.Errorf("invalid JSON body: %v", err), http.StatusBadRequest)
+			errorResponse(rw, apierrors.Validation(fmt.Sprintf("invalid JSON body: %v", err)))
 			return
 		}
 
 		commentTxtDecoded, err := url.QueryUnescape(commentBody.Comment)
 		if err != nil {
-			errorResponse(rw, fmt.Errorf("failed to unescape comment text: %v", err), http.StatusBadRequest)
+			errorResponse(rw, apierrors.Validation(fmt.Sprintf("failed to unescape comment text: %v", err)))
 			return
 		}
 
@@ -162,7 +176,7 @@ func AddCommentWithBodyWithOtherUserID() http.HandlerFunc {
 
 		insertRes, err := getCommentsCollection().InsertOne(ctx, comment)
 		if err != nil {
-			errorResponse(rw, fmt.Errorf("failed to insert comment: %v", err), http.StatusInternalServerError)
+			errorResponse(rw, apierrors.Internal("failed to insert comment", err))
 			return
 		}
 
@@ -204,12 +218,16 @@ func notifyOnComment(
 
 func BackfillComments() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if err := requireService(r, auth.SCOPE_MAINTENANCE); err != nil {
+			errorResponse(w, err)
+			return
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
 		cursor, err := getCommentsCollection().Find(ctx, bson.M{})
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			errorResponse(w, err)
 			return
 		}
 		defer cursor.Close(ctx)
@@ -274,14 +292,18 @@ func EditComment() http.HandlerFunc {
 		commentTxtDecoded, _ := url.QueryUnescape(commentTxtEncoded)
 		oID, err := primitive.ObjectIDFromHex(commentID)
 		if err != nil {
-			errorResponse(rw, err, 200)
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
+			return
+		}
+		if _, err := authorizeComment(ctx, r, ACTION_EDIT_COMMENT, oID, false); err != nil {
+			errorResponse(rw, err)
 			return
 		}
 		filter := bson.M{"_id": oID}
 		update := bson.M{"$set": bson.M{"comment": commentTxtDecoded}}
 		err = getCommentsCollection().FindOneAndUpdate(ctx, filter, update).Err()
 		if err != nil {
-			errorResponse(rw, err, 200)
+			errorResponse(rw, err)
 			return
 		}
 		successResponse(rw, "Updated")
@@ -298,21 +320,25 @@ func EditCommentWithBody() http.HandlerFunc {
 
 		err := json.NewDecoder(r.Body).Decode(&commentBody)
 		if err != nil {
-			errorResponse(rw, fmt.Errorf("bad request"), 200)
+			errorResponse(rw, apierrors.Validation("bad request"))
 			return
 		}
 		commentTxt := commentBody.Comment
 
 		oID, err := primitive.ObjectIDFromHex(commentID)
 		if err != nil {
-			errorResponse(rw, err, 200)
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
+			return
+		}
+		if _, err := authorizeComment(ctx, r, ACTION_EDIT_COMMENT, oID, false); err != nil {
+			errorResponse(rw, err)
 			return
 		}
 		filter := bson.M{"_id": oID}
 		update := bson.M{"$set": bson.M{"comment": commentTxt}}
 		err = getCommentsCollection().FindOneAndUpdate(ctx, filter, update).Err()
 		if err != nil {
-			errorResponse(rw, err, 200)
+			errorResponse(rw, err)
 			return
 		}
 		successResponse(rw, "Updated")
@@ -327,12 +353,16 @@ func DeleteComment() http.HandlerFunc {
 		commentID := vars["CommentID"]
 		commentOID, err := primitive.ObjectIDFromHex(commentID)
 		if err != nil {
-			errorResponse(rw, err, 200)
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
+			return
+		}
+		if _, err := authorizeComment(ctx, r, ACTION_DELETE_COMMENT, commentOID, true); err != nil {
+			errorResponse(rw, err)
 			return
 		}
 		err = getCommentsCollection().FindOneAndUpdate(ctx, bson.M{"_id": commentOID}, bson.M{"$set": bson.M{"isdeleted": true}}).Err()
 		if err != nil {
-			errorResponse(rw, err, 200)
+			errorResponse(rw, err)
 			return
 		}
 		successResponse(rw, "Deleted")
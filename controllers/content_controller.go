@@ -10,17 +10,19 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+	"upload-service/apierrors"
+	"upload-service/auth"
 	"upload-service/configs"
+	"upload-service/media"
 	"upload-service/models"
 	"upload-service/responses"
+	"upload-service/storage"
+	"upload-service/transcode"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/disintegration/imaging"
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/google/uuid"
@@ -60,29 +62,6 @@ const (
 	VISIBILITY_FOLLOWERS = "followers"
 )
 
-// 10GB
-func Execute(script string, command []string) (bool, error) {
-	cmd := &exec.Cmd{
-		Path:   script,
-		Args:   command,
-		Stdout: os.Stdout,
-		Stderr: os.Stderr,
-	}
-
-	err := cmd.Start()
-	if err != nil {
-		fmt.Println(err)
-		return false, err
-	}
-
-	/* err = cmd.Wait()
-	if err != nil {
-		return false, err
-	} */
-
-	return true, nil
-}
-
 func CleanUp(path string) (bool, error) {
 	e := os.Remove(path)
 	if e != nil {
@@ -92,15 +71,22 @@ func CleanUp(path string) (bool, error) {
 	return true, nil
 }
 
-func errorResponse(rw http.ResponseWriter, err error, code int) {
-	rw.WriteHeader(code)
-	response := responses.ContentResponse{Status: code, Message: "error", Data: map[string]interface{}{"data": err.Error()}}
-	json.NewEncoder(rw).Encode(response)
+// errorResponse reports err with the status and code of its apierrors kind;
+// untyped errors are reported as internal errors
+func errorResponse(rw http.ResponseWriter, err error) {
+	apierrors.Write(rw, err)
 }
 
 func successResponse(rw http.ResponseWriter, result interface{}) {
-	rw.WriteHeader(http.StatusCreated)
-	response := responses.ContentResponse{Status: http.StatusCreated, Message: "success", Data: map[string]interface{}{"data": result}}
+	writeResponse(rw, http.StatusCreated, "success", map[string]interface{}{"data": result})
+}
+
+// writeResponse writes a ContentResponse for handlers whose payload doesn't
+// fit successResponse
+func writeResponse(rw http.ResponseWriter, status int, message string, data map[string]interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	response := responses.ContentResponse{Status: status, Message: message, Data: data}
 	json.NewEncoder(rw).Encode(response)
 }
 
@@ -117,19 +103,24 @@ func UpdateOnProfilePic() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
+		logger := configs.LogWithRequest(r.Context(), "content", "update-profile-pic")
 		vars := mux.Vars(r)
 		userID := vars["UserID"]
 		fileName := vars["Filename"]
 		whatWillChange := vars["ToBeChanged"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		userObj := models.User{}
 		oID, err := primitive.ObjectIDFromHex(userID)
 		if err != nil {
-			errorResponse(rw, fmt.Errorf("invalid object id"), 200)
+			errorResponse(rw, apierrors.Validation("invalid object id"))
 			return
 		}
 		err = getUsersCollection().FindOne(ctx, bson.M{"_id": oID}).Decode(&userObj)
 		if err != nil {
-			errorResponse(rw, fmt.Errorf("couldn't find/decode user"), 200)
+			errorResponse(rw, apierrors.From(err))
 			return
 		}
 
@@ -141,8 +132,8 @@ func UpdateOnProfilePic() http.HandlerFunc {
 			delete := bson.M{"$set": bson.M{"isdeleted": true, "iscurrent": false}}
 			err := getProfilePicsCollection().FindOneAndUpdate(ctx, filterByFilename, delete).Err()
 			if err != nil {
-				fmt.Println("1")
-				errorResponse(rw, err, 500)
+				logger.Warn("Failed to mark profile pic deleted", "error", err)
+				errorResponse(rw, err)
 				return
 			}
 			sortByDateCreated := options.Find()
@@ -152,14 +143,14 @@ func UpdateOnProfilePic() http.HandlerFunc {
 			var pics []models.ProfilePic
 			cur, err := getProfilePicsCollection().Find(ctx, filterByNotDeleted, sortByDateCreated)
 			if err != nil {
-				fmt.Println("2")
-				errorResponse(rw, err, 500)
+				logger.Warn("Failed to list profile pics", "error", err)
+				errorResponse(rw, err)
 				return
 			}
 			if err := cur.All(ctx, &pics); err != nil {
 				if err != nil {
-					fmt.Println("3")
-					errorResponse(rw, err, 500)
+					logger.Warn("Failed to decode profile pics", "error", err)
+					errorResponse(rw, err)
 					return
 				}
 			}
@@ -175,15 +166,15 @@ func UpdateOnProfilePic() http.HandlerFunc {
 
 				_, err = getProfilePicsCollection().UpdateOne(ctx, filterByID, makeCurrentTrue)
 				if err != nil {
-					fmt.Println("4")
-					errorResponse(rw, err, 500)
+					logger.Warn("Failed to make profile pic current", "error", err)
+					errorResponse(rw, err)
 					return
 				}
 				setUserPic := bson.M{"$set": bson.M{"profile_pic": pics[0].Location}}
 				_, err = getUsersCollection().UpdateOne(ctx, filterByUserID, setUserPic)
 				if err != nil {
-					fmt.Println("could not set new profile pic")
-					errorResponse(rw, err, 500)
+					logger.Warn("Could not set new profile pic", "error", err)
+					errorResponse(rw, err)
 					return
 				}
 			}
@@ -194,33 +185,33 @@ func UpdateOnProfilePic() http.HandlerFunc {
 
 			_, err := getProfilePicsCollection().UpdateOne(ctx, filterByCurrent, makeCurrentFalse)
 			if err != nil {
-				fmt.Println("11111")
-				errorResponse(rw, err, 500)
+				logger.Warn("Failed to clear current profile pic", "error", err)
+				errorResponse(rw, err)
 				return
 			}
 			_, err = getProfilePicsCollection().UpdateOne(ctx, filterByFilename, makeCurrentTrue)
 			if err != nil {
-				fmt.Println("22222", fileName)
-				errorResponse(rw, err, 500)
+				logger.Warn("Failed to make profile pic current", "filename", fileName, "error", err)
+				errorResponse(rw, err)
 				return
 			}
 			pic := models.NewProfilePic{}
 			err = getProfilePicsCollection().FindOne(ctx, filterByFilename).Decode(&pic)
 			if err != nil {
-				fmt.Println("could not load new pic")
-				errorResponse(rw, err, 500)
+				logger.Warn("Could not load new profile pic", "error", err)
+				errorResponse(rw, err)
 				return
 			}
 			setUserPic := bson.M{"$set": bson.M{"profile_pic": pic.Location}}
 			_, err = getUsersCollection().UpdateOne(ctx, filterByUserID, setUserPic)
 			if err != nil {
-				fmt.Println("could not set new profile pic")
-				errorResponse(rw, err, 500)
+				logger.Warn("Could not set new profile pic", "error", err)
+				errorResponse(rw, err)
 				return
 			}
 		} else {
 			err := fmt.Errorf("malformed request")
-			errorResponse(rw, err, 400)
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
 			return
 		}
 		successResponse(rw, "OK")
@@ -232,57 +223,20 @@ func EditContent() http.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		vars := mux.Vars(r)
-		oID := vars["ContentID"]
-		title := vars["Title"]
-		description := vars["Description"]
-		tags := vars["Tags"]
-		show, _ := strconv.ParseBool(vars["Show"])
-		ispayperview, _ := strconv.ParseBool(vars["IsPayPerView"])
-		isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
-		theType := vars["Type"]
-		posting := vars["Posting"]
-		ppvprice := vars["PPVPrice"]
-		price, err := strconv.ParseFloat(ppvprice, 64)
+		contentID, err := primitive.ObjectIDFromHex(vars["ContentID"])
 		if err != nil {
-			fmt.Println("invalid price in PPVPrice")
-			price = 0
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
+			return
 		}
-		contentID, err := primitive.ObjectIDFromHex(oID)
-		if err != nil {
-			errorResponse(rw, err, 200)
-			return
-		}
-		theTags := strings.Split(tags, ",")
-		filterByFilename := bson.M{"_id": contentID}
-		update := bson.M{}
-		if theType == TYPE_PIC || theType == TYPE_VIDEO {
-			update = bson.M{"$set": bson.M{
-				"isdeleted":    isdeleted,
-				"show":         show,
-				"ispayperview": ispayperview,
-				"ppvprice":     price,
-				"title":        title,
-				"description":  description,
-				"tags":         theTags,
-			}}
-		} else if theType == TYPE_TEXT {
-			update = bson.M{"$set": bson.M{
-				"isdeleted":    isdeleted,
-				"posting":      posting,
-				"show":         show,
-				"ispayperview": ispayperview,
-				"ppvprice":     price,
-				"title":        title,
-				"description":  description,
-				"tags":         theTags,
-			}}
-		}
-		mongoSingleResult := getContentCollection().FindOneAndUpdate(ctx, filterByFilename, update)
-
-		var content = models.Content{}
-		err = mongoSingleResult.Decode(&content)
+		content, err := authorizeContentOwner(ctx, r, ACTION_EDIT_CONTENT, contentID)
 		if err != nil {
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
+			return
+		}
+		doc := legacyContentDocument(vars)
+		doc.Posting = vars["Posting"]
+		if err := updateContent(ctx, content, vars["Type"], doc); err != nil {
+			errorResponse(rw, err)
 			return
 		}
 		successResponse(rw, "OK")
@@ -295,66 +249,27 @@ func EditContentWithBody() http.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		vars := mux.Vars(r)
-		oID := vars["ContentID"]
-		tags := vars["Tags"]
-		show, _ := strconv.ParseBool(vars["Show"])
-		ispayperview, _ := strconv.ParseBool(vars["IsPayPerView"])
-		isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
-		theType := vars["Type"]
-		ppvprice := vars["PPVPrice"]
-		price, err := strconv.ParseFloat(ppvprice, 64)
+		contentID, err := primitive.ObjectIDFromHex(vars["ContentID"])
 		if err != nil {
-			fmt.Println("invalid price in PPVPrice")
-			price = 0
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
+			return
 		}
-		contentID, err := primitive.ObjectIDFromHex(oID)
+		content, err := authorizeContentOwner(ctx, r, ACTION_EDIT_CONTENT, contentID)
 		if err != nil {
-			errorResponse(rw, err, 200)
+			errorResponse(rw, err)
 			return
 		}
 		contentBody := models.ContentBody{}
 
 		err = json.NewDecoder(r.Body).Decode(&contentBody)
 		if err != nil {
-			errorResponse(rw, fmt.Errorf("bad request"), 200)
-			return
-		}
-
-		title := contentBody.Title
-		description := contentBody.Description
-		posting := contentBody.Posting
-
-		theTags := strings.Split(tags, ",")
-		filterByFilename := bson.M{"_id": contentID}
-		update := bson.M{}
-		if theType == TYPE_PIC || theType == TYPE_VIDEO {
-			update = bson.M{"$set": bson.M{
-				"isdeleted":    isdeleted,
-				"show":         show,
-				"ispayperview": ispayperview,
-				"ppvprice":     price,
-				"title":        title,
-				"description":  description,
-				"tags":         theTags,
-			}}
-		} else if theType == TYPE_TEXT {
-			update = bson.M{"$set": bson.M{
-				"isdeleted":    isdeleted,
-				"posting":      posting,
-				"show":         show,
-				"ispayperview": ispayperview,
-				"ppvprice":     price,
-				"title":        title,
-				"description":  description,
-				"tags":         theTags,
-			}}
-		}
-		mongoSingleResult := getContentCollection().FindOneAndUpdate(ctx, filterByFilename, update)
-
-		var content = models.Content{}
-		err = mongoSingleResult.Decode(&content)
-		if err != nil {
-			errorResponse(rw, err, 500)
+			errorResponse(rw, apierrors.Validation("bad request"))
+			return
+		}
+
+		doc := withContentBody(legacyContentDocument(vars), contentBody)
+		if err := updateContent(ctx, content, vars["Type"], doc); err != nil {
+			errorResponse(rw, err)
 			return
 		}
 		successResponse(rw, "OK")
@@ -366,71 +281,27 @@ func EditContentWithBodyV2() http.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		vars := mux.Vars(r)
-		oID := vars["ContentID"]
-		tags := vars["Tags"]
-		show, _ := strconv.ParseBool(vars["Show"])
-		ispayperview, _ := strconv.ParseBool(vars["IsPayPerView"])
-		isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
-		theType := vars["Type"]
-		ppvprice := vars["PPVPrice"]
-		visibility := vars["Visibility"]
-		price, err := strconv.ParseFloat(ppvprice, 64)
+		contentID, err := primitive.ObjectIDFromHex(vars["ContentID"])
 		if err != nil {
-			fmt.Println("invalid price in PPVPrice")
-			price = 0
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
+			return
 		}
-		contentID, err := primitive.ObjectIDFromHex(oID)
+		content, err := authorizeContentOwner(ctx, r, ACTION_EDIT_CONTENT, contentID)
 		if err != nil {
-			errorResponse(rw, err, 200)
+			errorResponse(rw, err)
 			return
 		}
 		contentBody := models.ContentBody{}
 
 		err = json.NewDecoder(r.Body).Decode(&contentBody)
 		if err != nil {
-			errorResponse(rw, fmt.Errorf("bad request"), 200)
-			return
-		}
-
-		title := contentBody.Title
-		description := contentBody.Description
-		posting := contentBody.Posting
-		if visibility != VISIBILITY_FOLLOWERS {
-			visibility = VISIBILITY_EVERYONE
-		}
-		theTags := strings.Split(tags, ",")
-		filterByFilename := bson.M{"_id": contentID}
-		update := bson.M{}
-		if theType == TYPE_PIC || theType == TYPE_VIDEO {
-			update = bson.M{"$set": bson.M{
-				"isdeleted":    isdeleted,
-				"show":         show,
-				"ispayperview": ispayperview,
-				"ppvprice":     price,
-				"title":        title,
-				"description":  description,
-				"tags":         theTags,
-				"visibility":   visibility,
-			}}
-		} else if theType == TYPE_TEXT {
-			update = bson.M{"$set": bson.M{
-				"isdeleted":    isdeleted,
-				"posting":      posting,
-				"show":         show,
-				"ispayperview": ispayperview,
-				"ppvprice":     price,
-				"title":        title,
-				"description":  description,
-				"tags":         theTags,
-				"visibility":   visibility,
-			}}
-		}
-		mongoSingleResult := getContentCollection().FindOneAndUpdate(ctx, filterByFilename, update)
-
-		var content = models.Content{}
-		err = mongoSingleResult.Decode(&content)
-		if err != nil {
-			errorResponse(rw, err, 500)
+			errorResponse(rw, apierrors.Validation("bad request"))
+			return
+		}
+
+		doc := withContentBody(legacyContentDocument(vars), contentBody)
+		if err := updateContent(ctx, content, vars["Type"], doc); err != nil {
+			errorResponse(rw, err)
 			return
 		}
 		successResponse(rw, "OK")
@@ -438,35 +309,35 @@ func EditContentWithBodyV2() http.HandlerFunc {
 	}
 }
 
-func deleteFromS3(bucketName, S3RawKey string) error {
-	if S3RawKey == "" {
+func deleteStoredObject(bucketName, key string) error {
+	if key == "" {
 		return nil
 	}
 
 	ctx := context.Background()
-	client := configs.GetS3Client()
-
-	_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(S3RawKey),
-	})
 
+	err := storage.Backend().Delete(ctx, bucketName, key)
 	if err != nil {
-		fmt.Println("Error deleting from S3:", err)
+		configs.LogWithContext("storage", "delete").Error("Error deleting from storage", "bucket", bucketName, "key", key, "error", err)
 		return err
 	}
 
-	fmt.Println("Deleted from S3:", S3RawKey)
+	configs.LogWithContext("storage", "delete").Debug("Deleted from storage", "bucket", bucketName, "key", key)
 	return nil
 }
 
 func PostProfilePic() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := requestContext(r, 5*time.Minute)
 		defer cancel()
+		logger := configs.LogWithRequest(r.Context(), "content", "create-profile-pic")
 
 		vars := mux.Vars(r)
 		userID := vars["UserID"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		iscurrent, _ := strconv.ParseBool(vars["IsCurrent"])
 		isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
 
@@ -480,7 +351,7 @@ func PostProfilePic() http.HandlerFunc {
 		// Retrieve file from form
 		file, _, err := r.FormFile("file")
 		if err != nil {
-			errorResponse(rw, err, http.StatusBadRequest)
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
 			return
 		}
 		defer file.Close()
@@ -488,15 +359,15 @@ func PostProfilePic() http.HandlerFunc {
 		// Read the entire file for detection
 		fileBytes, err := io.ReadAll(file)
 		if err != nil {
-			fmt.Println("failed to read file data:", err)
-			errorResponse(rw, err, http.StatusInternalServerError)
+			logger.Warn("Failed to read file data", "error", err)
+			errorResponse(rw, err)
 			return
 		}
 
 		// Detect MIME
 		detectedMIME := mimetype.Detect(fileBytes)
 		mimeType := detectedMIME.String()
-		fmt.Println("Detected MIME:", mimeType)
+		logger.Debug("Detected MIME", "mime", mimeType)
 
 		extension := ""
 		switch mimeType {
@@ -509,11 +380,27 @@ func PostProfilePic() http.HandlerFunc {
 		case "image/heic", "image/heif":
 			extension = "heic"
 		default:
-			http.Error(rw, "This file type is not allowed for images", http.StatusBadRequest)
+			errorResponse(rw, apierrors.New(apierrors.UNSUPPORTED_MEDIA_TYPE, "This file type is not allowed for images"))
 			return
 		}
 
-		// If setting as current, delete old current profile pic from S3
+		fileBytes, mimeType, extension, err = webSafePicture(ctx, fileBytes, mimeType, extension)
+		if err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		fileBytes, mimeType, err = sanitizePicture(ctx, userID, imageID, fileBytes, mimeType)
+		if err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		extension = pictureExtensionFor(mimeType)
+		if err := rejectBannedPicture(ctx, userID, fileBytes, mimeType); err != nil {
+			errorResponse(rw, apierrors.Wrap(apierrors.UNPROCESSABLE, err))
+			return
+		}
+
+		// If setting as current, delete old current profile pic from storage
 		if iscurrent {
 			var oldPic models.NewProfilePic
 			err := getProfilePicsCollection().FindOne(ctx, bson.M{
@@ -522,8 +409,8 @@ func PostProfilePic() http.HandlerFunc {
 			}).Decode(&oldPic)
 
 			if err == nil && oldPic.S3RawKey != "" {
-				// Delete old file from S3
-				deleteFromS3(configs.EnvPicturesBucket(), oldPic.S3RawKey)
+				// Delete old file from storage
+				deleteStoredObject(configs.EnvPicturesBucket(), oldPic.S3RawKey)
 
 				// Mark as not current in DB
 				getProfilePicsCollection().UpdateOne(ctx,
@@ -533,25 +420,20 @@ func PostProfilePic() http.HandlerFunc {
 			}
 		}
 
-		uploader := configs.GetS3Uploader()
+		store := storage.Backend()
 		S3RawKey := fmt.Sprintf("%s/profile/%s.%s", userID, imageID, extension)
 
-		fmt.Printf("Uploading profile pic to S3: s3://%s/%s\n", configs.EnvPicturesBucket(), S3RawKey)
+		logger.Info("Uploading profile pic", "bucket", configs.EnvPicturesBucket(), "key", S3RawKey)
 
-		_, err = uploader.Upload(ctx, &s3.PutObjectInput{
-			Bucket:      aws.String(configs.EnvPicturesBucket()),
-			Key:         aws.String(S3RawKey),
-			Body:        bytes.NewReader(fileBytes),
-			ContentType: aws.String(mimeType),
-		})
+		err = store.Put(ctx, configs.EnvPicturesBucket(), S3RawKey, bytes.NewReader(fileBytes), mimeType)
 		if err != nil {
-			fmt.Println("Error uploading to S3:", err)
-			errorResponse(rw, fmt.Errorf("error uploading profile pic"), 500)
+			logger.Error("Error uploading to storage", "error", err)
+			errorResponse(rw, apierrors.Upstream("error uploading profile pic", err))
 			return
 		}
-		fmt.Println("Profile pic uploaded to S3")
+		logger.Debug("Profile pic uploaded")
 
-		profilePicURL := configs.EnvPicturesCDNURL() + "/" + S3RawKey
+		profilePicURL := store.PublicURL(configs.EnvPicturesBucket(), S3RawKey)
 
 		newPostPic := models.NewProfilePic{
 			UserID:      userID,
@@ -564,7 +446,7 @@ func PostProfilePic() http.HandlerFunc {
 
 		result, err := getProfilePicsCollection().InsertOne(ctx, newPostPic)
 		if err != nil {
-			errorResponse(rw, err, http.StatusInternalServerError)
+			errorResponse(rw, err)
 			return
 		}
 
@@ -578,8 +460,7 @@ func PostProfilePic() http.HandlerFunc {
 	}
 }
 
-func uploadProfilePic(userID string, file multipart.File, fileHeader *multipart.FileHeader, filename string) (string, error) {
-	ctx := context.Background()
+func uploadProfilePic(ctx context.Context, userID string, file multipart.File, fileHeader *multipart.FileHeader, filename string) (string, error) {
 
 	// Read file
 	fileBytes, err := io.ReadAll(file)
@@ -605,33 +486,47 @@ func uploadProfilePic(userID string, file multipart.File, fileHeader *multipart.
 		extension = strings.Split(fileHeader.Filename, ".")[1]
 	}
 
-	// Upload to S3
-	uploader := configs.GetS3Uploader()
+	fileBytes, mimeType, extension, err = webSafePicture(ctx, fileBytes, mimeType, extension)
+	if err != nil {
+		return "", err
+	}
+	fileBytes, mimeType, err = sanitizePicture(ctx, userID, filename, fileBytes, mimeType)
+	if err != nil {
+		return "", err
+	}
+	if ext := pictureExtensionFor(mimeType); ext != "" {
+		extension = ext
+	}
+	if err := rejectBannedPicture(ctx, userID, fileBytes, mimeType); err != nil {
+		return "", err
+	}
+
+	// Upload to storage
+	store := storage.Backend()
 	s3Key := fmt.Sprintf("%s/profile/%s.%s", userID, filename, extension)
 
-	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(configs.EnvPicturesBucket()),
-		Key:         aws.String(s3Key),
-		Body:        bytes.NewReader(fileBytes),
-		ContentType: aws.String(mimeType),
-	})
+	err = store.Put(ctx, configs.EnvPicturesBucket(), s3Key, bytes.NewReader(fileBytes), mimeType)
 	if err != nil {
 		return "", err
 	}
 
-	return configs.EnvPicturesCDNURL() + "/" + s3Key, nil
+	return store.PublicURL(configs.EnvPicturesBucket(), s3Key), nil
 }
 
 func PostProfilePicBase64() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := requestContext(r, 5*time.Minute)
 		defer cancel()
 
 		vars := mux.Vars(r)
 		userID := vars["UserID"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		oid, err := primitive.ObjectIDFromHex(userID)
 		if err != nil {
-			errorResponse(rw, fmt.Errorf("invalid userID"), 400)
+			errorResponse(rw, apierrors.Validation("invalid userID"))
 			return
 		}
 
@@ -642,23 +537,26 @@ func PostProfilePicBase64() http.HandlerFunc {
 
 		file, fileHeader, err := r.FormFile("file")
 		if err != nil {
-			errorResponse(rw, err, 400)
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
 			return
 		}
 		defer file.Close()
 
 		profileID := primitive.NewObjectID()
 
-		// uploadProfilePic now returns CDN URL and uploads to S3
-		location, err := uploadProfilePic(userID, file, fileHeader, profileID.Hex())
+		// uploadProfilePic uploads to storage and returns the public URL
+		location, err := uploadProfilePic(ctx, userID, file, fileHeader, profileID.Hex())
+		if err == ErrBannedImage {
+			errorResponse(rw, apierrors.Wrap(apierrors.UNPROCESSABLE, err))
+			return
+		}
 		if err != nil {
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 
-		fmt.Print("LOADING WHEN LOADED", location)
+		configs.LogWithRequest(r.Context(), "content", "create-profile-pic").Debug("Profile pic uploaded", "location", location, "iscurrent", iscurrent)
 
-		fmt.Println("iscurrent", iscurrent)
 		// If setting as current, delete old current profile pic from S3
 		if iscurrent {
 			//var oldPic models.NewProfilePic
@@ -668,7 +566,7 @@ func PostProfilePicBase64() http.HandlerFunc {
 			// }).Decode(&oldPic)
 			// TODO AFTER YOU SET THE DELETE POLICY IN ACCOUNT
 			// if err == nil && oldPic.S3RawKey != "" {
-			// 	deleteFromS3(configs.EnvPicturesBucket(), oldPic.S3RawKey)
+			// 	deleteStoredObject(configs.EnvPicturesBucket(), oldPic.S3RawKey)
 			// }
 
 			// Mark old pics as not current
@@ -697,7 +595,7 @@ func PostProfilePicBase64() http.HandlerFunc {
 
 		result, err := getProfilePicsCollection().InsertOne(ctx, newPostPic)
 		if err != nil {
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 
@@ -707,383 +605,43 @@ func PostProfilePicBase64() http.HandlerFunc {
 
 func PostPic() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := requestContext(r, 5*time.Minute)
 		defer cancel()
 
-		vars := mux.Vars(r)
-		userID := vars["UserID"]
-		title := vars["Title"]
-		description := vars["Description"]
-		tags := vars["Tags"]
-		visibility := vars["Visibility"]
-		show, _ := strconv.ParseBool(vars["Show"])
-		ispayperview, _ := strconv.ParseBool(vars["IsPayPerView"])
-		isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
-		ppvprice := vars["PPVPrice"]
-		price, err := strconv.ParseFloat(ppvprice, 64)
-		if err != nil {
-			fmt.Println("invalid price in PPVPrice")
-			price = 0
-		}
-
-		if visibility != VISIBILITY_FOLLOWERS {
-			visibility = VISIBILITY_EVERYONE
-		}
-
-		// Generate unique ID
-		imageID := strings.Replace(uuid.New().String(), "-", "", -1)
+		doc := legacyContentDocument(mux.Vars(r))
 
 		r.ParseMultipartForm(10 * MB)
 		r.Body = http.MaxBytesReader(rw, r.Body, 10*MB)
 
-		file, _, err := r.FormFile("file")
-		if err != nil {
-			fmt.Println("Error reading form file:", err)
-			errorResponse(rw, err, 400)
-			return
-		}
-		defer file.Close()
-
-		// Read the entire file for MIME detection
-		fileBytes, err := io.ReadAll(file)
-		if err != nil {
-			fmt.Println("Failed to read file data:", err)
-			errorResponse(rw, fmt.Errorf("failed to read file"), 500)
-			return
-		}
-
-		// Detect MIME type
-		detectedMIME := mimetype.Detect(fileBytes)
-		mimeType := detectedMIME.String()
-		fmt.Println("Detected MIME:", mimeType)
-
-		extension := ""
-		switch mimeType {
-		case "image/png":
-			extension = "png"
-		case "image/jpeg":
-			extension = "jpeg"
-		case "image/webp":
-			extension = "webp"
-		case "image/heic", "image/heif":
-			extension = "heic"
-		default:
-			http.Error(rw, "This file type is not allowed for images", http.StatusBadRequest)
-			return
-		}
-
-		// Upload original to S3
-		uploader := configs.GetS3Uploader()
-		s3OriginalKey := fmt.Sprintf("%s/%s.%s", userID, imageID, extension)
-
-		fmt.Printf("Uploading original image to S3: s3://%s/%s\n", configs.EnvPicturesBucket(), s3OriginalKey)
-
-		_, err = uploader.Upload(ctx, &s3.PutObjectInput{
-			Bucket:      aws.String(configs.EnvPicturesBucket()),
-			Key:         aws.String(s3OriginalKey),
-			Body:        bytes.NewReader(fileBytes),
-			ContentType: aws.String(mimeType),
-		})
-		if err != nil {
-			fmt.Println("Error uploading original to S3:", err)
-			errorResponse(rw, fmt.Errorf("error uploading image"), 500)
-			return
-		}
-		fmt.Println("Original image uploaded to S3")
-
-		// Generate and upload thumbnail
-		var s3ThumbnailKey string
-		if mimeType == "image/heic" || mimeType == "image/heif" {
-			// For HEIC, use original as thumbnail
-			s3ThumbnailKey = s3OriginalKey
-		} else {
-			// Decode and resize
-			img, err := imaging.Decode(bytes.NewReader(fileBytes))
-			if err != nil {
-				fmt.Println("Error decoding image:", err)
-				s3ThumbnailKey = s3OriginalKey // Fallback to original
-			} else {
-				thumbnail := imaging.Resize(img, 585, 0, imaging.Linear)
-
-				var thumbBuf bytes.Buffer
-				switch extension {
-				case "jpeg":
-					imaging.Encode(&thumbBuf, thumbnail, imaging.JPEG)
-				case "png":
-					imaging.Encode(&thumbBuf, thumbnail, imaging.PNG)
-				case "webp":
-					imaging.Encode(&thumbBuf, thumbnail, imaging.JPEG)
-				default:
-					imaging.Encode(&thumbBuf, thumbnail, imaging.JPEG)
-				}
-
-				s3ThumbnailKey = fmt.Sprintf("%s/%s_thumb.%s", userID, imageID, extension)
-
-				fmt.Printf("Uploading thumbnail to S3: s3://%s/%s\n", configs.EnvPicturesBucket(), s3ThumbnailKey)
-
-				_, err = uploader.Upload(ctx, &s3.PutObjectInput{
-					Bucket:      aws.String(configs.EnvPicturesBucket()),
-					Key:         aws.String(s3ThumbnailKey),
-					Body:        &thumbBuf,
-					ContentType: aws.String(mimeType),
-				})
-				if err != nil {
-					fmt.Println("Error uploading thumbnail:", err)
-					s3ThumbnailKey = s3OriginalKey // Fallback
-				} else {
-					fmt.Println("Thumbnail uploaded to S3")
-				}
-			}
-		}
-
-		// Build CDN URLs
-		cdnURL := configs.EnvPicturesCDNURL()
-		originalURL := cdnURL + "/" + s3OriginalKey
-		thumbnailURL := cdnURL + "/" + s3ThumbnailKey
-
-		newPostPic := models.Content{
-			UserID:       userID,
-			Poster:       userID,
-			Title:        title,
-			Description:  description,
-			Location:     originalURL,
-			Posting:      thumbnailURL,
-			S3RawKey:     s3OriginalKey,
-			ThumbnailKey: s3ThumbnailKey,
-			DateCreated:  time.Now(),
-			Show:         show,
-			IsPayPerView: ispayperview,
-			IsDeleted:    isdeleted,
-			PPVPrice:     price,
-			Type:         TYPE_PIC,
-			Visibility:   visibility,
-		}
-
-		newPostPic.Tags = strings.Split(tags, ",")
-		for i, s := range newPostPic.Tags {
-			newPostPic.Tags[i] = strings.TrimSpace(s)
-		}
-
-		// Insert to DB
-		result, err := getContentCollection().InsertOne(ctx, newPostPic)
-		fmt.Println(result)
-		if err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
-			response := responses.ContentResponse{
-				Status:  http.StatusInternalServerError,
-				Message: "error",
-				Data:    map[string]interface{}{"data": err.Error()},
-			}
-			json.NewEncoder(rw).Encode(response)
-			return
-		}
-
-		// Return success
-		rw.WriteHeader(http.StatusCreated)
-		response := responses.ContentResponse{
-			Status:  http.StatusCreated,
-			Message: "success",
-			Data:    map[string]interface{}{"data": result},
-		}
-		json.NewEncoder(rw).Encode(response)
+		createPicture(ctx, rw, r, doc)
 	}
 }
 
 func PostPicWithBody() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := requestContext(r, 5*time.Minute)
 		defer cancel()
 
-		vars := mux.Vars(r)
-		userID := vars["UserID"]
-		tags := vars["Tags"]
-		visibility := vars["Visibility"]
-		show, _ := strconv.ParseBool(vars["Show"])
-		ispayperview, _ := strconv.ParseBool(vars["IsPayPerView"])
-		isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
-		ppvprice := vars["PPVPrice"]
-		price, err := strconv.ParseFloat(ppvprice, 64)
-		if err != nil {
-			fmt.Println("invalid price in PPVPrice")
-			price = 0
-		}
-
-		if visibility != VISIBILITY_FOLLOWERS {
-			visibility = VISIBILITY_EVERYONE
-		}
-
 		// Read JSON from formValue("data")
 		jsonData := r.FormValue("data")
 		if jsonData == "" {
-			errorResponse(rw, fmt.Errorf("missing data"), 400)
+			errorResponse(rw, apierrors.Validation("missing data"))
 			return
 		}
 
 		var contentBody models.ContentBody
 		if err := json.Unmarshal([]byte(jsonData), &contentBody); err != nil {
-			errorResponse(rw, err, 400)
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
 			return
 		}
 
-		title := contentBody.Title
-		description := contentBody.Description
-
-		// Generate unique ID
-		imageID := strings.Replace(uuid.New().String(), "-", "", -1)
+		doc := withContentBody(legacyContentDocument(mux.Vars(r)), contentBody)
 
 		// Parse multipart form
 		r.ParseMultipartForm(10 * MB)
 		r.Body = http.MaxBytesReader(rw, r.Body, 10*MB)
 
-		// Retrieve file
-		file, _, err := r.FormFile("file")
-		if err != nil {
-			fmt.Println(err)
-			errorResponse(rw, err, 400)
-			return
-		}
-		defer file.Close()
-
-		// Read entire file for MIME detection
-		fileBytes, err := io.ReadAll(file)
-		if err != nil {
-			fmt.Println("failed to read file data:", err)
-			errorResponse(rw, fmt.Errorf("failed to read file"), 500)
-			return
-		}
-
-		detectedMIME := mimetype.Detect(fileBytes)
-		mimeType := detectedMIME.String()
-		fmt.Println("Detected MIME:", mimeType)
-
-		extension := ""
-		switch mimeType {
-		case "image/png":
-			extension = "png"
-		case "image/jpeg":
-			extension = "jpeg"
-		case "image/webp":
-			extension = "webp"
-		case "image/heic":
-			extension = "heic"
-		case "image/heif":
-			extension = "heic"
-		default:
-			http.Error(rw, "This file type is not allowed for images", http.StatusBadRequest)
-			return
-		}
-
-		uploader := configs.GetS3Uploader()
-		s3OriginalKey := fmt.Sprintf("%s/%s.%s", userID, imageID, extension)
-
-		fmt.Printf("Uploading original image to S3: s3://%s/%s\n", configs.EnvPicturesBucket(), s3OriginalKey)
-
-		_, err = uploader.Upload(ctx, &s3.PutObjectInput{
-			Bucket:      aws.String(configs.EnvPicturesBucket()),
-			Key:         aws.String(s3OriginalKey),
-			Body:        bytes.NewReader(fileBytes),
-			ContentType: aws.String(mimeType),
-		})
-		if err != nil {
-			fmt.Println("Error uploading original to S3:", err)
-			errorResponse(rw, fmt.Errorf("error uploading image"), 500)
-			return
-		}
-		fmt.Println("Original image uploaded to S3")
-
-		var s3ThumbnailKey string
-		if mimeType == "image/heic" || mimeType == "image/heif" {
-			s3ThumbnailKey = s3OriginalKey
-		} else {
-
-			img, err := imaging.Decode(bytes.NewReader(fileBytes))
-			if err != nil {
-				fmt.Println("Error decoding image:", err)
-				s3ThumbnailKey = s3OriginalKey
-			} else {
-				thumbnail := imaging.Resize(img, 585, 0, imaging.Linear)
-
-				var thumbBuf bytes.Buffer
-				switch extension {
-				case "jpeg":
-					imaging.Encode(&thumbBuf, thumbnail, imaging.JPEG)
-				case "png":
-					imaging.Encode(&thumbBuf, thumbnail, imaging.PNG)
-				case "webp":
-					imaging.Encode(&thumbBuf, thumbnail, imaging.JPEG)
-				default:
-					imaging.Encode(&thumbBuf, thumbnail, imaging.JPEG)
-				}
-
-				s3ThumbnailKey = fmt.Sprintf("%s/%s_thumb.%s", userID, imageID, extension)
-
-				fmt.Printf("Uploading thumbnail to S3: s3://%s/%s\n", configs.EnvPicturesBucket(), s3ThumbnailKey)
-
-				_, err = uploader.Upload(ctx, &s3.PutObjectInput{
-					Bucket:      aws.String(configs.EnvPicturesBucket()),
-					Key:         aws.String(s3ThumbnailKey),
-					Body:        &thumbBuf,
-					ContentType: aws.String(mimeType),
-				})
-				if err != nil {
-					fmt.Println("Error uploading thumbnail:", err)
-					s3ThumbnailKey = s3OriginalKey
-				} else {
-					fmt.Println("Thumbnail uploaded to S3")
-				}
-			}
-		}
-
-		cdnURL := configs.EnvPicturesCDNURL()
-		originalURL := cdnURL + "/" + s3OriginalKey
-		thumbnailURL := cdnURL + "/" + s3ThumbnailKey
-
-		newPostPic := models.Content{
-			UserID:       userID,
-			Poster:       userID,
-			Title:        title,
-			Description:  description,
-			Location:     originalURL,
-			Posting:      thumbnailURL,
-			S3RawKey:     s3OriginalKey,
-			ThumbnailKey: s3ThumbnailKey,
-			DateCreated:  time.Now(),
-			Show:         show,
-			IsPayPerView: ispayperview,
-			IsDeleted:    isdeleted,
-			PPVPrice:     price,
-			Type:         TYPE_PIC,
-			Visibility:   visibility,
-		}
-
-		newPostPic.Tags = strings.Split(tags, ",")
-		for i, s := range newPostPic.Tags {
-			newPostPic.Tags[i] = strings.TrimSpace(s)
-		}
-
-		fmt.Print(">>>", newPostPic)
-		// Insert into DB
-		result, err := getContentCollection().InsertOne(ctx, newPostPic)
-		fmt.Println(result)
-		if err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
-			response := responses.ContentResponse{
-				Status:  http.StatusInternalServerError,
-				Message: "error",
-				Data:    map[string]interface{}{"data": err.Error()},
-			}
-			json.NewEncoder(rw).Encode(response)
-			return
-		}
-
-		// Success
-		rw.WriteHeader(http.StatusCreated)
-		response := responses.ContentResponse{
-			Status:  http.StatusCreated,
-			Message: "success",
-			Data:    map[string]interface{}{"data": result},
-		}
-		json.NewEncoder(rw).Encode(response)
+		createPicture(ctx, rw, r, doc)
 	}
 }
 
@@ -1268,7 +826,7 @@ func PostVideo() http.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Minute)
 		defer cancel()
 
-		fmt.Print("CHECKPOINT 1")
+		logger := configs.LogWithRequest(r.Context(), "content", "post-video")
 
 		// Parse parameters
 		vars := mux.Vars(r)
@@ -1276,13 +834,17 @@ func PostVideo() http.HandlerFunc {
 		title := vars["Title"]
 		description := vars["Description"]
 		tags := vars["Tags"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		show, _ := strconv.ParseBool(vars["Show"])
 		ispayperview, _ := strconv.ParseBool(vars["IsPayPerView"])
 		isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
 		ppvprice := vars["PPVPrice"]
 		price, err := strconv.ParseFloat(ppvprice, 64)
 		if err != nil {
-			fmt.Println("Invalid price in PPVPrice")
+			logger.Warn("Invalid price in PPVPrice", "ppvprice", ppvprice)
 			price = 0
 		}
 
@@ -1291,18 +853,18 @@ func PostVideo() http.HandlerFunc {
 		videoID := strings.Replace(newUuid.String(), "-", "", -1)
 
 		// Parse multipart form
+		r.Body = http.MaxBytesReader(rw, r.Body, 1024*20*MB)
 		err = r.ParseMultipartForm(1024 * 20 * MB)
 		if err != nil {
-			http.Error(rw, "Error parsing form", http.StatusBadRequest)
+			errorResponse(rw, apierrors.Validation("Error parsing form"))
 			return
 		}
-		r.Body = http.MaxBytesReader(rw, r.Body, 1024*20*MB)
 
 		// Get video file
 		file, _, err := r.FormFile("video")
 		if err != nil {
-			fmt.Println("Error getting video file:", err)
-			http.Error(rw, "Error reading video file", http.StatusBadRequest)
+			logger.Warn("Error getting video file", "error", err)
+			errorResponse(rw, apierrors.Validation("Error reading video file"))
 			return
 		}
 		defer file.Close()
@@ -1310,18 +872,18 @@ func PostVideo() http.HandlerFunc {
 		// Validate video file type
 		fileHeader := make([]byte, 512)
 		if _, err := file.Read(fileHeader); err != nil {
-			http.Error(rw, "Error reading file", http.StatusBadRequest)
+			errorResponse(rw, apierrors.Validation("Error reading file"))
 			return
 		}
 		if _, err := file.Seek(0, 0); err != nil {
-			http.Error(rw, "Error reading file", http.StatusBadRequest)
+			errorResponse(rw, apierrors.Validation("Error reading file"))
 			return
 		}
 
 		mime := http.DetectContentType(fileHeader)
 		extension := ""
 
-		fmt.Println("Video MIME type:", mime)
+		logger.Debug("Detected MIME", "mime", mime)
 		switch mime {
 		case "video/mp4":
 			extension = "mp4"
@@ -1334,30 +896,26 @@ func PostVideo() http.HandlerFunc {
 		case "video/3gp":
 			extension = "3gp"
 		default:
-			http.Error(rw, "This file type for video is not allowed: "+mime, http.StatusBadRequest)
+			errorResponse(rw, apierrors.New(apierrors.UNSUPPORTED_MEDIA_TYPE, "This file type for video is not allowed: "+mime))
 			return
 		}
 
-		uploader := configs.GetS3Uploader()
-
-		fmt.Print("UPLOADER>>", uploader)
+		if _, err := inspectUploadedVideo(ctx, r, file); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 
-		// Upload raw video to S3
+		// Upload raw video to storage
 		s3VideoKey := fmt.Sprintf("%s/%s.%s", userID, videoID, extension)
-		fmt.Printf("Uploading video to S3: s3://%s/%s\n", configs.EnvRawBucket(), s3VideoKey)
+		logger.Info("Uploading video", "bucket", configs.EnvRawBucket(), "key", s3VideoKey)
 
-		_, err = uploader.Upload(ctx, &s3.PutObjectInput{
-			Bucket:      aws.String(configs.EnvRawBucket()),
-			Key:         aws.String(s3VideoKey),
-			Body:        file,
-			ContentType: aws.String(mime),
-		})
+		s3VideoKey, err = storeDedupedVideo(ctx, s3VideoKey, file, mime)
 		if err != nil {
-			fmt.Println("Error uploading video to S3:", err)
-			http.Error(rw, "Error uploading video to S3", http.StatusInternalServerError)
+			logger.Error("Error uploading video to storage", "error", err)
+			errorResponse(rw, apierrors.Upstream("Error uploading video to storage", err))
 			return
 		}
-		fmt.Println("✅ Video uploaded to S3 successfully")
+		logger.Debug("Video uploaded")
 
 		/**
 		  insert a new metadata for video in the database
@@ -1385,22 +943,13 @@ func PostVideo() http.HandlerFunc {
 			newPostVid.Tags[i] = strings.TrimSpace(s)
 		}
 
-		fmt.Println("TEST MONGO BD INSERT")
-
 		result, err := getVideosCollection().InsertOne(ctx, newPostVid)
-		fmt.Println("MongoDB insert result:", result)
 		if err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
-			response := responses.ContentResponse{
-				Status:  http.StatusInternalServerError,
-				Message: "error",
-				Data:    map[string]interface{}{"data": err.Error()},
-			}
-			json.NewEncoder(rw).Encode(response)
+			errorResponse(rw, apierrors.Internal("failed to save video", err))
 			return
 		}
 
-		fmt.Println("TEST MONGO BD INSERT sucessss")
+		logger.Info("Video saved", "video_id", videoID, "id", result.InsertedID)
 
 		// Return success
 		rw.WriteHeader(http.StatusCreated)
@@ -1422,50 +971,9 @@ func PostText() http.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Minute)
 		defer cancel()
 		vars := mux.Vars(r)
-		userID := vars["UserID"]
-		title := vars["Title"]
-		description := vars["Description"]
-		tags := vars["Tags"]
-		visibility := vars["Visibility"]
-		show, _ := strconv.ParseBool(vars["Show"])
-		ispayperview, _ := strconv.ParseBool(vars["IsPayPerView"])
-		isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
-		ppvprice := vars["PPVPrice"]
-		postingEncoded := vars["Posting"]
-		postingDecoded, err := url.QueryUnescape(postingEncoded)
-		price, err := strconv.ParseFloat(ppvprice, 64)
-		if err != nil {
-			fmt.Println("invalid price in PPVPrice")
-			price = 0
-		}
-		if visibility != VISIBILITY_FOLLOWERS {
-			visibility = VISIBILITY_EVERYONE
-		}
-		newTextContent := models.Content{
-			UserID:       userID,
-			Poster:       userID,
-			Type:         TYPE_TEXT,
-			Title:        title,
-			Description:  description,
-			DateCreated:  time.Now(),
-			Show:         show,
-			IsPayPerView: ispayperview,
-			IsDeleted:    isdeleted,
-			PPVPrice:     price,
-			Posting:      postingDecoded,
-			Visibility:   visibility,
-		}
-		newTextContent.Tags = strings.Split(tags, ",")
-		for i, s := range newTextContent.Tags {
-			newTextContent.Tags[i] = strings.Trim(s, " ")
-		}
-		result, err := getContentCollection().InsertOne(ctx, newTextContent)
-		if err != nil {
-			fmt.Println(err)
-			errorResponse(rw, err, 200)
-			return
-		}
-		successResponse(rw, result.InsertedID)
+		doc := legacyContentDocument(vars)
+		doc.Posting, _ = url.QueryUnescape(vars["Posting"])
+		createText(ctx, rw, r, doc)
 		//go insertInREDISGetContentByUserID(userID)
 	}
 }
@@ -1474,59 +982,15 @@ func PostTextWithBody() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Minute)
 		defer cancel()
-		vars := mux.Vars(r)
-		userID := vars["UserID"]
-		tags := vars["Tags"]
-		visibility := vars["Visibility"]
-		show, _ := strconv.ParseBool(vars["Show"])
-		ispayperview, _ := strconv.ParseBool(vars["IsPayPerView"])
-		isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
-		ppvprice := vars["PPVPrice"]
-		price, err := strconv.ParseFloat(ppvprice, 64)
-		if err != nil {
-			fmt.Println("invalid price in PPVPrice")
-			price = 0
-		}
-		if visibility != VISIBILITY_FOLLOWERS {
-			visibility = VISIBILITY_EVERYONE
-		}
 		contentBody := models.ContentBody{}
 
-		err = json.NewDecoder(r.Body).Decode(&contentBody)
+		err := json.NewDecoder(r.Body).Decode(&contentBody)
 		if err != nil {
-			errorResponse(rw, fmt.Errorf("bad request"), 200)
+			errorResponse(rw, apierrors.Validation("bad request"))
 			return
 		}
 
-		title := contentBody.Title
-		description := contentBody.Description
-		postingDecoded := contentBody.Posting
-
-		newTextContent := models.Content{
-			UserID:       userID,
-			Poster:       userID,
-			Type:         TYPE_TEXT,
-			Title:        title,
-			Description:  description,
-			DateCreated:  time.Now(),
-			Show:         show,
-			IsPayPerView: ispayperview,
-			IsDeleted:    isdeleted,
-			PPVPrice:     price,
-			Posting:      postingDecoded,
-			Visibility:   visibility,
-		}
-		newTextContent.Tags = strings.Split(tags, ",")
-		for i, s := range newTextContent.Tags {
-			newTextContent.Tags[i] = strings.Trim(s, " ")
-		}
-		result, err := getContentCollection().InsertOne(ctx, newTextContent)
-		if err != nil {
-			fmt.Println(err)
-			errorResponse(rw, err, 200)
-			return
-		}
-		successResponse(rw, result.InsertedID)
+		createText(ctx, rw, r, withContentBody(legacyContentDocument(mux.Vars(r)), contentBody))
 		//go insertInREDISGetContentByUserID(userID)
 	}
 }
@@ -1536,414 +1000,187 @@ func PostVideoNT() http.HandlerFunc {
 
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Minute)
 		defer cancel()
-		vars := mux.Vars(r)
-		userID := vars["UserID"]
-		title := vars["Title"]
-		visibility := vars["Visibility"]
-		description := vars["Description"]
-		tags := vars["Tags"]
-		show, _ := strconv.ParseBool(vars["Show"])
-		ispayperview, _ := strconv.ParseBool(vars["IsPayPerView"])
-		isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
+		logger := configs.LogWithRequest(r.Context(), "content", "post-video")
+		doc := legacyContentDocument(mux.Vars(r))
+		userID := doc.UserID
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		extension := ""
 		ffmpegSource := ""
 		ffmpegTarget := ""
 		newUuid := uuid.New()
-		ppvprice := vars["PPVPrice"]
-		price, err := strconv.ParseFloat(ppvprice, 64)
-		if err != nil {
-			fmt.Println("invalid price in PPVPrice")
-			price = 0
-		}
-		if visibility != VISIBILITY_FOLLOWERS {
-			visibility = VISIBILITY_EVERYONE
-		}
-		newPostVid := models.Content{
-			UserID:       userID,
-			Poster:       userID,
-			Title:        title,
-			Description:  description,
-			Location:     configs.EnvMediaDir() + "/" + userID + "/videos/",
-			DateCreated:  time.Now(),
-			Show:         show,
-			IsPayPerView: ispayperview,
-			IsDeleted:    isdeleted,
-			PPVPrice:     price,
-			Type:         TYPE_VIDEO,
-			Posting:      "",
-			Visibility:   visibility,
-			Transcoding:  TRANSCODING_PENDING,
-		}
-		newPostVid.Tags = strings.Split(tags, ",")
-		for i, s := range newPostVid.Tags {
-			newPostVid.Tags[i] = strings.Trim(s, " ")
-		}
-		fmt.Println("Creating folder ..." + newPostVid.Location)
+		newPostVid := newContentFromDocument(doc, TYPE_VIDEO)
+		newPostVid.Location = configs.EnvMediaDir() + "/" + userID + "/videos/"
+		newPostVid.Transcoding = TRANSCODING_PENDING
+		logger.Debug("Creating folder", "path", newPostVid.Location)
 		var res = os.MkdirAll(newPostVid.Location, 0777)
 		if res != nil {
-			fmt.Println(res)
+			logger.Warn("Failed to create folder", "error", res)
 		}
-		err = os.Chmod(configs.EnvMediaDir()+"/"+userID, 0666)
+		err := os.Chmod(configs.EnvMediaDir()+"/"+userID, 0666)
 		if err != nil {
-			fmt.Println(err)
+			logger.Warn("Failed to set folder permissions", "error", err)
 		}
 
 		err = os.Chmod(configs.EnvMediaDir()+"/"+userID+"/videos", 0666)
 		if err != nil {
-			fmt.Println(err)
+			logger.Warn("Failed to set folder permissions", "error", err)
 		}
 		var res1 = os.MkdirAll(newPostVid.Location+strings.Replace(newUuid.String(), "-", "", -1), 0777)
-		fmt.Println("Creating folder ..." + newPostVid.Location + strings.Replace(newUuid.String(), "-", "", -1))
+		logger.Debug("Creating folder", "path", newPostVid.Location+strings.Replace(newUuid.String(), "-", "", -1))
 		if res1 != nil {
-			fmt.Println(res1)
+			logger.Warn("Failed to create folder", "error", res1)
 		}
 		err = os.Chmod(newPostVid.Location+strings.Replace(newUuid.String(), "-", "", -1), 0666)
 		if err != nil {
-			fmt.Println(err)
+			logger.Warn("Failed to set folder permissions", "error", err)
 		}
 
-		r.ParseMultipartForm(1024 * 20 * MB)
 		r.Body = http.MaxBytesReader(rw, r.Body, 1024*20*MB)
-		file, fheader, err := r.FormFile("video")
-		if err != nil {
-			fmt.Println(err)
-		}
-
-		fileHeader := make([]byte, 512)
-		if _, err := file.Read(fileHeader); err != nil {
-			return
-		}
-		if _, err := file.Seek(0, 0); err != nil {
-			return
-		}
-
-		mime := http.DetectContentType(fileHeader)
-
-		fmt.Println(mime)
-		switch mime {
-		case "video/mp4":
-			extension = "mp4"
-		case "video/mkv":
-			extension = "mkv"
-		case "video/avi":
-			extension = "avi"
-		case "video/3gp":
-			extension = "3gp"
-		case "video/mov":
-			extension = "mov"
-		case "video/hevc", "video/h265":
-			extension = "hevc"
-		case "application/octet-stream":
-			// MIME sniffer couldn’t decide – trust the filename
-			ext := strings.ToLower(filepath.Ext(fheader.Filename)) // “.mp4”, “.h265”, …
-			switch ext {
-			case ".mp4", ".mkv", ".avi", ".3gp", ".mov",
-				".hevc", ".h265", ".265":
-				extension = ext[1:] // strip the leading “.”
-			default:
-				http.Error(rw, "This file type for video is not allowed.", http.StatusBadRequest)
-				return
-			}
-
-		default:
-			http.Error(rw, "This file type for video is not allowed.", http.StatusBadRequest)
-			return
-		}
-
-		ffmpegSource = newPostVid.Location + strings.Replace(newUuid.String(), "-", "", -1) + "." + extension
-		ffmpegTarget = newPostVid.Location + strings.Replace(newUuid.String(), "-", "", -1)
-		fmt.Println(ffmpegSource, ffmpegTarget)
-
-		defer file.Close()
-
-		f, err := os.OpenFile(configs.INITMEDIADIR()+userID+"-"+strings.Replace(newUuid.String(), "-", "", -1)+"."+extension, os.O_WRONLY|os.O_CREATE, 0666)
-		if err != nil {
-			fmt.Println("Can't create a file for video at....")
-			fmt.Println("----" + newPostVid.Location + strings.Replace(newUuid.String(), "-", "", -1) + "." + extension + "----")
-			fmt.Println(err)
-		}
-		io.Copy(f, file)
-		defer f.Close()
-
-		newPostVid.Location = newPostVid.Location + strings.Replace(newUuid.String(), "-", "", -1) + "/"
-		result, err := getContentCollection().InsertOne(ctx, newPostVid)
-		fmt.Println(result)
-		if err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
-			response := responses.ContentResponse{Status: http.StatusInternalServerError, Message: "error", Data: map[string]interface{}{"data": err.Error()}}
-			json.NewEncoder(rw).Encode(response)
-			return
-		}
-		rw.WriteHeader(http.StatusCreated)
-		response := responses.ContentResponse{Status: http.StatusCreated, Message: "success", Data: map[string]interface{}{"data": result}}
-		json.NewEncoder(rw).Encode(response)
-		//go insertInREDISGetContentByUserID(userID)
-
-	}
-}
-
-func PostVideoNTWithBody() http.HandlerFunc {
-	return func(rw http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Minute)
-		defer cancel()
-
-		vars := mux.Vars(r)
-		userID := vars["UserID"]
-		visibility := vars["Visibility"]
-		tags := vars["Tags"]
-		show, _ := strconv.ParseBool(vars["Show"])
-		ispayperview, _ := strconv.ParseBool(vars["IsPayPerView"])
-		isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
-		ppvprice := vars["PPVPrice"]
-		price, err := strconv.ParseFloat(ppvprice, 64)
-		if err != nil {
-			price = 0
-		}
-
-		if visibility != VISIBILITY_FOLLOWERS {
-			visibility = VISIBILITY_EVERYONE
-		}
-
-		jsonData := r.FormValue("data")
-		if jsonData == "" {
-			errorResponse(rw, fmt.Errorf("missing data"), 400)
-			return
-		}
-
-		var contentBody models.ContentBody
-		if err := json.Unmarshal([]byte(jsonData), &contentBody); err != nil {
-			errorResponse(rw, err, 400)
-			return
-		}
-
-		title := contentBody.Title
-		description := contentBody.Description
-
-		// Generate unique video ID
-		videoID := strings.Replace(uuid.New().String(), "-", "", -1)
-
-		// Parse multipart form
 		r.ParseMultipartForm(1024 * 20 * MB)
-		r.Body = http.MaxBytesReader(rw, r.Body, 1024*20*MB)
-
 		file, fheader, err := r.FormFile("video")
 		if err != nil {
-			errorResponse(rw, fmt.Errorf("error reading video file"), 400)
+			logger.Warn("Error getting video file", "error", err)
+			errorResponse(rw, apierrors.Validation("Error reading video file"))
 			return
 		}
-		defer file.Close()
 
 		fileHeader := make([]byte, 512)
-		file.Read(fileHeader)
-		file.Seek(0, 0)
+		if _, err := file.Read(fileHeader); err != nil {
+			return
+		}
+		if _, err := file.Seek(0, 0); err != nil {
+			return
+		}
 
 		mime := http.DetectContentType(fileHeader)
-		extension := ""
 
+		logger.Debug("Detected MIME", "mime", mime)
 		switch mime {
 		case "video/mp4":
 			extension = "mp4"
-		case "video/quicktime":
-			extension = "mov"
-		case "video/x-msvideo":
-			extension = "avi"
-		case "video/x-matroska":
+		case "video/mkv":
 			extension = "mkv"
-		case "video/3gpp":
+		case "video/avi":
+			extension = "avi"
+		case "video/3gp":
 			extension = "3gp"
+		case "video/mov":
+			extension = "mov"
 		case "video/hevc", "video/h265":
 			extension = "hevc"
 		case "application/octet-stream":
-			ext := strings.ToLower(filepath.Ext(fheader.Filename))
+			// MIME sniffer couldn’t decide – trust the filename
+			ext := strings.ToLower(filepath.Ext(fheader.Filename)) // “.mp4”, “.h265”, …
 			switch ext {
-			case ".mp4", ".mkv", ".avi", ".3gp", ".mov", ".hevc", ".h265", ".265":
-				extension = ext[1:]
+			case ".mp4", ".mkv", ".avi", ".3gp", ".mov",
+				".hevc", ".h265", ".265":
+				extension = ext[1:] // strip the leading “.”
 			default:
-				http.Error(rw, "Invalid video file type", http.StatusBadRequest)
+				errorResponse(rw, apierrors.New(apierrors.UNSUPPORTED_MEDIA_TYPE, "This file type for video is not allowed."))
 				return
 			}
+
 		default:
-			http.Error(rw, "Invalid video file type", http.StatusBadRequest)
+			errorResponse(rw, apierrors.New(apierrors.UNSUPPORTED_MEDIA_TYPE, "This file type for video is not allowed."))
 			return
 		}
 
-		uploader := configs.GetS3Uploader()
-		s3VideoKey := fmt.Sprintf("%s/%s.%s", userID, videoID, extension)
-
-		fmt.Printf("Uploading video to S3: s3://%s/%s\n", configs.EnvRawBucket(), s3VideoKey)
-
-		_, err = uploader.Upload(ctx, &s3.PutObjectInput{
-			Bucket:      aws.String(configs.EnvRawBucket()),
-			Key:         aws.String(s3VideoKey),
-			Body:        file,
-			ContentType: aws.String(mime),
-		})
+		mediaInfo, err := inspectUploadedVideo(ctx, r, file)
 		if err != nil {
-			fmt.Println("Error uploading to S3:", err)
-			errorResponse(rw, fmt.Errorf("error uploading video"), 500)
+			errorResponse(rw, err)
 			return
 		}
-		fmt.Println("Video uploaded to S3")
+		newPostVid.MediaInfo = mediaInfo
 
-		newPostVid := models.Content{
-			VideoID:      videoID,
-			UserID:       userID,
-			Poster:       userID,
-			Title:        title,
-			Description:  description,
-			S3RawKey:     s3VideoKey,
-			ThumbnailKey: "",
-			HLSURL:       "",
-			DateCreated:  time.Now(),
-			Show:         show,
-			IsPayPerView: ispayperview,
-			IsDeleted:    isdeleted,
-			PPVPrice:     price,
-			Type:         TYPE_VIDEO,
-			Posting:      "",
-			Visibility:   visibility,
-			Transcoding:  TRANSCODING_PENDING,
-		}
+		ffmpegSource = newPostVid.Location + strings.Replace(newUuid.String(), "-", "", -1) + "." + extension
+		ffmpegTarget = newPostVid.Location + strings.Replace(newUuid.String(), "-", "", -1)
+		logger.Debug("Transcoding paths", "source", ffmpegSource, "target", ffmpegTarget)
 
-		newPostVid.Tags = strings.Split(tags, ",")
-		for i, s := range newPostVid.Tags {
-			newPostVid.Tags[i] = strings.TrimSpace(s)
+		defer file.Close()
+
+		f, err := os.OpenFile(configs.INITMEDIADIR()+userID+"-"+strings.Replace(newUuid.String(), "-", "", -1)+"."+extension, os.O_WRONLY|os.O_CREATE, 0666)
+		if err != nil {
+			logger.Error("Can't create a file for video", "error", err)
+			errorResponse(rw, apierrors.Internal("failed to store video", err))
+			return
 		}
+		io.Copy(f, file)
+		defer f.Close()
 
+		newPostVid.Location = newPostVid.Location + strings.Replace(newUuid.String(), "-", "", -1) + "/"
 		result, err := getContentCollection().InsertOne(ctx, newPostVid)
 		if err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
-			response := responses.ContentResponse{
-				Status:  http.StatusInternalServerError,
-				Message: "error",
-				Data:    map[string]interface{}{"data": err.Error()},
-			}
-			json.NewEncoder(rw).Encode(response)
+			errorResponse(rw, apierrors.Internal("failed to save content", err))
 			return
 		}
-
-		fmt.Print(result)
-
 		rw.WriteHeader(http.StatusCreated)
-		response := responses.ContentResponse{
-			Status:  http.StatusCreated,
-			Message: "success",
-			Data: map[string]interface{}{
-				"video_id": videoID,
-				"status":   "processing",
-			},
-		}
+		response := responses.ContentResponse{Status: http.StatusCreated, Message: "success", Data: map[string]interface{}{"data": result}}
 		json.NewEncoder(rw).Encode(response)
+		//go insertInREDISGetContentByUserID(userID)
+
 	}
 }
 
-func StartStream() http.HandlerFunc {
+func PostVideoNTWithBody() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Minute)
 		defer cancel()
-		vars := mux.Vars(r)
-		userID := vars["UserID"]
-		title := vars["Title"]
-		visibility := vars["Visibility"]
-		description := vars["Description"]
-		tags := vars["Tags"]
-		show, _ := strconv.ParseBool(vars["Show"])
-		ispayperview, _ := strconv.ParseBool(vars["IsPayPerView"])
-		isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
 
-		ppvprice := vars["PPVPrice"]
-		price, err := strconv.ParseFloat(ppvprice, 64)
-		if err != nil {
-			fmt.Println("invalid price in PPVPrice")
-			price = 0
-		}
-
-		if visibility != VISIBILITY_FOLLOWERS {
-			visibility = VISIBILITY_EVERYONE
+		jsonData := r.FormValue("data")
+		if jsonData == "" {
+			errorResponse(rw, apierrors.Validation("missing data"))
+			return
 		}
 
-		// Generate unique stream key
-		streamKey := strings.Replace(uuid.New().String(), "-", "", -1)
-
-		// Streaming server IP (replace with your actual IP or use env variable)
-		streamingServerIP := "13.50.17.68" // TODO: Move to configs.EnvStreamingServer()
-
-		newStream := models.Content{
-			UserID:       userID,
-			Poster:       userID,
-			Title:        title,
-			Description:  description,
-			Location:     configs.EnvMediaDir() + "/" + userID + "/videos/",
-			DateCreated:  time.Now(),
-			Show:         show,
-			IsPayPerView: ispayperview,
-			IsDeleted:    isdeleted,
-			PPVPrice:     price,
-			Type:         TYPE_STREAM,
-			Posting:      "",
-			Visibility:   visibility,
-
-			// Live streaming fields
-			StreamKey:   streamKey,
-			RTMPUrl:     fmt.Sprintf("rtmp://%s/live/%s", streamingServerIP, streamKey),
-			HLSURL:      fmt.Sprintf("http://%s/hls/%s/index.m3u8", streamingServerIP, streamKey),
-			IsLive:      false, // Will be set to true when streaming actually starts
-			ViewerCount: 0,
+		var contentBody models.ContentBody
+		if err := json.Unmarshal([]byte(jsonData), &contentBody); err != nil {
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
+			return
 		}
 
-		newStream.Tags = strings.Split(tags, ",")
-		for i, s := range newStream.Tags {
-			newStream.Tags[i] = strings.Trim(s, " ")
-		}
+		doc := withContentBody(legacyContentDocument(mux.Vars(r)), contentBody)
 
-		result, err := getContentCollection().InsertOne(ctx, newStream)
-		if err != nil {
-			rw.WriteHeader(http.StatusConflict)
-			errresponse := responses.ContentResponse{Status: http.StatusConflict, Message: "error"}
-			json.NewEncoder(rw).Encode(errresponse)
-			return
-		}
+		// Parse multipart form
+		r.Body = http.MaxBytesReader(rw, r.Body, 1024*20*MB)
+		r.ParseMultipartForm(1024 * 20 * MB)
 
-		go func() {
-			// THE NGINX PUBLISHER WILL SEND NOTIFICATION NOT THIS GUY @CHECK
-			// time.Sleep(30 * time.Second)
-			// contentID := result.InsertedID.(primitive.ObjectID).Hex()
-			// sendLiveStartedNotification(userID, contentID)
-		}()
+		createVideo(ctx, rw, r, doc)
+	}
+}
 
-		rw.WriteHeader(http.StatusCreated)
-		response := responses.ContentResponse{
-			Status:  http.StatusCreated,
-			Message: "success",
-			Data: map[string]interface{}{
-				"content_id": result.InsertedID,
-				"stream_key": streamKey,
-				"rtmp_url":   newStream.RTMPUrl,
-				"hls_url":    newStream.HLSURL,
-			},
-		}
-		json.NewEncoder(rw).Encode(response)
+func StartStream() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Minute)
+		defer cancel()
+		createStream(ctx, rw, r, legacyContentDocument(mux.Vars(r)))
 	}
 }
 
 // KNOW BASED ON on_publish event from rtmp nginx when live stream started
 func HandleStreamPublish() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
+		if err := requireService(r, auth.SCOPE_STREAMS); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		if err := r.ParseForm(); err != nil {
-			http.Error(rw, "invalid form", http.StatusBadRequest)
+			errorResponse(rw, apierrors.Validation("invalid form"))
 			return
 		}
 
 		streamKey := r.FormValue("name")
 
 		if streamKey == "" {
-			http.Error(rw, "missing stream key", http.StatusBadRequest)
+			errorResponse(rw, apierrors.Validation("missing stream key"))
 			return
 		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
+		logger := configs.LogWithRequest(r.Context(), "streams", "stream-publish")
 
 		var stream models.Content
 		err := getContentCollection().FindOne(ctx, bson.M{
@@ -1952,7 +1189,7 @@ func HandleStreamPublish() http.HandlerFunc {
 		}).Decode(&stream)
 
 		if err != nil {
-			http.Error(rw, "unauthorized", http.StatusNotFound)
+			errorResponse(rw, apierrors.NotFound("unauthorized"))
 			return
 		}
 
@@ -1971,18 +1208,18 @@ func HandleStreamPublish() http.HandlerFunc {
 			contentID := stream.Id.Hex()
 			hlsURL := fmt.Sprintf("http://13.50.17.68/hls/%s/index.m3u8", streamKey)
 
-			fmt.Printf("Checking HLS availability: %s\n", hlsURL)
+			logger.Debug("Checking HLS availability", "url", hlsURL)
 
 			if waitForHLSViaHTTP(hlsURL, 30*time.Second) {
-				fmt.Printf(" HLS ready! Sending notifications for %s\n", streamKey)
-				sendLiveStartedNotification(stream.UserID, contentID)
+				logger.Info("HLS ready, sending notifications", "stream_key", streamKey)
+				sendLiveStartedNotification(r.Context(), stream.UserID, contentID)
 			} else {
-				fmt.Printf(" HLS timeout, sending notifications anywayyy for %s\n", streamKey)
-				sendLiveStartedNotification(stream.UserID, contentID)
+				logger.Warn("HLS timeout, sending notifications anyway", "stream_key", streamKey)
+				sendLiveStartedNotification(r.Context(), stream.UserID, contentID)
 			}
 		}()
 
-		fmt.Println(stream.UserID + " IS NOW LIVEE ")
+		logger.Info("Stream is live", "user_id", stream.UserID)
 
 		rw.Header().Set("Content-Type", "application/json")
 		rw.WriteHeader(http.StatusOK)
@@ -1995,19 +1232,24 @@ func HandleStreamPublish() http.HandlerFunc {
 
 func HandleStreamPublishDone() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
+		if err := requireService(r, auth.SCOPE_STREAMS); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		if err := r.ParseForm(); err != nil {
-			http.Error(rw, "invalid form", http.StatusBadRequest)
+			errorResponse(rw, apierrors.Validation("invalid form"))
 			return
 		}
 
 		streamKey := r.FormValue("name")
+		logger := configs.LogWithRequest(r.Context(), "streams", "stream-publish-done")
 
 		if streamKey == "" {
-			http.Error(rw, "missing stream key", http.StatusBadRequest)
+			errorResponse(rw, apierrors.Validation("missing stream key"))
 			return
 		}
 
-		fmt.Printf("Stream ENDED: %s\n", streamKey)
+		logger.Info("Stream ended", "stream_key", streamKey)
 
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
@@ -2019,7 +1261,7 @@ func HandleStreamPublishDone() http.HandlerFunc {
 		}).Decode(&stream)
 
 		if err != nil {
-			fmt.Printf("Stream not found: %s\n", streamKey)
+			logger.Warn("Stream not found", "stream_key", streamKey)
 			rw.WriteHeader(http.StatusOK)
 			return
 		}
@@ -2054,16 +1296,17 @@ func HandleStreamPublishDone() http.HandlerFunc {
 		)
 
 		if err != nil {
-			fmt.Println("Error updating stream:", err)
+			logger.Error("Error updating stream", "error", err)
 		}
 
-		fmt.Printf("Stream finalized. Recording at: %s\n", recordingURL)
+		logger.Info("Stream finalized", "recording_url", recordingURL)
 
 		rw.WriteHeader(http.StatusOK)
 	}
 }
 
 func waitForHLSViaHTTP(hlsURL string, maxWait time.Duration) bool {
+	logger := configs.LogWithContext("streams", "wait-for-hls")
 	client := &http.Client{
 		Timeout: 3 * time.Second,
 	}
@@ -2091,15 +1334,11 @@ func waitForHLSViaHTTP(hlsURL string, maxWait time.Duration) bool {
 				segmentCount := strings.Count(playlist, ".ts")
 
 				if segmentCount >= minSegments {
-					fmt.Printf(" HLS ready after %.1fs (%d attempts, %d segments)\n",
-						time.Since(deadline.Add(-maxWait)).Seconds(),
-						attemptCount,
-						segmentCount)
+					logger.Debug("HLS ready", "after", time.Since(deadline.Add(-maxWait)), "attempts", attemptCount, "segments", segmentCount)
 					return true
 				}
 
-				fmt.Printf(" Attempt %d: %d/%d segments (waiting %v)\n",
-					attemptCount, segmentCount, minSegments, waitDuration)
+				logger.Debug("HLS not ready", "attempt", attemptCount, "segments", segmentCount, "wanted", minSegments, "wait", waitDuration)
 			}
 		} else if resp != nil {
 			resp.Body.Close()
@@ -2115,19 +1354,22 @@ func waitForHLSViaHTTP(hlsURL string, maxWait time.Duration) bool {
 		}
 	}
 
-	fmt.Printf("HLS timeout after %.1fs (%d attempts)\n",
-		maxWait.Seconds(), attemptCount)
+	logger.Warn("HLS timeout", "after", maxWait, "attempts", attemptCount)
 	return false
 }
 
 func GetStreamUserID() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
+		if err := requireService(r, auth.SCOPE_STREAMS); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
 		streamKey := r.URL.Query().Get("stream_key")
 		if streamKey == "" {
-			http.Error(rw, "stream_key required", http.StatusBadRequest)
+			errorResponse(rw, apierrors.Validation("stream_key required"))
 			return
 		}
 
@@ -2138,7 +1380,7 @@ func GetStreamUserID() http.HandlerFunc {
 		}).Decode(&stream)
 
 		if err != nil {
-			http.Error(rw, "stream not found", http.StatusNotFound)
+			errorResponse(rw, apierrors.NotFound("stream not found"))
 			return
 		}
 
@@ -2157,6 +1399,10 @@ func EndView() http.HandlerFunc {
 		vars := mux.Vars(r)
 		media := vars["MediaID"]
 		viewerid := vars["ViewerID"]
+		if err := authorizeUser(r, viewerid); err != nil {
+			errorResponse(w, err)
+			return
+		}
 
 		ctx := context.Background()
 
@@ -2177,24 +1423,27 @@ func StartView() http.HandlerFunc {
 		vars := mux.Vars(r)
 		media := vars["MediaID"]
 		viewerid := vars["ViewerID"]
+		if err := authorizeUser(r, viewerid); err != nil {
+			errorResponse(w, err)
+			return
+		}
 
 		ctx := context.Background()
 
 		// Check if it's a live stream
 		objID, err := primitive.ObjectIDFromHex(media)
 		if err != nil {
-			errorResponse(w, err, 400)
+			errorResponse(w, apierrors.Wrap(apierrors.VALIDATION, err))
 			return
 		}
 
 		var content models.Content
 		err = getContentCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&content)
 		if err != nil {
-			errorResponse(w, err, 404)
+			errorResponse(w, apierrors.Wrap(apierrors.NOT_FOUND, err))
 			return
 		}
 
-		fmt.Println("content", content)
 
 		// Only track if it's a live stream
 		if content.Type == TYPE_STREAM && content.IsLive {
@@ -2205,14 +1454,14 @@ func StartView() http.HandlerFunc {
 			// Update viewer count
 			go updateLiveViewerCount(media)
 
-			fmt.Println("JOINEDDDDDDDDDDD")
+			configs.LogWithRequest(r.Context(), "streams", "start-view").Debug("Viewer joined stream", "content_id", media, "viewer_id", viewerid)
 
 			successResponse(w, map[string]interface{}{
 				"message":      "viewing live stream",
 				"viewer_count": content.ViewerCount,
 			})
 		} else {
-			errorResponse(w, fmt.Errorf("not a live stream"), 400)
+			errorResponse(w, apierrors.Validation("not a live stream"))
 		}
 	}
 }
@@ -2230,7 +1479,7 @@ func updateLiveViewerCount(contentID string) {
 	pattern := fmt.Sprintf("stream:%s:viewer:*", contentID)
 	keys, err := configs.GetRedisClient().Keys(ctx, pattern).Result()
 	if err != nil {
-		fmt.Println("Error counting viewers:", err)
+		configs.LogWithContext("streams", "viewer-count").Warn("Error counting viewers", "error", err)
 		return
 	}
 
@@ -2243,7 +1492,7 @@ func updateLiveViewerCount(contentID string) {
 		bson.M{"$set": bson.M{"viewer_count": viewerCount}},
 	)
 	if err != nil {
-		fmt.Println("Error updating viewer count:", err)
+		configs.LogWithContext("streams", "viewer-count").Warn("Error updating viewer count", "error", err)
 	}
 }
 
@@ -2252,6 +1501,10 @@ func ViewHeartbeat() http.HandlerFunc {
 		vars := mux.Vars(r)
 		media := vars["MediaID"]
 		viewerid := vars["ViewerID"]
+		if err := authorizeUser(r, viewerid); err != nil {
+			errorResponse(w, err)
+			return
+		}
 
 		ctx := context.Background()
 		redisKey := fmt.Sprintf("stream:%s:viewer:%s", media, viewerid)
@@ -2259,7 +1512,7 @@ func ViewHeartbeat() http.HandlerFunc {
 		// Refresh expiry to 30 fucking seconds
 		err := configs.GetRedisClient().Expire(ctx, redisKey, 30*time.Second).Err()
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			errorResponse(w, apierrors.Upstream("failed to refresh viewer", err))
 			return
 		}
 
@@ -2269,123 +1522,47 @@ func ViewHeartbeat() http.HandlerFunc {
 
 func StartStreamWithBody() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Minute)
 		defer cancel()
-		vars := mux.Vars(r)
-		userID := vars["UserID"]
-		visibility := vars["Visibility"]
-		tags := vars["Tags"]
-		show, _ := strconv.ParseBool(vars["Show"])
-		ispayperview, _ := strconv.ParseBool(vars["IsPayPerView"])
-		isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
-
-		ppvprice := vars["PPVPrice"]
-		price, err := strconv.ParseFloat(ppvprice, 64)
-		if err != nil {
-			fmt.Println("invalid price in PPVPrice")
-			price = 0
-		}
-
-		if visibility != VISIBILITY_FOLLOWERS {
-			visibility = VISIBILITY_EVERYONE
-		}
 
 		contentBody := models.ContentBody{}
-		err = json.NewDecoder(r.Body).Decode(&contentBody)
-		if err != nil {
-			errorResponse(rw, fmt.Errorf("bad request"), 200)
-			return
-		}
-
-		title := contentBody.Title
-		description := contentBody.Description
-
-		// Generate unique stream key
-		streamKey := strings.Replace(uuid.New().String(), "-", "", -1)
-
-		// Streaming server IP
-		streamingServerIP := "13.50.17.68" // TODO: Move to config
-
-		newStream := models.Content{
-			UserID:       userID,
-			Poster:       userID,
-			Title:        title,
-			Description:  description,
-			Location:     configs.EnvMediaDir() + "/" + userID + "/videos/",
-			DateCreated:  time.Now(),
-			Show:         show,
-			IsPayPerView: ispayperview,
-			IsDeleted:    isdeleted,
-			PPVPrice:     price,
-			Type:         TYPE_STREAM,
-			Posting:      "",
-			Visibility:   visibility,
-
-			// Live streaming fields
-			StreamKey:   streamKey,
-			RTMPUrl:     fmt.Sprintf("rtmp://%s/live/%s", streamingServerIP, streamKey),
-			HLSURL:      fmt.Sprintf("http://%s/hls/%s.m3u8", streamingServerIP, streamKey),
-			IsLive:      false,
-			ViewerCount: 0,
-		}
-
-		newStream.Tags = strings.Split(tags, ",")
-		for i, s := range newStream.Tags {
-			newStream.Tags[i] = strings.Trim(s, " ")
-		}
-
-		result, err := getContentCollection().InsertOne(ctx, newStream)
+		err := json.NewDecoder(r.Body).Decode(&contentBody)
 		if err != nil {
-			rw.WriteHeader(http.StatusConflict)
-			errresponse := responses.ContentResponse{Status: http.StatusConflict, Message: "error"}
-			json.NewEncoder(rw).Encode(errresponse)
+			errorResponse(rw, apierrors.Validation("bad request"))
 			return
 		}
 
-		go func() {
-			// time.Sleep(10 * time.Second)
-			// contentID := result.InsertedID.(primitive.ObjectID).Hex()
-			// sendLiveStartedNotification(userID, contentID)
-		}()
-
-		rw.WriteHeader(http.StatusCreated)
-		response := responses.ContentResponse{
-			Status:  http.StatusCreated,
-			Message: "success",
-			Data: map[string]interface{}{
-				"content_id": result.InsertedID,
-				"stream_key": streamKey,
-				"rtmp_url":   newStream.RTMPUrl,
-				"hls_url":    newStream.HLSURL,
-			},
-		}
-		json.NewEncoder(rw).Encode(response)
+		createStream(ctx, rw, r, withContentBody(legacyContentDocument(mux.Vars(r)), contentBody))
 	}
 }
 
 func SetInitialVisibility() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 
+		if err := requireService(r, auth.SCOPE_PROCESSING); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
+		logger := configs.LogWithRequest(r.Context(), "content", "set-initial-visibility")
 		cur, err := getContentCollection().Find(ctx, bson.M{})
 		if err != nil {
-			errorResponse(rw, err, 200)
+			errorResponse(rw, err)
 			return
 		}
 		content := []models.Content{}
 		if err = cur.All(ctx, &content); err != nil {
-			errorResponse(rw, err, 200)
+			errorResponse(rw, err)
 			return
 		}
 		for k, v := range content {
 			res, err := getContentCollection().UpdateOne(ctx, bson.M{"_id": v.Id}, bson.M{"$set": bson.M{"visibility": VISIBILITY_EVERYONE}})
 			if err != nil {
-				fmt.Println("COULDN'T UPDATE INDEX %v", k)
+				logger.Warn("Couldn't update visibility", "index", k, "error", err)
 				continue
 			}
-			fmt.Println("index: %v has been modified: %v", k, res.ModifiedCount)
+			logger.Debug("Visibility updated", "index", k, "modified", res.ModifiedCount)
 		}
 		successResponse(rw, "OK")
 	}
@@ -2393,13 +1570,17 @@ func SetInitialVisibility() http.HandlerFunc {
 
 func SetTranscodingStatus() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if err := requireService(r, auth.SCOPE_PROCESSING); err != nil {
+			errorResponse(w, err)
+			return
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 		filter := bson.M{"type": "video"}
 		update := bson.M{"$set": bson.M{"transcoding": TRANSCODING_DONE}}
 		res, err := getContentCollection().UpdateMany(ctx, filter, update)
 		if err != nil {
-			errorResponse(w, err, 500)
+			errorResponse(w, err)
 			return
 		}
 		successResponse(w, res)
@@ -2415,22 +1596,33 @@ func DeleteContent() http.HandlerFunc {
 
 		oID, err := primitive.ObjectIDFromHex(contentID)
 		if err != nil {
-			errorResponse(rw, fmt.Errorf("invalid content ID"), 400)
+			errorResponse(rw, apierrors.Validation("invalid content ID"))
+			return
+		}
+
+		content := models.Content{}
+		if err := getContentCollection().FindOne(ctx, bson.M{"_id": oID}).Decode(&content); err != nil {
+			errorResponse(rw, apierrors.NotFound("content not found"))
+			return
+		}
+		if err := authorize(r, ACTION_DELETE_CONTENT, contentID, ownerPolicy(postedBy(&content)), adminPolicy); err != nil {
+			errorResponse(rw, err)
 			return
 		}
 
-		// Soft delete by setting isdeleted to true
+		// Soft delete by setting isdeleted to true; the record and its media
+		// are purged later, see PurgeDeletedContent
 		filter := bson.M{"_id": oID}
-		update := bson.M{"$set": bson.M{"isdeleted": true}}
+		update := bson.M{"$set": bson.M{"isdeleted": true, "datedeleted": time.Now()}}
 
 		result, err := getContentCollection().UpdateOne(ctx, filter, update)
 		if err != nil {
-			errorResponse(rw, fmt.Errorf("failed to delete content"), 500)
+			errorResponse(rw, apierrors.Internal("failed to delete content", err))
 			return
 		}
 
 		if result.ModifiedCount == 0 {
-			errorResponse(rw, fmt.Errorf("content not found"), 404)
+			errorResponse(rw, apierrors.NotFound("content not found"))
 			return
 		}
 
@@ -2452,12 +1644,17 @@ type FailedFile struct {
 
 func UploadMultipleFiles() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
+		if err := requireService(r, auth.SCOPE_PROCESSING); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Minute)
 		defer cancel()
+		logger := configs.LogWithRequest(r.Context(), "content", "upload-transcoded")
 
 		// Parse multipart form
 		if err := r.ParseMultipartForm(1024 * 100 * MB); err != nil {
-			errorResponse(rw, fmt.Errorf("error parsing form: %v", err), 400)
+			errorResponse(rw, apierrors.Validation(fmt.Sprintf("error parsing form: %v", err)))
 			return
 		}
 
@@ -2465,42 +1662,77 @@ func UploadMultipleFiles() http.HandlerFunc {
 		videoID := r.FormValue("video_id")
 
 		if userID == "" || videoID == "" {
-			errorResponse(rw, fmt.Errorf("missing user_id or video_id"), 400)
+			errorResponse(rw, apierrors.Validation("missing user_id or video_id"))
 			return
 		}
 
-		fmt.Printf("User ID: %s\n", userID)
-		fmt.Printf("Video ID: %s\n", videoID)
-		fmt.Println("=================================")
+		logger.Info("Receiving transcoded files", "user_id", userID, "video_id", videoID)
 
 		// Get all files
 		files := r.MultipartForm.File["files"]
 		
 		if len(files) == 0 {
-			errorResponse(rw, fmt.Errorf("no files uploaded"), 400)
+			errorResponse(rw, apierrors.Validation("no files uploaded"))
 			return
 		}
 
-		fmt.Printf("Total files received: %d\n", len(files))
-		fmt.Println("=================================")
+		logger.Info("Files received", "count", len(files))
+
+		store := storage.Backend()
 
-		// Get S3 uploader
-		uploader := configs.GetS3Uploader()
-		
 		var uploadedFiles []UploadedFile
 		var failedFiles []FailedFile
-		var playlistKey string
-		var thumbnailKey string
+		playlists := map[string][]byte{}
+		segments := map[string]bool{}
+		var thumbnail *multipart.FileHeader
 		var thumbnailURL string
+		var placeholder *media.Placeholder
+		var hashes *media.PerceptualHashes
+
+		// Read the playlists first: the ladder is checked before anything is
+		// published to the processed bucket
+		for _, fileHeader := range files {
+			fileExt := strings.ToLower(filepath.Ext(fileHeader.Filename))
+			switch {
+			case isImageFile(fileExt):
+				logger.Debug("Detected thumbnail image", "filename", fileHeader.Filename)
+				thumbnail = fileHeader
+			case strings.HasSuffix(fileHeader.Filename, ".m3u8"):
+				playlist, err := readFormFile(fileHeader)
+				if err != nil {
+					logger.Warn("Failed to read playlist", "filename", fileHeader.Filename, "error", err)
+					failedFiles = append(failedFiles, FailedFile{
+						Filename: fileHeader.Filename,
+						Error:    err.Error(),
+					})
+					continue
+				}
+				playlists[fileHeader.Filename] = playlist
+			default:
+				segments[fileHeader.Filename] = true
+			}
+		}
+		ladder, ladderErr := transcode.CheckLadder(playlists, segments)
 
-		// Upload each file to S3
+		// Upload each file to the processed bucket, unless the ladder is broken
 		for i, fileHeader := range files {
-			fmt.Printf("Processing file %d/%d: %s\n", i+1, len(files), fileHeader.Filename)
+			if ladderErr != nil {
+				break
+			}
+			logger.Debug("Processing file", "index", i+1, "total", len(files), "filename", fileHeader.Filename)
+
+			contentType := getContentType(fileHeader.Filename)
+			fileExt := strings.ToLower(filepath.Ext(fileHeader.Filename))
+			playlist, isPlaylist := playlists[fileHeader.Filename]
+			if !isPlaylist && !segments[fileHeader.Filename] && fileHeader != thumbnail {
+				// a playlist that couldn't be read
+				continue
+			}
 
 			// Open the file
 			file, err := fileHeader.Open()
 			if err != nil {
-				fmt.Printf("  ERROR: Failed to open file: %v\n", err)
+				logger.Warn("Failed to open file", "filename", fileHeader.Filename, "error", err)
 				failedFiles = append(failedFiles, FailedFile{
 					Filename: fileHeader.Filename,
 					Error:    err.Error(),
@@ -2508,127 +1740,157 @@ func UploadMultipleFiles() http.HandlerFunc {
 				continue
 			}
 
-			// Determine content type and file category
-			contentType := getContentType(fileHeader.Filename)
-			fileExt := strings.ToLower(filepath.Ext(fileHeader.Filename))
-			
-			var s3Key string
-			
-			// Check if it's a thumbnail image
+			var body io.Reader = file
+			s3Key := fmt.Sprintf("%s/%s", videoID, fileHeader.Filename)
 			if isImageFile(fileExt) {
 				// Upload to thumbnails folder with original filename
 				s3Key = fmt.Sprintf("thumbnails/%s", fileHeader.Filename)
-				thumbnailKey = s3Key
-				thumbnailURL = fmt.Sprintf("https://syn-video-cdn.b-cdn.net/%s", thumbnailKey)
-				fmt.Printf("  Detected thumbnail image\n")
-			} else if strings.HasSuffix(fileHeader.Filename, ".m3u8") {
-				// Playlist file
-				s3Key = fmt.Sprintf("%s/%s", videoID, fileHeader.Filename)
-				playlistKey = s3Key
-			} else {
-				// Regular video segments
-				s3Key = fmt.Sprintf("%s/%s", videoID, fileHeader.Filename)
+				placeholder, hashes = inspectThumbnail(file)
+			} else if isPlaylist {
+				body = bytes.NewReader(playlist)
 			}
 
-			fmt.Printf("  Uploading to S3: s3://%s/%s\n", configs.EnvProcessedBucket(), s3Key)
-			fmt.Printf("  Content-Type: %s\n", contentType)
+			logger.Debug("Uploading file", "bucket", configs.EnvProcessedBucket(), "key", s3Key, "content_type", contentType)
 
-			// Upload to S3
-			_, err = uploader.Upload(ctx, &s3.PutObjectInput{
-				Bucket:      aws.String(configs.EnvProcessedBucket()),
-				Key:         aws.String(s3Key),
-				Body:        file,
-				ContentType: aws.String(contentType),
-			})
+			err = store.Put(ctx, configs.EnvProcessedBucket(), s3Key, body, contentType)
 
 			file.Close()
 
 			if err != nil {
-				fmt.Printf("  ERROR: Failed to upload to S3: %v\n", err)
+				logger.Warn("Failed to upload file", "key", s3Key, "error", err)
 				failedFiles = append(failedFiles, FailedFile{
 					Filename: fileHeader.Filename,
 					Error:    err.Error(),
 				})
+				if isPlaylist {
+					delete(playlists, fileHeader.Filename)
+				} else {
+					delete(segments, fileHeader.Filename)
+				}
 				continue
 			}
 
-			fmt.Printf("  SUCCESS: Uploaded %s (%d bytes)\n", fileHeader.Filename, fileHeader.Size)
-			
+			logger.Debug("Uploaded file", "filename", fileHeader.Filename, "size", fileHeader.Size)
+			if fileHeader == thumbnail {
+				thumbnailURL = store.PublicURL(configs.EnvProcessedBucket(), s3Key)
+			}
+
 			uploadedFiles = append(uploadedFiles, UploadedFile{
 				Filename: fileHeader.Filename,
 				S3Key:    s3Key,
 				Size:     fileHeader.Size,
 			})
-			
-			fmt.Println("---------------------------------")
+		}
+		if ladderErr == nil {
+			// a ladder missing files that failed to upload is taken down again
+			if ladder, ladderErr = transcode.CheckLadder(playlists, segments); ladderErr != nil {
+				for _, uploaded := range uploadedFiles {
+					deleteStoredObject(configs.EnvProcessedBucket(), uploaded.S3Key)
+				}
+				uploadedFiles = nil
+				thumbnailURL, placeholder, hashes = "", nil, nil
+			}
 		}
 
-		fmt.Printf("\n=================================\n")
-		fmt.Printf("Upload Summary:\n")
-		fmt.Printf("  Successful: %d\n", len(uploadedFiles))
-		fmt.Printf("  Failed: %d\n", len(failedFiles))
-		fmt.Printf("=================================\n")
+		logger.Info("Upload summary", "uploaded", len(uploadedFiles), "failed", len(failedFiles))
 
-		// Update Content collection
+		// Only publish a ladder whose playlists and segments all made it;
+		// otherwise the video fails with a report of what's broken
 		var hlsURL string
-		if playlistKey != "" {
-			hlsURL = fmt.Sprintf("https://syn-video-cdn.b-cdn.net/%s", playlistKey)
-		}
-
-		// Prepare update document
+		var report []models.TranscodingProblem
+		var event models.TranscodingEvent
 		updateDoc := bson.M{
-			"transcoding":  "done",
 			"date_updated": time.Now(),
 		}
-
-		if hlsURL != "" {
+		update := bson.M{"$set": updateDoc}
+		// a failure only applies to a video still waiting for its ladder
+		pending := bson.M{}
+
+		if ladderErr != nil {
+			logger.Warn("Transcoded ladder is broken", "video_id", videoID, "error", ladderErr)
+			report = transcodingReport(ladderErr)
+			updateDoc["transcoding"] = TRANSCODING_FAILED
+			updateDoc["transcoding_report"] = report
+			event = models.TranscodingEvent{State: TRANSCODING_FAILED, Reason: ladderErr.Error()}
+			pending = bson.M{"transcoding": TRANSCODING_PENDING}
+		} else {
+			hlsURL = store.PublicURL(configs.EnvProcessedBucket(), videoID+"/"+ladder.Master)
+			updateDoc["transcoding"] = TRANSCODING_DONE
 			updateDoc["hls_url"] = hlsURL
 			updateDoc["posting"] = hlsURL
-			fmt.Printf("HLS URL: %s\n", hlsURL)
+			updateDoc["renditions"] = renditionsOf(ladder, videoID+"/")
+			updateDoc["duration"] = ladder.Duration
+			update["$unset"] = bson.M{"transcoding_report": ""}
+			event = models.TranscodingEvent{State: TRANSCODING_DONE, HLSURL: hlsURL}
+			logger.Info("HLS ready", "hls_url", hlsURL)
 		}
 
 		if thumbnailURL != "" {
 			updateDoc["thumbnail_key"] = thumbnailURL
-			fmt.Printf("Thumbnail URL: %s\n", thumbnailURL)
+			logger.Debug("Thumbnail stored", "thumbnail_url", thumbnailURL)
+		}
+
+		if placeholder != nil {
+			updateDoc["blurhash"] = placeholder.BlurHash
+			updateDoc["width"] = placeholder.Width
+			updateDoc["height"] = placeholder.Height
+		}
+		if hashes != nil {
+			updateDoc["phash"] = hashes.PHash
+			updateDoc["dhash"] = hashes.DHash
+			updateDoc["phash_bands"] = media.HashBands(hashes.PHash)
 		}
 
 		// Try to find the document
 		// First try by video_id (new format)
 		filter := bson.M{"video_id": videoID}
-		result, err := getContentCollection().UpdateOne(ctx, filter, bson.M{"$set": updateDoc})
-		
+		result, err := getContentCollection().UpdateOne(ctx, bson.M{"$and": []bson.M{filter, pending}}, update)
+
 		// If not found, try legacy format (search by location containing the videoID)
 		if err == nil && result.MatchedCount == 0 {
-			fmt.Printf("No document found with video_id, trying legacy location search...\n")
-			
+			logger.Debug("No document found with video_id, trying legacy location search", "video_id", videoID)
+
 			// Search by location field containing the video_id
 			legacyFilter := bson.M{
 				"location": bson.M{
-					"$regex": videoID,
+					"$regex":   videoID,
 					"$options": "i", // case insensitive
 				},
 				"LEGACY": true,
 			}
-			
-			result, err = getContentCollection().UpdateOne(ctx, legacyFilter, bson.M{"$set": updateDoc})
-			
+
+			result, err = getContentCollection().UpdateOne(ctx, bson.M{"$and": []bson.M{legacyFilter, pending}}, update)
+
 			if err != nil {
-				fmt.Printf("ERROR: Failed to update legacy content: %v\n", err)
+				logger.Error("Failed to update legacy content", "error", err)
 			} else if result.MatchedCount == 0 {
-				fmt.Printf("WARNING: No legacy content found with location containing video_id=%s\n", videoID)
+				logger.Warn("No legacy content found with location containing video_id", "video_id", videoID)
 			} else {
-				fmt.Printf("SUCCESS: Updated legacy content collection\n")
+				logger.Info("Updated legacy content", "video_id", videoID)
+				notifyVideoTranscoding(ctx, legacyFilter, event)
 			}
 		} else if err != nil {
-			fmt.Printf("ERROR: Failed to update content collection: %v\n", err)
+			logger.Error("Failed to update content", "error", err)
 		} else {
-			fmt.Printf("SUCCESS: Updated content collection\n")
+			logger.Info("Updated content", "video_id", videoID)
+			notifyVideoTranscoding(ctx, filter, event)
+		}
+
+		// the processor must not take the ladder for published
+		if err != nil {
+			errorResponse(rw, apierrors.Internal("failed to update content", err))
+			return
 		}
 
 		// Return response
-		rw.WriteHeader(http.StatusOK)
+		status, outcome := http.StatusOK, "success"
+		if ladderErr != nil {
+			status, outcome = http.StatusUnprocessableEntity, "failed"
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(status)
 		response := map[string]interface{}{
-			"status":         "success",
+			"status":         outcome,
 			"message":        fmt.Sprintf("Uploaded %d/%d files successfully", len(uploadedFiles), len(files)),
 			"video_id":       videoID,
 			"user_id":        userID,
@@ -2636,11 +1898,68 @@ func UploadMultipleFiles() http.HandlerFunc {
 			"thumbnail_url":  thumbnailURL,
 			"uploaded_files": uploadedFiles,
 			"failed_files":   failedFiles,
+			"transcoding_report": report,
 		}
 		json.NewEncoder(rw).Encode(response)
 	}
 }
 
+// readFormFile reads an uploaded file of a multipart form
+func readFormFile(fileHeader *multipart.FileHeader) ([]byte, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// videoExtensionFor maps a sniffed video MIME type to the extension used for
+// the raw object key. It returns "" when the type is not allowed.
+func videoExtensionFor(mime, filename string) string {
+	switch mime {
+	case "video/mp4":
+		return "mp4"
+	case "video/quicktime":
+		return "mov"
+	case "video/x-msvideo":
+		return "avi"
+	case "video/x-matroska":
+		return "mkv"
+	case "video/3gpp":
+		return "3gp"
+	case "video/hevc", "video/h265":
+		return "hevc"
+	case "application/octet-stream":
+		// MIME sniffer couldn't decide – trust the filename
+		ext := strings.ToLower(filepath.Ext(filename))
+		switch ext {
+		case ".mp4", ".mkv", ".avi", ".3gp", ".mov", ".hevc", ".h265", ".265":
+			return ext[1:]
+		}
+	}
+	return ""
+}
+
+// inspectThumbnail computes the blurhash, size and perceptual hashes of an
+// uploaded video thumbnail and rewinds the file so it can still be uploaded
+func inspectThumbnail(file multipart.File) (*media.Placeholder, *media.PerceptualHashes) {
+	defer file.Seek(0, io.SeekStart)
+
+	img, err := imaging.Decode(file, imaging.AutoOrientation(true))
+	if err != nil {
+		configs.LogWithContext("content", "inspect-thumbnail").Warn("Could not decode thumbnail", "error", err)
+		return nil, nil
+	}
+	hashes := media.NewPerceptualHashes(img)
+	placeholder, err := media.NewPlaceholder(img)
+	if err != nil {
+		configs.LogWithContext("content", "inspect-thumbnail").Warn("Could not compute blurhash", "error", err)
+		return nil, &hashes
+	}
+	return placeholder, &hashes
+}
+
 // isImageFile checks if the file extension is an image
 func isImageFile(ext string) bool {
 	imageExtensions := []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".bmp", ".svg", ".ico"}
@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+	"upload-service/apierrors"
+	"upload-service/auth"
+	"upload-service/configs"
+	"upload-service/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var contentTypes = map[string]bool{
+	TYPE_PIC: true, TYPE_VIDEO: true, TYPE_TEXT: true, TYPE_STREAM: true, TYPE_GALLERY: true,
+}
+
+// contentViewer is who is reading content. Privileged viewers (admins,
+// internal services, and everyone while auth is disabled) see all of it;
+// others see hidden content only when it's theirs and followers-only
+// content only when they follow its owner.
+type contentViewer struct {
+	userID     string
+	privileged bool
+}
+
+func viewerOf(r *http.Request) contentViewer {
+	caller, _ := auth.FromContext(r.Context())
+	return contentViewer{
+		userID:     caller.UserID,
+		privileged: !configs.EnvAuthEnabled() || caller.HasScope(auth.SCOPE_USERS) || caller.HasRole(auth.ROLE_ADMIN),
+	}
+}
+
+// followedOwners returns the owners whose followers-only content the viewer
+// may see: the ones they follow, and themselves
+func (v contentViewer) followedOwners(ctx context.Context, owners []string) ([]string, error) {
+	if v.userID == "" {
+		return []string{}, nil
+	}
+	allowed := []string{v.userID}
+	if len(owners) == 0 {
+		return allowed, nil
+	}
+	cur, err := getFollowsCollection().Find(ctx, bson.M{"follower": v.userID, "following": bson.M{"$in": owners}})
+	if err != nil {
+		return nil, err
+	}
+	follows := []models.Follow{}
+	if err := cur.All(ctx, &follows); err != nil {
+		return nil, err
+	}
+	for _, follow := range follows {
+		allowed = append(allowed, follow.Following)
+	}
+	return allowed, nil
+}
+
+// canSee checks the viewer may read content
+func (v contentViewer) canSee(ctx context.Context, content models.Content) error {
+	if v.privileged {
+		return nil
+	}
+	if content.IsDeleted {
+		return apierrors.NotFound("content not found")
+	}
+	if !content.Show && v.userID != content.UserID && v.userID != content.Poster {
+		return apierrors.NotFound("content not found")
+	}
+	if content.Visibility != VISIBILITY_FOLLOWERS {
+		return nil
+	}
+	if v.userID == "" {
+		return apierrors.Unauthorized("sign in to see followers-only content")
+	}
+	allowed, err := v.followedOwners(ctx, []string{content.UserID})
+	if err != nil {
+		return apierrors.Internal("failed to check follows", err)
+	}
+	for _, owner := range allowed {
+		if owner == content.UserID {
+			return nil
+		}
+	}
+	return apierrors.Forbidden("content is only visible to the owner's followers")
+}
+
+// GetContent returns one content item
+func GetContent() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		oID, err := primitive.ObjectIDFromHex(mux.Vars(r)["ContentID"])
+		if err != nil {
+			errorResponse(rw, apierrors.Validation("invalid content ID"))
+			return
+		}
+		content := models.Content{}
+		err = getContentCollection().FindOne(ctx, bson.M{"_id": oID}).Decode(&content)
+		if err == mongo.ErrNoDocuments {
+			errorResponse(rw, apierrors.NotFound("content not found"))
+			return
+		}
+		if err != nil {
+			errorResponse(rw, apierrors.Internal("failed to load content", err))
+			return
+		}
+		if err := viewerOf(r).canSee(ctx, content); err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		writeResponse(rw, http.StatusOK, "success", map[string]interface{}{"data": content})
+	}
+}
+
+// GetUserContent lists a user's posts and the posts they reposted, newest
+// first, one cursor page at a time. The type, visibility, show and isdeleted
+// query parameters narrow the list; deleted content is for admins only.
+func GetUserContent() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		userID := mux.Vars(r)["UserID"]
+		viewer := viewerOf(r)
+
+		page, err := parsePageRequest(r)
+		if err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		filter, err := userContentFilter(r, userID, viewer)
+		if err != nil {
+			errorResponse(rw, err)
+			return
+		}
+
+		if !viewer.privileged {
+			// followers-only content needs the viewer to follow whoever
+			// posted it, which for reposts isn't userID
+			followersOnly := bson.M{"$and": []bson.M{filter, {"visibility": VISIBILITY_FOLLOWERS}}}
+			owners, err := getContentCollection().Distinct(ctx, "userid", followersOnly)
+			if err != nil {
+				errorResponse(rw, apierrors.Internal("failed to list content owners", err))
+				return
+			}
+			ownerIDs := []string{}
+			for _, owner := range owners {
+				if id, ok := owner.(string); ok {
+					ownerIDs = append(ownerIDs, id)
+				}
+			}
+			allowed, err := viewer.followedOwners(ctx, ownerIDs)
+			if err != nil {
+				errorResponse(rw, apierrors.Internal("failed to check follows", err))
+				return
+			}
+			filter = bson.M{"$and": []bson.M{filter, {"$or": []bson.M{
+				{"visibility": bson.M{"$ne": VISIBILITY_FOLLOWERS}},
+				{"userid": bson.M{"$in": allowed}},
+			}}}}
+		}
+
+		filter = bson.M{"$and": []bson.M{filter, page.after("datecreated")}}
+		cur, err := getContentCollection().Find(ctx, filter, page.findOptions("datecreated"))
+		if err != nil {
+			errorResponse(rw, apierrors.Internal("failed to list content", err))
+			return
+		}
+		content := []models.Content{}
+		if err := cur.All(ctx, &content); err != nil {
+			errorResponse(rw, apierrors.Internal("failed to decode content", err))
+			return
+		}
+		content, next := pageOf(page, content, func(c models.Content) (time.Time, primitive.ObjectID) {
+			return c.DateCreated, c.Id
+		})
+		writePage(rw, content, next)
+	}
+}
+
+// userContentFilter builds the filter for a user's content from the query
+// parameters, before visibility is applied
+func userContentFilter(r *http.Request, userID string, viewer contentViewer) (bson.M, error) {
+	query := r.URL.Query()
+	filter := bson.M{
+		"$or":       []bson.M{{"userid": userID}, {"reposter": userID}},
+		"isdeleted": false,
+	}
+	fields := []models.FieldError{}
+
+	if contentType := query.Get("type"); contentType != "" {
+		if !contentTypes[contentType] {
+			fields = append(fields, models.FieldError{Field: "type", Message: "unknown content type"})
+		}
+		filter["type"] = contentType
+	}
+	if visibility := query.Get("visibility"); visibility != "" {
+		if visibility != VISIBILITY_EVERYONE && visibility != VISIBILITY_FOLLOWERS {
+			fields = append(fields, models.FieldError{Field: "visibility", Message: "must be everyone or followers"})
+		}
+		filter["visibility"] = visibility
+		if visibility == VISIBILITY_EVERYONE {
+			// content from before visibility was recorded is public
+			filter["visibility"] = bson.M{"$ne": VISIBILITY_FOLLOWERS}
+		}
+	}
+	if raw := query.Get("show"); raw != "" {
+		show, err := strconv.ParseBool(raw)
+		if err != nil {
+			fields = append(fields, models.FieldError{Field: "show", Message: "must be true or false"})
+		}
+		filter["show"] = show
+	}
+	if raw := query.Get("isdeleted"); raw != "" {
+		deleted, err := strconv.ParseBool(raw)
+		if err != nil {
+			fields = append(fields, models.FieldError{Field: "isdeleted", Message: "must be true or false"})
+		}
+		filter["isdeleted"] = deleted
+	}
+	if len(fields) > 0 {
+		return nil, apierrors.InvalidFields(fields)
+	}
+
+	if !viewer.privileged {
+		if filter["isdeleted"] == true {
+			return nil, apierrors.Forbidden("only admins can list deleted content")
+		}
+		// hidden posts only show up on their owner's own profile
+		if viewer.userID != userID {
+			filter["show"] = true
+		}
+	}
+	return filter, nil
+}
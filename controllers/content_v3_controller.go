@@ -0,0 +1,539 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"upload-service/apierrors"
+	"upload-service/configs"
+	"upload-service/media"
+	"upload-service/models"
+	"upload-service/responses"
+	"upload-service/storage"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The v3 content API takes a typed, validated ContentDocument instead of
+// flags, prices and tags in the URL. The v1/v2 handlers turn their path
+// segments into the same document and share the code below, keeping their
+// lenient parsing so existing clients behave as before.
+
+// legacyContentDocument reads the v1/v2 path segments. Malformed flags and
+// prices fall back to false and 0 as they always have.
+func legacyContentDocument(vars map[string]string) models.ContentDocument {
+	show, _ := strconv.ParseBool(vars["Show"])
+	ispayperview, _ := strconv.ParseBool(vars["IsPayPerView"])
+	isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
+	price, err := strconv.ParseFloat(vars["PPVPrice"], 64)
+	if err != nil {
+		configs.LogWithContext("content", "legacy-document").Debug("Invalid price in PPVPrice", "ppvprice", vars["PPVPrice"])
+		price = 0
+	}
+
+	doc := models.ContentDocument{
+		UserID:       vars["UserID"],
+		Title:        vars["Title"],
+		Description:  vars["Description"],
+		Show:         &show,
+		IsPayPerView: ispayperview,
+		PPVPrice:     price,
+		IsDeleted:    isdeleted,
+		Tags:         strings.Split(vars["Tags"], ","),
+	}
+	for i, s := range doc.Tags {
+		doc.Tags[i] = strings.TrimSpace(s)
+	}
+	if visibility, ok := vars["Visibility"]; ok {
+		doc.Visibility = legacyVisibility(visibility)
+	}
+	return doc
+}
+
+func legacyVisibility(visibility string) string {
+	if visibility != VISIBILITY_FOLLOWERS {
+		return VISIBILITY_EVERYONE
+	}
+	return visibility
+}
+
+// withContentBody copies the fields v1/v2 clients send in their JSON body
+func withContentBody(doc models.ContentDocument, body models.ContentBody) models.ContentDocument {
+	doc.Title = body.Title
+	doc.Description = body.Description
+	doc.Posting = body.Posting
+	doc.ItemsOrder = body.ItemsOrder
+	doc.RemoveItems = body.RemoveItems
+	return doc
+}
+
+// decodeContentDocument strictly decodes and validates a v3 document, so a
+// misspelt field is reported instead of silently falling back to a default
+func decodeContentDocument(data io.Reader) (models.ContentDocument, []models.FieldError) {
+	var doc models.ContentDocument
+	decoder := json.NewDecoder(data)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&doc); err != nil {
+		return doc, []models.FieldError{decodeFieldError(err)}
+	}
+
+	doc.Normalize()
+	if errs := doc.Validate(); len(errs) > 0 {
+		return doc, errs
+	}
+	if doc.Visibility == "" {
+		doc.Visibility = VISIBILITY_EVERYONE
+	}
+	return doc, nil
+}
+
+// decodeFieldError attributes a JSON decoding error to the field it concerns
+func decodeFieldError(err error) models.FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return models.FieldError{Field: typeErr.Field, Message: "must be " + jsonTypeName(typeErr.Type)}
+	}
+	if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+		field := strings.Trim(strings.TrimPrefix(msg, "json: unknown field "), `"`)
+		return models.FieldError{Field: field, Message: "is not a known field"}
+	}
+	if err == io.EOF {
+		return models.FieldError{Message: "request body is empty"}
+	}
+	return models.FieldError{Message: "malformed JSON: " + err.Error()}
+}
+
+// jsonTypeName names a Go type the way API clients know it
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice:
+		return "an array"
+	case reflect.Ptr:
+		return jsonTypeName(t.Elem())
+	}
+	return "an object"
+}
+
+// newContentFromDocument fills the fields every content type shares
+func newContentFromDocument(doc models.ContentDocument, contentType string) models.Content {
+	return models.Content{
+		UserID:       doc.UserID,
+		Poster:       doc.UserID,
+		Title:        doc.Title,
+		Description:  doc.Description,
+		DateCreated:  time.Now(),
+		Show:         doc.IsShown(),
+		IsPayPerView: doc.IsPayPerView,
+		IsDeleted:    doc.IsDeleted,
+		PPVPrice:     doc.PPVPrice,
+		Tags:         doc.Tags,
+		Type:         contentType,
+		Visibility:   doc.Visibility,
+	}
+}
+
+// createPicture stores the "file" part of a parsed multipart form as a
+// picture post described by doc
+func createPicture(ctx context.Context, rw http.ResponseWriter, r *http.Request, doc models.ContentDocument) {
+	if err := authorizeUser(r, doc.UserID); err != nil {
+		errorResponse(rw, err)
+		return
+	}
+
+	logger := configs.LogWithRequest(r.Context(), "content", "create-picture")
+
+	// Generate unique ID
+	imageID := strings.Replace(uuid.New().String(), "-", "", -1)
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		logger.Warn("Error reading form file", "error", err)
+		errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
+		return
+	}
+	defer file.Close()
+
+	// Read the entire file for MIME detection
+	fileBytes, err := io.ReadAll(file)
+	if err != nil {
+		logger.Warn("Failed to read file data", "error", err)
+		errorResponse(rw, apierrors.Validation("failed to read file"))
+		return
+	}
+
+	mimeType := mimetype.Detect(fileBytes).String()
+	logger.Debug("Detected MIME", "mime", mimeType)
+
+	extension := pictureExtensionFor(mimeType)
+	if extension == "" {
+		errorResponse(rw, apierrors.New(apierrors.UNSUPPORTED_MEDIA_TYPE, "This file type is not allowed for images"))
+		return
+	}
+
+	store := storage.Backend()
+	pic, err := storePicture(ctx, doc.UserID, imageID, fileBytes, mimeType, extension)
+	if err == ErrBannedImage {
+		errorResponse(rw, apierrors.Wrap(apierrors.UNPROCESSABLE, err))
+		return
+	}
+	var rejected *apierrors.Error
+	if errors.As(err, &rejected) {
+		errorResponse(rw, err)
+		return
+	}
+	if err != nil {
+		logger.Error("Error uploading original to storage", "error", err)
+		errorResponse(rw, apierrors.Upstream("error uploading image", err))
+		return
+	}
+	duplicates := findNearDuplicates(ctx, doc.UserID, pic.PHash)
+
+	newPostPic := newContentFromDocument(doc, TYPE_PIC)
+	newPostPic.Location = store.PublicURL(configs.EnvPicturesBucket(), pic.OriginalKey)
+	newPostPic.Posting = store.PublicURL(configs.EnvPicturesBucket(), pic.ThumbnailKey)
+	newPostPic.S3RawKey = pic.OriginalKey
+	newPostPic.ThumbnailKey = pic.ThumbnailKey
+	newPostPic.Variants = pic.Variants
+	newPostPic.MasterKey = pic.MasterKey
+	newPostPic.BlurHash = pic.BlurHash
+	newPostPic.Width = pic.Width
+	newPostPic.Height = pic.Height
+	newPostPic.PHash = pic.PHash
+	newPostPic.DHash = pic.DHash
+	newPostPic.PHashBands = media.HashBands(pic.PHash)
+
+	result, err := getContentCollection().InsertOne(ctx, newPostPic)
+	if err != nil {
+		logger.Error("Failed to insert picture", "error", err)
+		errorResponse(rw, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusCreated)
+	response := responses.ContentResponse{
+		Status:  http.StatusCreated,
+		Message: "success",
+		Data:    map[string]interface{}{"data": result, "warnings": nearDuplicateWarnings(duplicates)},
+	}
+	json.NewEncoder(rw).Encode(response)
+}
+
+// createVideo stores the "video" part of a parsed multipart form in the raw
+// bucket and records it as pending transcoding
+func createVideo(ctx context.Context, rw http.ResponseWriter, r *http.Request, doc models.ContentDocument) {
+	if err := authorizeUser(r, doc.UserID); err != nil {
+		errorResponse(rw, err)
+		return
+	}
+
+	logger := configs.LogWithRequest(r.Context(), "content", "create-video")
+
+	// Generate unique video ID
+	videoID := strings.Replace(uuid.New().String(), "-", "", -1)
+
+	file, fheader, err := r.FormFile("video")
+	if err != nil {
+		errorResponse(rw, apierrors.Validation("error reading video file"))
+		return
+	}
+	defer file.Close()
+
+	fileHeader := make([]byte, 512)
+	file.Read(fileHeader)
+	file.Seek(0, 0)
+
+	mime := http.DetectContentType(fileHeader)
+	extension := videoExtensionFor(mime, fheader.Filename)
+	if extension == "" {
+		errorResponse(rw, apierrors.New(apierrors.UNSUPPORTED_MEDIA_TYPE, "Invalid video file type"))
+		return
+	}
+
+	mediaInfo, err := inspectUploadedVideo(ctx, r, file)
+	if err != nil {
+		errorResponse(rw, err)
+		return
+	}
+
+	s3VideoKey := fmt.Sprintf("%s/%s.%s", doc.UserID, videoID, extension)
+
+	logger.Info("Uploading video", "bucket", configs.EnvRawBucket(), "key", s3VideoKey)
+
+	s3VideoKey, err = storeDedupedVideo(ctx, s3VideoKey, file, mime)
+	if err != nil {
+		logger.Error("Error uploading to storage", "error", err)
+		errorResponse(rw, apierrors.Upstream("error uploading video", err))
+		return
+	}
+
+	newPostVid := newContentFromDocument(doc, TYPE_VIDEO)
+	newPostVid.VideoID = videoID
+	newPostVid.S3RawKey = s3VideoKey
+	newPostVid.Transcoding = TRANSCODING_PENDING
+	newPostVid.MediaInfo = mediaInfo
+
+	result, err := getContentCollection().InsertOne(ctx, newPostVid)
+	if err != nil {
+		logger.Error("Failed to insert video", "error", err)
+		errorResponse(rw, err)
+		return
+	}
+	enqueueTranscoding(ctx, result.InsertedID.(primitive.ObjectID), videoID, doc.UserID, s3VideoKey)
+
+	rw.WriteHeader(http.StatusCreated)
+	response := responses.ContentResponse{
+		Status:  http.StatusCreated,
+		Message: "success",
+		Data: map[string]interface{}{
+			"video_id":   videoID,
+			"content_id": result.InsertedID,
+			"status":     "processing",
+		},
+	}
+	json.NewEncoder(rw).Encode(response)
+}
+
+func createText(ctx context.Context, rw http.ResponseWriter, r *http.Request, doc models.ContentDocument) {
+	if err := authorizeUser(r, doc.UserID); err != nil {
+		errorResponse(rw, err)
+		return
+	}
+	newTextContent := newContentFromDocument(doc, TYPE_TEXT)
+	newTextContent.Posting = doc.Posting
+
+	result, err := getContentCollection().InsertOne(ctx, newTextContent)
+	if err != nil {
+		configs.LogWithRequest(r.Context(), "content", "create-text").Error("Failed to insert text", "error", err)
+		errorResponse(rw, err)
+		return
+	}
+	successResponse(rw, result.InsertedID)
+}
+
+// createStream registers a live stream and hands back the keys to publish it
+func createStream(ctx context.Context, rw http.ResponseWriter, r *http.Request, doc models.ContentDocument) {
+	if err := authorizeUser(r, doc.UserID); err != nil {
+		errorResponse(rw, err)
+		return
+	}
+
+	// Generate unique stream key
+	streamKey := strings.Replace(uuid.New().String(), "-", "", -1)
+
+	// Streaming server IP
+	streamingServerIP := "13.50.17.68" // TODO: Move to configs.EnvStreamingServer()
+
+	newStream := newContentFromDocument(doc, TYPE_STREAM)
+	newStream.Location = configs.EnvMediaDir() + "/" + doc.UserID + "/videos/"
+	newStream.StreamKey = streamKey
+	newStream.RTMPUrl = fmt.Sprintf("rtmp://%s/live/%s", streamingServerIP, streamKey)
+	newStream.HLSURL = fmt.Sprintf("http://%s/hls/%s/index.m3u8", streamingServerIP, streamKey)
+	newStream.IsLive = false // Will be set to true when streaming actually starts
+
+	result, err := getContentCollection().InsertOne(ctx, newStream)
+	if err != nil {
+		errorResponse(rw, apierrors.Internal("failed to create stream", err))
+		return
+	}
+	// the nginx on_publish callback sends the live notification, not this
+
+	rw.WriteHeader(http.StatusCreated)
+	response := responses.ContentResponse{
+		Status:  http.StatusCreated,
+		Message: "success",
+		Data: map[string]interface{}{
+			"content_id": result.InsertedID,
+			"stream_key": streamKey,
+			"rtmp_url":   newStream.RTMPUrl,
+			"hls_url":    newStream.HLSURL,
+		},
+	}
+	json.NewEncoder(rw).Encode(response)
+}
+
+// updateContent applies an edit document to a stored post. Visibility is only
+// changed when the document carries one, as v1 edits never did. Edits made
+// for another type than the stored post's are rejected. Deleting a post
+// through an edit dates the deletion like DeleteContent, so it is purged the
+// same way; restoring it clears the date.
+func updateContent(ctx context.Context, content models.Content, theType string, doc models.ContentDocument) error {
+	if theType != content.Type {
+		return apierrors.Validation(fmt.Sprintf("content is of type %q, not %q", content.Type, theType))
+	}
+	if theType == TYPE_GALLERY {
+		body := models.ContentBody{ItemsOrder: doc.ItemsOrder, RemoveItems: doc.RemoveItems}
+		if err := applyGalleryEdits(ctx, content.Id, body); err != nil {
+			return err
+		}
+	}
+
+	set := bson.M{
+		"isdeleted":    doc.IsDeleted,
+		"show":         doc.IsShown(),
+		"ispayperview": doc.IsPayPerView,
+		"ppvprice":     doc.PPVPrice,
+		"title":        doc.Title,
+		"description":  doc.Description,
+		"tags":         doc.Tags,
+	}
+	switch theType {
+	case TYPE_PIC, TYPE_VIDEO, TYPE_GALLERY, TYPE_STREAM:
+	case TYPE_TEXT:
+		set["posting"] = doc.Posting
+	default:
+		return apierrors.Validation(fmt.Sprintf("content of type %q can't be edited", theType))
+	}
+	if doc.Visibility != "" {
+		set["visibility"] = doc.Visibility
+	}
+	update := bson.M{"$set": set}
+	if !doc.IsDeleted {
+		update["$unset"] = bson.M{"datedeleted": ""}
+	} else if content.DateDeleted == nil {
+		set["datedeleted"] = time.Now()
+	}
+
+	result, err := getContentCollection().UpdateOne(ctx, bson.M{"_id": content.Id}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return apierrors.NotFound("content not found")
+	}
+	return nil
+}
+
+// parseMultipartDocument reads the "data" part of a v3 multipart upload
+func parseMultipartDocument(rw http.ResponseWriter, r *http.Request, maxBytes int64) (models.ContentDocument, bool) {
+	r.Body = http.MaxBytesReader(rw, r.Body, maxBytes)
+	if err := r.ParseMultipartForm(10 * MB); err != nil {
+		errorResponse(rw, apierrors.Validation(fmt.Sprintf("error parsing form: %v", err)))
+		return models.ContentDocument{}, false
+	}
+	jsonData := r.FormValue("data")
+	if jsonData == "" {
+		errorResponse(rw, apierrors.InvalidFields([]models.FieldError{{Field: "data", Message: "multipart part is required"}}))
+		return models.ContentDocument{}, false
+	}
+	doc, errs := decodeContentDocument(strings.NewReader(jsonData))
+	if len(errs) > 0 {
+		errorResponse(rw, apierrors.InvalidFields(errs))
+		return doc, false
+	}
+	return doc, true
+}
+
+func PostPicV3() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := requestContext(r, 5*time.Minute)
+		defer cancel()
+
+		doc, ok := parseMultipartDocument(rw, r, 10*MB)
+		if !ok {
+			return
+		}
+		createPicture(ctx, rw, r, doc)
+	}
+}
+
+func PostVideoV3() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Minute)
+		defer cancel()
+
+		doc, ok := parseMultipartDocument(rw, r, 1024*20*MB)
+		if !ok {
+			return
+		}
+		createVideo(ctx, rw, r, doc)
+	}
+}
+
+func PostTextV3() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		doc, errs := decodeContentDocument(r.Body)
+		if len(errs) == 0 && strings.TrimSpace(doc.Posting) == "" {
+			errs = append(errs, models.FieldError{Field: "posting", Message: "is required for text posts"})
+		}
+		if len(errs) > 0 {
+			errorResponse(rw, apierrors.InvalidFields(errs))
+			return
+		}
+		createText(ctx, rw, r, doc)
+	}
+}
+
+func StartStreamV3() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		doc, errs := decodeContentDocument(r.Body)
+		if len(errs) > 0 {
+			errorResponse(rw, apierrors.InvalidFields(errs))
+			return
+		}
+		createStream(ctx, rw, r, doc)
+	}
+}
+
+// EditContentV3 replaces the editable fields of a post with the document.
+// The content type is taken from the stored post rather than the URL.
+func EditContentV3() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		contentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["ContentID"])
+		if err != nil {
+			errorResponse(rw, apierrors.Validation("invalid content ID"))
+			return
+		}
+
+		doc, errs := decodeContentDocument(r.Body)
+		if len(errs) > 0 {
+			errorResponse(rw, apierrors.InvalidFields(errs))
+			return
+		}
+
+		content, err := authorizeContentOwner(ctx, r, ACTION_EDIT_CONTENT, contentID)
+		if err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		if content.UserID != doc.UserID {
+			errorResponse(rw, apierrors.InvalidFields([]models.FieldError{{Field: "user_id", Message: "must be the content's owner"}}))
+			return
+		}
+		if content.Type == TYPE_TEXT && strings.TrimSpace(doc.Posting) == "" {
+			errorResponse(rw, apierrors.InvalidFields([]models.FieldError{{Field: "posting", Message: "is required for text posts"}}))
+			return
+		}
+
+		if err := updateContent(ctx, content, content.Type, doc); err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		successResponse(rw, "OK")
+	}
+}
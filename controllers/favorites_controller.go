@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strings"
 	"time"
+	"upload-service/apierrors"
 	"upload-service/configs"
 	"upload-service/models"
 
@@ -25,6 +26,10 @@ func AddContentToFavorites() http.HandlerFunc {
 		vars := mux.Vars(r)
 		userID := vars["UserID"]
 		contentID := vars["ContentID"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 
 		/**
 		If album id is not sent, we create a favorite album and add the content to it.
@@ -59,14 +64,14 @@ func AddContentToFavorites() http.HandlerFunc {
 				result, err := albumsCollection.InsertOne(ctx, defaultAlbum)
 				if err != nil {
 					log.Printf("AddContentToFavorites: error creating default album: %v", err)
-					errorResponse(rw, err, 500)
+					errorResponse(rw, err)
 					return
 				}
 				defaultAlbum.ID = result.InsertedID.(primitive.ObjectID)
 				log.Printf("AddContentToFavorites: created default album with ID: %s", defaultAlbum.ID.Hex())
 			} else if err != nil {
 				log.Printf("AddContentToFavorites: error finding default album: %v", err)
-				errorResponse(rw, err, 500)
+				errorResponse(rw, err)
 				return
 			}
 			
@@ -78,7 +83,7 @@ func AddContentToFavorites() http.HandlerFunc {
 		albumObjectID, err := primitive.ObjectIDFromHex(albumID)
 		if err != nil {
 			log.Printf("AddContentToFavorites: invalid album ID format: %v", err)
-			errorResponse(rw, fmt.Errorf("invalid album ID"), 400)
+			errorResponse(rw, apierrors.Validation("invalid album ID"))
 			return
 		}
 		
@@ -90,12 +95,12 @@ func AddContentToFavorites() http.HandlerFunc {
 		
 		if err == mongo.ErrNoDocuments {
 			log.Printf("AddContentToFavorites: album not found or doesn't belong to user")
-			errorResponse(rw, fmt.Errorf("album not found"), 404)
+			errorResponse(rw, apierrors.NotFound("album not found"))
 			return
 		}
 		if err != nil {
 			log.Printf("AddContentToFavorites: error finding album: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		
@@ -124,7 +129,7 @@ func AddContentToFavorites() http.HandlerFunc {
 		result, err := favoritesCollection.InsertOne(ctx, newFavorite)
 		if err != nil {
 			log.Printf("AddContentToFavorites: error inserting favorite: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		
@@ -151,12 +156,16 @@ func RemoveContentFromFavorites() http.HandlerFunc {
 		userID := vars["UserID"]
 		contentID := vars["ContentID"]
 		albumID := vars["AlbumID"] // Required now
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		
 		log.Printf("RemoveContentFromFavorites: userID=%s, contentID=%s, albumID=%s", userID, contentID, albumID)
 		
 		if albumID == "" {
 			log.Printf("RemoveContentFromFavorites: albumID is required")
-			errorResponse(rw, fmt.Errorf("albumID is required"), 400)
+			errorResponse(rw, apierrors.Validation("albumID is required"))
 			return
 		}
 		
@@ -167,7 +176,7 @@ func RemoveContentFromFavorites() http.HandlerFunc {
 		albumObjectID, err := primitive.ObjectIDFromHex(albumID)
 		if err != nil {
 			log.Printf("RemoveContentFromFavorites: invalid albumID format: %v", err)
-			errorResponse(rw, fmt.Errorf("invalid albumID"), 400)
+			errorResponse(rw, apierrors.Validation("invalid albumID"))
 			return
 		}
 		
@@ -179,12 +188,12 @@ func RemoveContentFromFavorites() http.HandlerFunc {
 		
 		if err == mongo.ErrNoDocuments {
 			log.Printf("RemoveContentFromFavorites: album not found or doesn't belong to user")
-			errorResponse(rw, fmt.Errorf("album not found"), 404)
+			errorResponse(rw, apierrors.NotFound("album not found"))
 			return
 		}
 		if err != nil {
 			log.Printf("RemoveContentFromFavorites: error finding album: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		
@@ -198,13 +207,13 @@ func RemoveContentFromFavorites() http.HandlerFunc {
 		deleteResult, err := favoritesCollection.DeleteOne(ctx, filter)
 		if err != nil {
 			log.Printf("RemoveContentFromFavorites: error deleting favorite: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		
 		if deleteResult.DeletedCount == 0 {
 			log.Printf("RemoveContentFromFavorites: favorite not found")
-			errorResponse(rw, fmt.Errorf("content not found in this album"), 404)
+			errorResponse(rw, apierrors.NotFound("content not found in this album"))
 			return
 		}
 		
@@ -235,6 +244,10 @@ func CreateNewAlbum() http.HandlerFunc {
 		
 		vars := mux.Vars(r)
 		userID := vars["UserID"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		
 		log.Printf("CreateNewAlbum: userID=%s", userID)
 		
@@ -242,7 +255,7 @@ func CreateNewAlbum() http.HandlerFunc {
 		var req CreateAlbumRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			log.Printf("CreateNewAlbum: invalid request body: %v", err)
-			errorResponse(rw, fmt.Errorf("invalid request body"), 400)
+			errorResponse(rw, apierrors.Validation("invalid request body"))
 			return
 		}
 		
@@ -250,7 +263,7 @@ func CreateNewAlbum() http.HandlerFunc {
 		req.Title = strings.TrimSpace(req.Title)
 		if req.Title == "" {
 			log.Printf("CreateNewAlbum: album title is required")
-			errorResponse(rw, fmt.Errorf("album title is required"), 400)
+			errorResponse(rw, apierrors.Validation("album title is required"))
 			return
 		}
 		
@@ -266,13 +279,13 @@ func CreateNewAlbum() http.HandlerFunc {
 		if err == nil {
 			// Album already exists
 			log.Printf("CreateNewAlbum: album with title '%s' already exists", req.Title)
-			errorResponse(rw, fmt.Errorf("album with this title already exists"), 409)
+			errorResponse(rw, apierrors.Conflict("album with this title already exists"))
 			return
 		}
 		
 		if err != mongo.ErrNoDocuments {
 			log.Printf("CreateNewAlbum: error checking existing album: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		
@@ -288,7 +301,7 @@ func CreateNewAlbum() http.HandlerFunc {
 		result, err := albumsCollection.InsertOne(ctx, newAlbum)
 		if err != nil {
 			log.Printf("CreateNewAlbum: error inserting album: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		
@@ -297,14 +310,8 @@ func CreateNewAlbum() http.HandlerFunc {
 		log.Printf("CreateNewAlbum: successfully created album with ID: %s", newAlbum.ID.Hex())
 		
 		// Return the created album with its ID
-		rw.Header().Set("Content-Type", "application/json")
-		rw.WriteHeader(http.StatusCreated)
-		json.NewEncoder(rw).Encode(map[string]interface{}{
-			"status":  201,
-			"message": "Album created successfully",
-			"data": map[string]interface{}{
-				"album": newAlbum,
-			},
+		writeResponse(rw, http.StatusCreated, "Album created successfully", map[string]interface{}{
+			"album": newAlbum,
 		})
 	}
 }
@@ -317,6 +324,10 @@ func RemoveAlbum() http.HandlerFunc {
 		vars := mux.Vars(r)
 		userID := vars["UserID"]
 		albumID := vars["AlbumID"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		
 		log.Printf("RemoveAlbum: userID=%s, albumID=%s", userID, albumID)
 		
@@ -324,7 +335,7 @@ func RemoveAlbum() http.HandlerFunc {
 		albumObjectID, err := primitive.ObjectIDFromHex(albumID)
 		if err != nil {
 			log.Printf("RemoveAlbum: invalid albumID format: %v", err)
-			errorResponse(rw, fmt.Errorf("invalid album ID"), 400)
+			errorResponse(rw, apierrors.Validation("invalid album ID"))
 			return
 		}
 		
@@ -340,12 +351,12 @@ func RemoveAlbum() http.HandlerFunc {
 		
 		if err == mongo.ErrNoDocuments {
 			log.Printf("RemoveAlbum: album not found or doesn't belong to user")
-			errorResponse(rw, fmt.Errorf("album not found"), 404)
+			errorResponse(rw, apierrors.NotFound("album not found"))
 			return
 		}
 		if err != nil {
 			log.Printf("RemoveAlbum: error finding album: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		
@@ -356,7 +367,7 @@ func RemoveAlbum() http.HandlerFunc {
 		})
 		if err != nil {
 			log.Printf("RemoveAlbum: error deleting favorites from album: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		
@@ -369,27 +380,21 @@ func RemoveAlbum() http.HandlerFunc {
 		})
 		if err != nil {
 			log.Printf("RemoveAlbum: error deleting album: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		
 		if albumDeleteResult.DeletedCount == 0 {
 			log.Printf("RemoveAlbum: album was not deleted (possible race condition)")
-			errorResponse(rw, fmt.Errorf("failed to delete album"), 500)
+			errorResponse(rw, apierrors.Internal("failed to delete album", err))
 			return
 		}
 		
 		log.Printf("RemoveAlbum: successfully deleted album '%s' and %d favorites", album.Title, deleteResult.DeletedCount)
 		
-		rw.Header().Set("Content-Type", "application/json")
-		rw.WriteHeader(http.StatusOK)
-		json.NewEncoder(rw).Encode(map[string]interface{}{
-			"status":  200,
-			"message": fmt.Sprintf("Album '%s' and %d favorites deleted successfully", album.Title, deleteResult.DeletedCount),
-			"data": map[string]interface{}{
-				"albumTitle":       album.Title,
-				"favoritesDeleted": deleteResult.DeletedCount,
-			},
+		writeResponse(rw, http.StatusOK, fmt.Sprintf("Album '%s' and %d favorites deleted successfully", album.Title, deleteResult.DeletedCount), map[string]interface{}{
+			"albumTitle":       album.Title,
+			"favoritesDeleted": deleteResult.DeletedCount,
 		})
 	}
 }
@@ -405,13 +410,17 @@ func MoveFavorite() http.HandlerFunc {
 		contentID := vars["ContentID"]
 		fromAlbumID := vars["FromAlbumID"]
 		toAlbumID := vars["ToAlbumID"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		
 		log.Printf("MoveFavorite: userID=%s, contentID=%s, from=%s, to=%s", userID, contentID, fromAlbumID, toAlbumID)
 		
 		// Validate inputs
 		if fromAlbumID == toAlbumID {
 			log.Printf("MoveFavorite: source and destination albums are the same")
-			errorResponse(rw, fmt.Errorf("source and destination albums cannot be the same"), 400)
+			errorResponse(rw, apierrors.Validation("source and destination albums cannot be the same"))
 			return
 		}
 		
@@ -419,14 +428,14 @@ func MoveFavorite() http.HandlerFunc {
 		fromAlbumObjectID, err := primitive.ObjectIDFromHex(fromAlbumID)
 		if err != nil {
 			log.Printf("MoveFavorite: invalid fromAlbumID format: %v", err)
-			errorResponse(rw, fmt.Errorf("invalid source album ID"), 400)
+			errorResponse(rw, apierrors.Validation("invalid source album ID"))
 			return
 		}
 		
 		toAlbumObjectID, err := primitive.ObjectIDFromHex(toAlbumID)
 		if err != nil {
 			log.Printf("MoveFavorite: invalid toAlbumID format: %v", err)
-			errorResponse(rw, fmt.Errorf("invalid destination album ID"), 400)
+			errorResponse(rw, apierrors.Validation("invalid destination album ID"))
 			return
 		}
 		
@@ -442,12 +451,12 @@ func MoveFavorite() http.HandlerFunc {
 		
 		if err == mongo.ErrNoDocuments {
 			log.Printf("MoveFavorite: source album not found or doesn't belong to user")
-			errorResponse(rw, fmt.Errorf("source album not found"), 404)
+			errorResponse(rw, apierrors.NotFound("source album not found"))
 			return
 		}
 		if err != nil {
 			log.Printf("MoveFavorite: error finding source album: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		
@@ -459,12 +468,12 @@ func MoveFavorite() http.HandlerFunc {
 		
 		if err == mongo.ErrNoDocuments {
 			log.Printf("MoveFavorite: destination album not found or doesn't belong to user")
-			errorResponse(rw, fmt.Errorf("destination album not found"), 404)
+			errorResponse(rw, apierrors.NotFound("destination album not found"))
 			return
 		}
 		if err != nil {
 			log.Printf("MoveFavorite: error finding destination album: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		
@@ -478,12 +487,12 @@ func MoveFavorite() http.HandlerFunc {
 		
 		if err == mongo.ErrNoDocuments {
 			log.Printf("MoveFavorite: favorite not found in source album")
-			errorResponse(rw, fmt.Errorf("content not found in source album"), 404)
+			errorResponse(rw, apierrors.NotFound("content not found in source album"))
 			return
 		}
 		if err != nil {
 			log.Printf("MoveFavorite: error finding favorite: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		
@@ -503,7 +512,7 @@ func MoveFavorite() http.HandlerFunc {
 			})
 			if err != nil {
 				log.Printf("MoveFavorite: error deleting from source: %v", err)
-				errorResponse(rw, err, 500)
+				errorResponse(rw, err)
 				return
 			}
 			
@@ -527,13 +536,13 @@ func MoveFavorite() http.HandlerFunc {
 		
 		if err != nil {
 			log.Printf("MoveFavorite: error updating favorite: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		
 		if updateResult.ModifiedCount == 0 {
 			log.Printf("MoveFavorite: no documents were modified")
-			errorResponse(rw, fmt.Errorf("failed to move favorite"), 500)
+			errorResponse(rw, apierrors.Internal("failed to move favorite", err))
 			return
 		}
 		
@@ -557,16 +566,10 @@ func MoveFavorite() http.HandlerFunc {
 		
 		log.Printf("MoveFavorite: successfully moved content from '%s' to '%s'", fromAlbum.Title, toAlbum.Title)
 		
-		rw.Header().Set("Content-Type", "application/json")
-		rw.WriteHeader(http.StatusOK)
-		json.NewEncoder(rw).Encode(map[string]interface{}{
-			"status":  200,
-			"message": fmt.Sprintf("Content moved from '%s' to '%s' successfully", fromAlbum.Title, toAlbum.Title),
-			"data": map[string]interface{}{
-				"fromAlbum": fromAlbum.Title,
-				"toAlbum":   toAlbum.Title,
-				"contentID": contentID,
-			},
+		writeResponse(rw, http.StatusOK, fmt.Sprintf("Content moved from '%s' to '%s' successfully", fromAlbum.Title, toAlbum.Title), map[string]interface{}{
+			"fromAlbum": fromAlbum.Title,
+			"toAlbum":   toAlbum.Title,
+			"contentID": contentID,
 		})
 	}
 }
@@ -578,6 +581,10 @@ func GetUserFavorites() http.HandlerFunc {
 		
 		vars := mux.Vars(r)
 		userID := vars["UserID"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		
 		log.Printf("GetUserFavorites: userID=%s", userID)
 		
@@ -589,7 +596,7 @@ func GetUserFavorites() http.HandlerFunc {
 		cursor, err := albumsCollection.Find(ctx, bson.M{"userID": userID})
 		if err != nil {
 			log.Printf("GetUserFavorites: error finding albums: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		defer cursor.Close(ctx)
@@ -597,7 +604,7 @@ func GetUserFavorites() http.HandlerFunc {
 		var albums []models.Album
 		if err = cursor.All(ctx, &albums); err != nil {
 			log.Printf("GetUserFavorites: error decoding albums: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		
@@ -605,15 +612,9 @@ func GetUserFavorites() http.HandlerFunc {
 		
 		// If no albums, return empty structure
 		if len(albums) == 0 {
-			rw.Header().Set("Content-Type", "application/json")
-			rw.WriteHeader(http.StatusOK)
-			json.NewEncoder(rw).Encode(map[string]interface{}{
-				"status":  200,
-				"message": "success",
-				"data": map[string]interface{}{
-					"userID": userID,
-					"albums": []interface{}{},
-				},
+			writeResponse(rw, http.StatusOK, "success", map[string]interface{}{
+				"userID": userID,
+				"albums": []interface{}{},
 			})
 			return
 		}
@@ -725,15 +726,9 @@ func GetUserFavorites() http.HandlerFunc {
 		}
 		
 		// Return response
-		rw.Header().Set("Content-Type", "application/json")
-		rw.WriteHeader(http.StatusOK)
-		json.NewEncoder(rw).Encode(map[string]interface{}{
-			"status":  200,
-			"message": "success",
-			"data": map[string]interface{}{
-				"userID": userID,
-				"albums": albumsWithFavorites,
-			},
+		writeResponse(rw, http.StatusOK, "success", map[string]interface{}{
+			"userID": userID,
+			"albums": albumsWithFavorites,
 		})
 	}
 }
@@ -745,6 +740,10 @@ func GetUserAlbums() http.HandlerFunc {
 		
 		vars := mux.Vars(r)
 		userID := vars["UserID"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		
 		log.Printf("GetUserAlbums: userID=%s", userID)
 		
@@ -754,7 +753,7 @@ func GetUserAlbums() http.HandlerFunc {
 		cursor, err := albumsCollection.Find(ctx, bson.M{"userID": userID})
 		if err != nil {
 			log.Printf("GetUserAlbums: error finding albums: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		defer cursor.Close(ctx)
@@ -762,7 +761,7 @@ func GetUserAlbums() http.HandlerFunc {
 		var albums []models.Album
 		if err = cursor.All(ctx, &albums); err != nil {
 			log.Printf("GetUserAlbums: error decoding albums: %v", err)
-			errorResponse(rw, err, 500)
+			errorResponse(rw, err)
 			return
 		}
 		
@@ -806,15 +805,9 @@ func GetUserAlbums() http.HandlerFunc {
 		}
 		
 		// Return response
-		rw.Header().Set("Content-Type", "application/json")
-		rw.WriteHeader(http.StatusOK)
-		json.NewEncoder(rw).Encode(map[string]interface{}{
-			"status":  200,
-			"message": "success",
-			"data": map[string]interface{}{
-				"userID": userID,
-				"albums": albumsWithCount,
-			},
+		writeResponse(rw, http.StatusOK, "success", map[string]interface{}{
+			"userID": userID,
+			"albums": albumsWithCount,
 		})
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"time"
+	"upload-service/apierrors"
 	"upload-service/configs"
 	"upload-service/models"
 
@@ -20,7 +21,7 @@ func getFeedbackCollection() *mongo.Collection {
 // SubmitFeedback handles POST requests to submit feedback
 func SubmitFeedback() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		logger := configs.LogWithContext("feedback", "submit")
+		logger := configs.LogWithRequest(r.Context(), "feedback", "submit")
 		start := time.Now()
 
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -29,7 +30,7 @@ func SubmitFeedback() http.HandlerFunc {
 		var feedbackRequest models.FeedbackRequest
 		if err := json.NewDecoder(r.Body).Decode(&feedbackRequest); err != nil {
 			logger.Error("Failed to decode feedback request", "error", err)
-			errorResponse(rw, err, http.StatusBadRequest)
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
 			return
 		}
 
@@ -38,14 +39,14 @@ func SubmitFeedback() http.HandlerFunc {
 		// Validate that at least email or phone is provided
 		if feedbackRequest.Email == "" && feedbackRequest.Phone == "" {
 			logger.Warn("Feedback validation failed: no contact information provided")
-			errorResponse(rw, &json.SyntaxError{}, http.StatusBadRequest)
+			errorResponse(rw, apierrors.Validation("email or phone is required"))
 			return
 		}
 
 		// Validate that comment is not empty
 		if feedbackRequest.Comment == "" {
 			logger.Warn("Feedback validation failed: comment is empty")
-			errorResponse(rw, &json.SyntaxError{}, http.StatusBadRequest)
+			errorResponse(rw, apierrors.Validation("comment is required"))
 			return
 		}
 
@@ -59,7 +60,7 @@ func SubmitFeedback() http.HandlerFunc {
 		result, err := getFeedbackCollection().InsertOne(ctx, feedback)
 		if err != nil {
 			logger.Error("Failed to insert feedback", "error", err)
-			errorResponse(rw, err, http.StatusInternalServerError)
+			errorResponse(rw, err)
 			return
 		}
 
@@ -72,7 +73,11 @@ func SubmitFeedback() http.HandlerFunc {
 // GetFeedback handles GET requests to retrieve all feedback
 func GetFeedback() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		logger := configs.LogWithContext("feedback", "get-all")
+		if err := requireAdmin(r, ACTION_READ_ALL_FEEDBACK); err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		logger := configs.LogWithRequest(r.Context(), "feedback", "get-all")
 		start := time.Now()
 
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -85,7 +90,7 @@ func GetFeedback() http.HandlerFunc {
 		cursor, err := getFeedbackCollection().Find(ctx, bson.M{}, findOptions)
 		if err != nil {
 			logger.Error("Failed to query feedback collection", "error", err)
-			errorResponse(rw, err, http.StatusInternalServerError)
+			errorResponse(rw, err)
 			return
 		}
 		defer cursor.Close(ctx)
@@ -93,7 +98,7 @@ func GetFeedback() http.HandlerFunc {
 		var feedback []models.Feedback
 		if err = cursor.All(ctx, &feedback); err != nil {
 			logger.Error("Failed to decode feedback documents", "error", err)
-			errorResponse(rw, err, http.StatusInternalServerError)
+			errorResponse(rw, err)
 			return
 		}
 
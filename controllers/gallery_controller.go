@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"upload-service/apierrors"
+	"upload-service/configs"
+	"upload-service/media"
+	"upload-service/models"
+	"upload-service/storage"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Gallery (carousel) posts hold an ordered list of pictures. Each item goes
+// through the same pipeline as a single picture post; the first item is
+// mirrored onto the Content's own fields so older clients show it as cover.
+
+const (
+	TYPE_GALLERY      = "gallery"
+	MAX_GALLERY_ITEMS = 20
+)
+
+// pictureExtensionFor maps an accepted picture MIME type to its extension
+func pictureExtensionFor(mimeType string) string {
+	switch mimeType {
+	case "image/png":
+		return "png"
+	case "image/jpeg":
+		return "jpeg"
+	case "image/webp":
+		return "webp"
+	case "image/heic", "image/heif":
+		return "heic"
+	}
+	return ""
+}
+
+func PostGalleryWithBody() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := requestContext(r, 5*time.Minute)
+		defer cancel()
+
+		vars := mux.Vars(r)
+		userID := vars["UserID"]
+		tags := vars["Tags"]
+		visibility := vars["Visibility"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		show, _ := strconv.ParseBool(vars["Show"])
+		ispayperview, _ := strconv.ParseBool(vars["IsPayPerView"])
+		isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
+		price, err := strconv.ParseFloat(vars["PPVPrice"], 64)
+		if err != nil {
+			configs.LogWithRequest(r.Context(), "content", "create-gallery").Debug("Invalid price in PPVPrice", "ppvprice", vars["PPVPrice"])
+			price = 0
+		}
+		if visibility != VISIBILITY_FOLLOWERS {
+			visibility = VISIBILITY_EVERYONE
+		}
+
+		r.Body = http.MaxBytesReader(rw, r.Body, MAX_GALLERY_ITEMS*10*MB)
+		if err := r.ParseMultipartForm(10 * MB); err != nil {
+			errorResponse(rw, apierrors.Validation(fmt.Sprintf("error parsing form: %v", err)))
+			return
+		}
+
+		jsonData := r.FormValue("data")
+		if jsonData == "" {
+			errorResponse(rw, apierrors.Validation("missing data"))
+			return
+		}
+		var contentBody models.ContentBody
+		if err := json.Unmarshal([]byte(jsonData), &contentBody); err != nil {
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
+			return
+		}
+
+		files := r.MultipartForm.File["files"]
+		if len(files) == 0 {
+			errorResponse(rw, apierrors.Validation("no files uploaded"))
+			return
+		}
+		if len(files) > MAX_GALLERY_ITEMS {
+			errorResponse(rw, apierrors.Validation(fmt.Sprintf("a gallery holds at most %d pictures", MAX_GALLERY_ITEMS)))
+			return
+		}
+
+		store := storage.Backend()
+		items := make([]models.MediaItem, 0, len(files))
+		// drop what was already stored if a later item is rejected
+		abort := func(err error) {
+			for i := range items {
+				releaseBlob(ctx, configs.EnvPicturesBucket(), items[i].S3RawKey)
+			}
+			errorResponse(rw, err)
+		}
+
+		for i, fileHeader := range files {
+			file, err := fileHeader.Open()
+			if err != nil {
+				abort(apierrors.Wrap(apierrors.VALIDATION, err))
+				return
+			}
+			fileBytes, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				abort(apierrors.Validation(fmt.Sprintf("failed to read %s", fileHeader.Filename)))
+				return
+			}
+
+			mimeType := mimetype.Detect(fileBytes).String()
+			extension := pictureExtensionFor(mimeType)
+			if extension == "" {
+				abort(apierrors.New(apierrors.UNSUPPORTED_MEDIA_TYPE, fmt.Sprintf("%s: this file type is not allowed for images", fileHeader.Filename)))
+				return
+			}
+
+			itemID := strings.Replace(uuid.New().String(), "-", "", -1)
+			pic, err := storePicture(ctx, userID, itemID, fileBytes, mimeType, extension)
+			if err == ErrBannedImage {
+				abort(apierrors.Unprocessable(fmt.Sprintf("%s: %v", fileHeader.Filename, err)))
+				return
+			}
+			var rejected *apierrors.Error
+			if errors.As(err, &rejected) {
+				abort(apierrors.New(rejected.Code, fmt.Sprintf("%s: %s", fileHeader.Filename, rejected.Message)))
+				return
+			}
+			if err != nil {
+				configs.LogWithRequest(r.Context(), "content", "create-gallery").Error("Error storing gallery item", "filename", fileHeader.Filename, "error", err)
+				abort(apierrors.Upstream(fmt.Sprintf("error uploading %s", fileHeader.Filename), err))
+				return
+			}
+
+			item := models.MediaItem{
+				ID:           itemID,
+				Location:     store.PublicURL(configs.EnvPicturesBucket(), pic.OriginalKey),
+				Posting:      store.PublicURL(configs.EnvPicturesBucket(), pic.ThumbnailKey),
+				S3RawKey:     pic.OriginalKey,
+				ThumbnailKey: pic.ThumbnailKey,
+				MasterKey:    pic.MasterKey,
+				Variants:     pic.Variants,
+				BlurHash:     pic.BlurHash,
+				Width:        pic.Width,
+				Height:       pic.Height,
+				PHash:        pic.PHash,
+				DHash:        pic.DHash,
+			}
+			if i < len(contentBody.AltTexts) {
+				item.AltText = contentBody.AltTexts[i]
+			}
+			items = append(items, item)
+		}
+
+		newGallery := models.Content{
+			UserID:       userID,
+			Poster:       userID,
+			Title:        contentBody.Title,
+			Description:  contentBody.Description,
+			DateCreated:  time.Now(),
+			Show:         show,
+			IsPayPerView: ispayperview,
+			IsDeleted:    isdeleted,
+			PPVPrice:     price,
+			Type:         TYPE_GALLERY,
+			Visibility:   visibility,
+			Items:        items,
+		}
+		setGalleryCover(&newGallery)
+
+		newGallery.Tags = strings.Split(tags, ",")
+		for i, s := range newGallery.Tags {
+			newGallery.Tags[i] = strings.TrimSpace(s)
+		}
+
+		result, err := getContentCollection().InsertOne(ctx, newGallery)
+		if err != nil {
+			abort(err)
+			return
+		}
+
+		configs.LogWithRequest(r.Context(), "content", "create-gallery").Info("Created gallery", "id", result.InsertedID, "items", len(items), "user_id", userID)
+		successResponse(rw, result)
+	}
+}
+
+// setGalleryCover mirrors the first item onto the Content's own fields
+func setGalleryCover(content *models.Content) {
+	if len(content.Items) == 0 {
+		return
+	}
+	cover := content.Items[0]
+	content.Location = cover.Location
+	content.Posting = cover.Posting
+	content.S3RawKey = cover.S3RawKey
+	content.ThumbnailKey = cover.ThumbnailKey
+	content.MasterKey = cover.MasterKey
+	content.Variants = cover.Variants
+	content.BlurHash = cover.BlurHash
+	content.Width = cover.Width
+	content.Height = cover.Height
+	content.PHash = cover.PHash
+	content.DHash = cover.DHash
+	content.PHashBands = media.HashBands(cover.PHash)
+}
+
+// applyGalleryEdits removes and reorders the items of a gallery post as asked
+// by an edit request. The removed items' media is released once the new
+// list is saved.
+func applyGalleryEdits(ctx context.Context, contentID primitive.ObjectID, body models.ContentBody) error {
+	if len(body.ItemsOrder) == 0 && len(body.RemoveItems) == 0 {
+		return nil
+	}
+
+	content := models.Content{}
+	if err := getContentCollection().FindOne(ctx, bson.M{"_id": contentID, "type": TYPE_GALLERY}).Decode(&content); err != nil {
+		return apierrors.NotFound("gallery not found")
+	}
+
+	remove := map[string]bool{}
+	for _, id := range body.RemoveItems {
+		remove[id] = true
+	}
+	var kept, removed []models.MediaItem
+	byID := map[string]models.MediaItem{}
+	for _, item := range content.Items {
+		if remove[item.ID] {
+			removed = append(removed, item)
+			continue
+		}
+		kept = append(kept, item)
+		byID[item.ID] = item
+	}
+	if len(removed) != len(remove) {
+		return apierrors.Validation("remove_items references unknown items")
+	}
+	if len(kept) == 0 {
+		return apierrors.Validation("a gallery needs at least one picture; delete the post instead")
+	}
+
+	if len(body.ItemsOrder) > 0 {
+		if len(body.ItemsOrder) != len(kept) {
+			return apierrors.Validation("items_order must list every remaining item exactly once")
+		}
+		ordered := make([]models.MediaItem, 0, len(kept))
+		for _, id := range body.ItemsOrder {
+			item, ok := byID[id]
+			if !ok {
+				return apierrors.Validation("items_order must list every remaining item exactly once")
+			}
+			delete(byID, id)
+			ordered = append(ordered, item)
+		}
+		kept = ordered
+	}
+
+	content.Items = kept
+	setGalleryCover(&content)
+	_, err := getContentCollection().UpdateOne(ctx, bson.M{"_id": contentID}, bson.M{"$set": bson.M{
+		"items":         content.Items,
+		"location":      content.Location,
+		"posting":       content.Posting,
+		"s3_raw_key":    content.S3RawKey,
+		"thumbnail_key": content.ThumbnailKey,
+		"master_key":    content.MasterKey,
+		"variants":      content.Variants,
+		"blurhash":      content.BlurHash,
+		"width":         content.Width,
+		"height":        content.Height,
+		"phash":         content.PHash,
+		"dhash":         content.DHash,
+		"phash_bands":   content.PHashBands,
+	}})
+	if err != nil {
+		return err
+	}
+
+	if holdsBlobRefs(&content) {
+		for _, item := range removed {
+			releaseBlob(ctx, configs.EnvPicturesBucket(), item.S3RawKey)
+		}
+	}
+	return nil
+}
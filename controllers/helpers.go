@@ -5,7 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"net/http"
 	"time"
 
 	//"encoding/json"
@@ -32,6 +32,8 @@ const (
 	TRANSCODING_DONE    = "done"
 	TRANSCODING_PENDING = "pending"
 	TRANSCODING_FAILED  = "failed"
+	// the client is still uploading the raw file straight to the bucket
+	TRANSCODING_UPLOADING = "uploading"
 )
 
 func sendNotificationWithData(userID, initiatorID, message, contentID string, notificationType models.NotificationType, ctx context.Context) {
@@ -44,23 +46,30 @@ func sendNotificationWithData(userID, initiatorID, message, contentID string, no
 		Status:      "pending",
 		DateCreated: time.Now(),
 		UpdatedAt:   time.Now(),
+		RequestID:   configs.RequestID(ctx),
 	}
+	logger := configs.LogWithRequest(ctx, "notifications", "publish")
 	jsonData, err := json.Marshal(notificationData)
 	if err != nil {
-		log.Println("Error marshaling notification data:", err)
+		logger.Error("Error marshaling notification data", "error", err)
 		return
 	}
 
-	log.Println("PREPARING TO SEND IT")
-
 	err = configs.GetRedisClient().Publish(ctx, configs.NOTIFICATIONCHANNEL(), jsonData).Err()
 	if err != nil {
-		log.Println("Error publishing notification to Redis:", err)
+		logger.Error("Error publishing notification to Redis", "error", err, "type", notificationType)
 	}
 }
 
-func sendLiveStartedNotification(userID string, contentID string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// requestContext bounds a handler's work to timeout. It keeps the request's
+// values (the caller, the request ID) but, like context.Background, isn't
+// cancelled when the client disconnects.
+func requestContext(r *http.Request, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(r.Context()), timeout)
+}
+
+func sendLiveStartedNotification(parent context.Context, userID string, contentID string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(parent), 10*time.Second)
 	defer cancel()
 	followings := []models.Follow{}
 
@@ -1,10 +1,10 @@
 package controllers
 
 import (
-	"context"
 	"fmt"
 	"net/http"
 	"time"
+	"upload-service/apierrors"
 	"upload-service/configs"
 	"upload-service/models"
 
@@ -20,16 +20,20 @@ func getLikesCollection() *mongo.Collection {
 
 func Like() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := requestContext(r, 10*time.Second)
 		defer cancel()
 		vars := mux.Vars(r)
 		userID := vars["UserID"]
 		likedContent := vars["LikedContent"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		exists := getLikesCollection().FindOne(ctx, bson.M{"userid": userID, "likedcontent": likedContent})
 		existingLike := models.Like{}
 		if err := exists.Decode(&existingLike); err != nil {
 			if err != mongo.ErrNoDocuments {
-				errorResponse(rw, err, 200)
+				errorResponse(rw, err)
 				return
 			}
 			like := models.Like{
@@ -39,20 +43,20 @@ func Like() http.HandlerFunc {
 			}
 			res, err := getLikesCollection().InsertOne(ctx, like)
 			if err != nil {
-				errorResponse(rw, err, 200)
+				errorResponse(rw, err)
 				return
 			}
 			oCID, err := primitive.ObjectIDFromHex(likedContent)
 			if err != nil {
 				fmt.Println("couldn't get content from ", likedContent)
-				errorResponse(rw, err, 200)
+				errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
 				return
 			}
 			content := models.Content{}
 			err = getContentCollection().FindOne(ctx, bson.M{"_id": oCID}).Decode(&content)
 			if err != nil {
 				fmt.Println("couldn't get decode content from ", oCID)
-				errorResponse(rw, err, 200)
+				errorResponse(rw, err)
 				return
 			}
 			sendNotificationWithData(content.UserID, userID, "liked your post", likedContent, models.LikeNotification, ctx)
@@ -61,7 +65,7 @@ func Like() http.HandlerFunc {
 		}
 		delRes, err := getLikesCollection().DeleteOne(ctx, bson.M{"_id": existingLike.ID})
 		if err != nil {
-			errorResponse(rw, err, 200)
+			errorResponse(rw, err)
 			return
 		}
 		successResponse(rw, delRes.DeletedCount)
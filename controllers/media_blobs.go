@@ -0,0 +1,340 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"time"
+	"upload-service/configs"
+	"upload-service/models"
+	"upload-service/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Content-addressed storage of uploaded originals. Uploads are hashed with
+// SHA-256 and recorded in media_blobs; a second upload of the same bytes
+// points its Content at the existing key and bumps the reference count.
+// Reposts take a reference of their own on the original's blobs. Deleting
+// content only hides it: references are dropped when PurgeDeletedContent
+// removes it for good.
+
+func getMediaBlobsCollection() *mongo.Collection {
+	return configs.GetCollection(configs.DB, "media_blobs")
+}
+
+// EnsureMediaBlobIndexes creates the indexes dedup relies on. The unique
+// hash index is what makes concurrent uploads of the same file converge.
+func EnsureMediaBlobIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := getMediaBlobsCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "bucket", Value: 1}, {Key: "hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "bucket", Value: 1}, {Key: "key", Value: 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// looked up before objects are deleted and when purging
+	_, err = getContentCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "s3_raw_key", Value: 1}}},
+		{Keys: bson.D{{Key: "items.s3_raw_key", Value: 1}}},
+		{Keys: bson.D{{Key: "isdeleted", Value: 1}, {Key: "datedeleted", Value: 1}}},
+	})
+	return err
+}
+
+// hashingReader hashes and counts everything read through it
+type hashingReader struct {
+	r    io.Reader
+	h    hash.Hash
+	size int64
+}
+
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{r: r, h: sha256.New()}
+}
+
+func (hr *hashingReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	hr.h.Write(p[:n])
+	hr.size += int64(n)
+	return n, err
+}
+
+func (hr *hashingReader) Sum() string {
+	return hex.EncodeToString(hr.h.Sum(nil))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// reuseBlob takes a reference on an already stored copy of the same bytes.
+// It returns nil when nothing with that hash exists yet.
+func reuseBlob(ctx context.Context, bucket, contentHash string) (*models.MediaBlob, error) {
+	blob := models.MediaBlob{}
+	err := getMediaBlobsCollection().FindOneAndUpdate(ctx,
+		bson.M{"bucket": bucket, "hash": contentHash},
+		bson.M{"$inc": bson.M{"refcount": 1}, "$set": bson.M{"updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&blob)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &blob, nil
+}
+
+// registerBlob records a freshly stored object and takes the first reference.
+// When a concurrent upload of the same bytes got there first, the existing
+// blob is returned with reused set and the caller should drop its own copy.
+func registerBlob(ctx context.Context, blob models.MediaBlob) (*models.MediaBlob, bool, error) {
+	now := time.Now()
+	stored := models.MediaBlob{}
+	var err error
+	// two upserts racing on the unique index can fail once; the retry then
+	// finds the winner's document
+	for attempt := 0; attempt < 2; attempt++ {
+		err = getMediaBlobsCollection().FindOneAndUpdate(ctx,
+			bson.M{"bucket": blob.Bucket, "hash": blob.Hash},
+			bson.M{
+				"$setOnInsert": bson.M{
+					"key":           blob.Key,
+					"thumbnail_key": blob.ThumbnailKey,
+					"master_key":    blob.MasterKey,
+					"variants":      blob.Variants,
+					"blurhash":      blob.BlurHash,
+					"width":         blob.Width,
+					"height":        blob.Height,
+					"phash":         blob.PHash,
+					"dhash":         blob.DHash,
+					"size":          blob.Size,
+					"mime_type":     blob.MimeType,
+					"created_at":    now,
+				},
+				"$inc": bson.M{"refcount": 1},
+				"$set": bson.M{"updated_at": now},
+			},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&stored)
+		if !mongo.IsDuplicateKeyError(err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &stored, stored.Key != blob.Key, nil
+}
+
+// releaseBlob drops one reference to the blob stored at key and deletes the
+// objects once nobody points at them anymore. Objects that predate dedup
+// have no blob and are left alone, since reposts may still share them.
+func releaseBlob(ctx context.Context, bucket, key string) {
+	if key == "" {
+		return
+	}
+	blob := models.MediaBlob{}
+	err := getMediaBlobsCollection().FindOneAndUpdate(ctx,
+		bson.M{"bucket": bucket, "key": key},
+		bson.M{"$inc": bson.M{"refcount": -1}, "$set": bson.M{"updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&blob)
+	if err == mongo.ErrNoDocuments {
+		return
+	}
+	if err != nil {
+		configs.LogWithRequest(ctx, "media", "release").Error("Error releasing media blob", "bucket", bucket, "key", key, "error", err)
+		return
+	}
+	if blob.RefCount > 0 {
+		return
+	}
+	// reposts made before they took references still point at the objects
+	if referenced, err := contentReferencesKey(ctx, key); err != nil || referenced {
+		return
+	}
+
+	// a concurrent upload may have re-referenced the blob in the meantime
+	res, err := getMediaBlobsCollection().DeleteOne(ctx, bson.M{"_id": blob.Id, "refcount": bson.M{"$lte": 0}})
+	if err != nil || res.DeletedCount == 0 {
+		return
+	}
+	for _, objectKey := range pictureKeys(blob.Key, blob.ThumbnailKey, blob.Variants) {
+		deleteStoredObject(bucket, objectKey)
+	}
+	if blob.MasterKey != "" {
+		deleteStoredObject(configs.EnvArchiveBucket(), blob.MasterKey)
+	}
+}
+
+// contentReferencesKey tells whether any content, deleted or not, still
+// points at key
+func contentReferencesKey(ctx context.Context, key string) (bool, error) {
+	count, err := getContentCollection().CountDocuments(ctx,
+		bson.M{"$or": []bson.M{{"s3_raw_key": key}, {"items.s3_raw_key": key}}},
+		options.Count().SetLimit(1))
+	if err != nil {
+		configs.LogWithRequest(ctx, "media", "release").Error("Error checking media references", "key", key, "error", err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// retainBlob takes one more reference on the blob stored at key, if any
+func retainBlob(ctx context.Context, bucket, key string) error {
+	if key == "" {
+		return nil
+	}
+	_, err := getMediaBlobsCollection().UpdateOne(ctx,
+		bson.M{"bucket": bucket, "key": key},
+		bson.M{"$inc": bson.M{"refcount": 1}, "$set": bson.M{"updated_at": time.Now()}})
+	return err
+}
+
+// contentBlobKeys lists the bucket and keys of the blobs a Content points at
+func contentBlobKeys(content *models.Content) (string, []string) {
+	switch content.Type {
+	case TYPE_PIC:
+		return configs.EnvPicturesBucket(), []string{content.S3RawKey}
+	case TYPE_VIDEO:
+		return configs.EnvRawBucket(), []string{content.S3RawKey}
+	case TYPE_GALLERY:
+		keys := make([]string, 0, len(content.Items))
+		for _, item := range content.Items {
+			keys = append(keys, item.S3RawKey)
+		}
+		return configs.EnvPicturesBucket(), keys
+	}
+	return "", nil
+}
+
+// isRepost tells reposts from originals. Reposts approved by hand used not to
+// record their original, but they keep its author as UserID.
+func isRepost(content *models.Content) bool {
+	return content.OriginalID != "" || (content.Poster != "" && content.Poster != content.UserID)
+}
+
+// holdsBlobRefs tells whether a Content holds references on its media
+func holdsBlobRefs(content *models.Content) bool {
+	return !isRepost(content) || content.BlobRef
+}
+
+// retainContentBlobs takes the references a repost about to be inserted
+// holds on the original's media and marks it as holding them
+func retainContentBlobs(ctx context.Context, content *models.Content) error {
+	bucket, keys := contentBlobKeys(content)
+	for i, key := range keys {
+		if err := retainBlob(ctx, bucket, key); err != nil {
+			for _, taken := range keys[:i] {
+				releaseBlob(ctx, bucket, taken)
+			}
+			return err
+		}
+	}
+	content.BlobRef = true
+	return nil
+}
+
+// releaseContentBlobs drops the references a Content holds on its media.
+// Objects are deleted once nothing references them anymore.
+func releaseContentBlobs(ctx context.Context, content *models.Content) {
+	if !holdsBlobRefs(content) {
+		return
+	}
+	bucket, keys := contentBlobKeys(content)
+	for _, key := range keys {
+		releaseBlob(ctx, bucket, key)
+	}
+}
+
+// PurgeDeletedContent periodically removes content deleted longer than
+// CONTENT_PURGE_AFTER ago, together with the media nothing else references
+func PurgeDeletedContent() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purgeDeletedContent()
+	}
+}
+
+func purgeDeletedContent() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	logger := configs.LogWithContext("content", "purge")
+
+	filter := bson.M{"isdeleted": true, "datedeleted": bson.M{"$lt": time.Now().Add(-configs.EnvContentPurgeAfter())}}
+	cur, err := getContentCollection().Find(ctx, filter)
+	if err != nil {
+		logger.Error("Error finding deleted content", "error", err)
+		return
+	}
+	var contents []models.Content
+	if err := cur.All(ctx, &contents); err != nil {
+		logger.Error("Error decoding deleted content", "error", err)
+		return
+	}
+
+	purged := 0
+	for i := range contents {
+		// content restored since it was found is kept
+		res, err := getContentCollection().DeleteOne(ctx, bson.M{"_id": contents[i].Id, "isdeleted": true})
+		if err != nil {
+			logger.Error("Error purging content", "content_id", contents[i].Id.Hex(), "error", err)
+			continue
+		}
+		if res.DeletedCount == 0 {
+			continue
+		}
+		releaseContentBlobs(ctx, &contents[i])
+		purged++
+	}
+	if purged > 0 {
+		logger.Info("Purged deleted content", "count", purged)
+	}
+}
+
+// storeDedupedVideo streams a raw video into the raw bucket under key while
+// hashing it. If the same bytes were uploaded before, the new copy is
+// deleted and the existing key is returned instead.
+func storeDedupedVideo(ctx context.Context, key string, body io.Reader, mimeType string) (string, error) {
+	store := storage.Backend()
+	hr := newHashingReader(body)
+	if err := store.Put(ctx, configs.EnvRawBucket(), key, hr, mimeType); err != nil {
+		return "", err
+	}
+
+	blob, reused, err := registerBlob(ctx, models.MediaBlob{
+		Hash:     hr.Sum(),
+		Bucket:   configs.EnvRawBucket(),
+		Key:      key,
+		Size:     hr.size,
+		MimeType: mimeType,
+	})
+	if err != nil {
+		configs.LogWithRequest(ctx, "media", "store-video").Warn("Error registering media blob", "key", key, "error", err)
+		return key, nil
+	}
+	if reused {
+		configs.LogWithRequest(ctx, "media", "store-video").Info("Video duplicates an existing object, reusing it", "key", key, "existing", blob.Key)
+		deleteStoredObject(configs.EnvRawBucket(), key)
+		return blob.Key, nil
+	}
+	return key, nil
+}
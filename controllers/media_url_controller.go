@@ -1,8 +1,8 @@
 package controllers
 
 import (
-	"encoding/json"
 	"net/http"
+	"upload-service/apierrors"
 	"upload-service/utils"
 
 	"github.com/gorilla/mux"
@@ -22,23 +22,18 @@ func ConvertPathToURL() http.HandlerFunc {
 		filePath := r.URL.Query().Get("path")
 
 		if filePath == "" {
-			http.Error(rw, "path parameter is required", http.StatusBadRequest)
+			errorResponse(rw, apierrors.Validation("path parameter is required"))
 			return
 		}
 
 		url := utils.FilePathToURL(filePath)
 
-		response := map[string]interface{}{
-			"status":  http.StatusOK,
-			"message": "success",
+		writeResponse(rw, http.StatusOK, "success", map[string]interface{}{
 			"data": map[string]string{
 				"original_path": filePath,
 				"url":           url,
 			},
-		}
-
-		rw.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(rw).Encode(response)
+		})
 	}
 }
 
@@ -56,23 +51,18 @@ func ConvertURLToPath() http.HandlerFunc {
 		url := r.URL.Query().Get("url")
 
 		if url == "" {
-			http.Error(rw, "url parameter is required", http.StatusBadRequest)
+			errorResponse(rw, apierrors.Validation("url parameter is required"))
 			return
 		}
 
 		filePath := utils.URLToFilePath(url)
 
-		response := map[string]interface{}{
-			"status":  http.StatusOK,
-			"message": "success",
+		writeResponse(rw, http.StatusOK, "success", map[string]interface{}{
 			"data": map[string]string{
 				"original_url": url,
 				"path":         filePath,
 			},
-		}
-
-		rw.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(rw).Encode(response)
+		})
 	}
 }
 
@@ -97,9 +87,7 @@ func GetMediaInfo() http.HandlerFunc {
 		mediaURL := utils.GetMediaURL(userID, fileType, filename)
 		filePath := "/app/media/" + userID + "/" + fileType + "/" + filename
 
-		response := map[string]interface{}{
-			"status":  http.StatusOK,
-			"message": "success",
+		writeResponse(rw, http.StatusOK, "success", map[string]interface{}{
 			"data": map[string]interface{}{
 				"user_id":   userID,
 				"file_type": fileType,
@@ -107,9 +95,6 @@ func GetMediaInfo() http.HandlerFunc {
 				"url":       mediaURL,
 				"path":      filePath,
 			},
-		}
-
-		rw.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(rw).Encode(response)
+		})
 	}
 }
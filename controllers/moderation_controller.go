@@ -0,0 +1,296 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+	"time"
+	"upload-service/apierrors"
+	"upload-service/auth"
+	"upload-service/configs"
+	"upload-service/media"
+	"upload-service/models"
+
+	"github.com/disintegration/imaging"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Perceptual-hash moderation: moderators ban hashes, uploads close to a
+// banned hash are rejected, and creators are warned when a new picture is a
+// near-duplicate of one of their earlier posts.
+
+var ErrBannedImage = errors.New("this image is not allowed")
+
+// NearDuplicate points at an earlier post that looks like the new upload
+type NearDuplicate struct {
+	ContentID string `json:"content_id"`
+	Distance  int    `json:"distance"`
+}
+
+func getBannedHashesCollection() *mongo.Collection {
+	return configs.GetCollection(configs.DB, "banned_hashes")
+}
+
+// EnsurePerceptualHashIndexes creates the band indexes used to find hashes
+// within a few bits of each other without scanning every document
+func EnsurePerceptualHashIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := getContentCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "phash", Value: 1}}},
+		{Keys: bson.D{{Key: "dhash", Value: 1}}},
+		{Keys: bson.D{{Key: "userid", Value: 1}, {Key: "phash_bands", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = getBannedHashesCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "phash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "bands", Value: 1}}},
+	})
+	return err
+}
+
+// findBannedHash returns the banned hash closest to phash, if any is within
+// the configured distance
+func findBannedHash(ctx context.Context, phash string) (*models.BannedHash, error) {
+	bands := media.HashBands(phash)
+	if bands == nil {
+		return nil, nil
+	}
+	cur, err := getBannedHashesCollection().Find(ctx, bson.M{"bands": bson.M{"$in": bands}})
+	if err != nil {
+		return nil, err
+	}
+	var candidates []models.BannedHash
+	if err := cur.All(ctx, &candidates); err != nil {
+		return nil, err
+	}
+
+	var closest *models.BannedHash
+	best := configs.EnvBannedHashDistance() + 1
+	for i := range candidates {
+		if d := media.HammingDistance(phash, candidates[i].PHash); d >= 0 && d < best {
+			closest, best = &candidates[i], d
+		}
+	}
+	return closest, nil
+}
+
+// rejectBannedHash returns ErrBannedImage when phash matches the banned list.
+// Lookup failures let the upload through rather than blocking every post.
+func rejectBannedHash(ctx context.Context, userID, phash string) error {
+	banned, err := findBannedHash(ctx, phash)
+	if err != nil {
+		fmt.Println("Error checking banned hashes:", err)
+		return nil
+	}
+	if banned == nil {
+		return nil
+	}
+	fmt.Printf("Rejected upload from %s matching banned hash %s\n", userID, banned.PHash)
+	return ErrBannedImage
+}
+
+// rejectBannedPicture decodes an upload just far enough to hash it and check
+// it against the banned list
+func rejectBannedPicture(ctx context.Context, userID string, fileBytes []byte, mimeType string) error {
+	var img image.Image
+	var err error
+	if media.IsHEIC(mimeType) {
+		img, err = media.DecodeHEIC(fileBytes)
+	} else {
+		img, err = imaging.Decode(bytes.NewReader(fileBytes), imaging.AutoOrientation(true))
+	}
+	if err != nil {
+		fmt.Println("Error decoding picture for hashing:", err)
+		return nil
+	}
+	return rejectBannedHash(ctx, userID, media.NewPerceptualHashes(img).PHash)
+}
+
+// findNearDuplicates lists the creator's live posts that look like phash
+func findNearDuplicates(ctx context.Context, userID, phash string) []NearDuplicate {
+	bands := media.HashBands(phash)
+	if bands == nil {
+		return nil
+	}
+	opts := options.Find().SetProjection(bson.M{"phash": 1}).SetLimit(100)
+	cur, err := getContentCollection().Find(ctx, bson.M{
+		"userid":      userID,
+		"isdeleted":   false,
+		"phash_bands": bson.M{"$in": bands},
+	}, opts)
+	if err != nil {
+		fmt.Println("Error looking for near-duplicates:", err)
+		return nil
+	}
+	var candidates []models.Content
+	if err := cur.All(ctx, &candidates); err != nil {
+		fmt.Println("Error decoding near-duplicates:", err)
+		return nil
+	}
+
+	var duplicates []NearDuplicate
+	for _, c := range candidates {
+		if d := media.HammingDistance(phash, c.PHash); d >= 0 && d <= configs.EnvNearDuplicateDistance() {
+			duplicates = append(duplicates, NearDuplicate{ContentID: c.Id.Hex(), Distance: d})
+		}
+	}
+	return duplicates
+}
+
+// nearDuplicateWarnings turns near-duplicates into the warnings returned
+// alongside a successful upload
+func nearDuplicateWarnings(duplicates []NearDuplicate) []map[string]interface{} {
+	warnings := []map[string]interface{}{}
+	for _, d := range duplicates {
+		warnings = append(warnings, map[string]interface{}{
+			"type":       "near_duplicate",
+			"message":    "near-duplicate of your earlier post",
+			"content_id": d.ContentID,
+			"distance":   d.Distance,
+		})
+	}
+	return warnings
+}
+
+type BannedHashRequest struct {
+	PHash     string `json:"phash"`
+	ContentID string `json:"content_id"`
+	Reason    string `json:"reason"`
+	AddedBy   string `json:"added_by"`
+}
+
+// AddBannedHash bans a perceptual hash, given directly or taken from an
+// existing post
+func AddBannedHash() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if err := requireAdmin(r, ACTION_MODERATE); err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var body BannedHashRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			errorResponse(rw, apierrors.Validation("invalid request body"))
+			return
+		}
+		if adminID := auth.UserID(r.Context()); adminID != "" {
+			body.AddedBy = adminID
+		}
+
+		if body.PHash == "" && body.ContentID != "" {
+			oID, err := primitive.ObjectIDFromHex(body.ContentID)
+			if err != nil {
+				errorResponse(rw, apierrors.Validation("invalid content ID"))
+				return
+			}
+			content := models.Content{}
+			if err := getContentCollection().FindOne(ctx, bson.M{"_id": oID}).Decode(&content); err != nil {
+				errorResponse(rw, apierrors.NotFound("content not found"))
+				return
+			}
+			if content.PHash == "" {
+				errorResponse(rw, apierrors.Unprocessable("content has no perceptual hash"))
+				return
+			}
+			body.PHash = content.PHash
+		}
+		if _, err := media.ParseHash(body.PHash); err != nil {
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
+			return
+		}
+
+		banned := models.BannedHash{
+			PHash:     body.PHash,
+			Bands:     media.HashBands(body.PHash),
+			Reason:    body.Reason,
+			ContentID: body.ContentID,
+			AddedBy:   body.AddedBy,
+			CreatedAt: time.Now(),
+		}
+		result, err := getBannedHashesCollection().InsertOne(ctx, banned)
+		if mongo.IsDuplicateKeyError(err) {
+			errorResponse(rw, apierrors.Conflict("hash is already banned"))
+			return
+		}
+		if err != nil {
+			errorResponse(rw, err)
+			return
+		}
+
+		fmt.Printf("Banned perceptual hash %s (%s)\n", banned.PHash, banned.Reason)
+		successResponse(rw, result)
+	}
+}
+
+func GetBannedHashes() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if err := requireAdmin(r, ACTION_MODERATE); err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		vars := mux.Vars(r)
+		limit, _ := strconv.ParseInt(vars["limit"], 10, 64)
+		skip, _ := strconv.ParseInt(vars["skip"], 10, 64)
+		if limit <= 0 || limit > 100 {
+			limit = 100
+		}
+
+		opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(limit).SetSkip(skip)
+		cur, err := getBannedHashesCollection().Find(ctx, bson.M{}, opts)
+		if err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		banned := []models.BannedHash{}
+		if err := cur.All(ctx, &banned); err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		successResponse(rw, banned)
+	}
+}
+
+func RemoveBannedHash() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if err := requireAdmin(r, ACTION_MODERATE); err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		oID, err := primitive.ObjectIDFromHex(mux.Vars(r)["HashID"])
+		if err != nil {
+			errorResponse(rw, apierrors.Validation("invalid hash ID"))
+			return
+		}
+		result, err := getBannedHashesCollection().DeleteOne(ctx, bson.M{"_id": oID})
+		if err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		if result.DeletedCount == 0 {
+			errorResponse(rw, apierrors.NotFound("banned hash not found"))
+			return
+		}
+		successResponse(rw, "Banned hash removed")
+	}
+}
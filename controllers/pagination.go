@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"upload-service/apierrors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DEFAULT_PAGE_SIZE = 20
+	MAX_PAGE_SIZE     = 100
+)
+
+// pageCursor is the position just past the last item of a page: that item's
+// sort key and its _id, which orders items sharing a sort key. Clients get it
+// as an opaque string and hand it back unchanged.
+type pageCursor struct {
+	Key time.Time          `json:"k"`
+	ID  primitive.ObjectID `json:"id"`
+}
+
+func encodeCursor(cursor pageCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(raw string) (pageCursor, error) {
+	var cursor pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil || cursor.ID.IsZero() {
+		return pageCursor{}, apierrors.Validation("invalid cursor")
+	}
+	return cursor, nil
+}
+
+// pageRequest is the page a list endpoint was asked for through its limit and
+// cursor query parameters. Pages run newest first.
+type pageRequest struct {
+	Limit  int64
+	Cursor *pageCursor
+}
+
+func parsePageRequest(r *http.Request) (pageRequest, error) {
+	query := r.URL.Query()
+	page := pageRequest{Limit: DEFAULT_PAGE_SIZE}
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || limit < 1 || limit > MAX_PAGE_SIZE {
+			return pageRequest{}, apierrors.Validation("limit must be between 1 and " + strconv.Itoa(MAX_PAGE_SIZE))
+		}
+		page.Limit = limit
+	}
+	if raw := query.Get("cursor"); raw != "" {
+		cursor, err := decodeCursor(raw)
+		if err != nil {
+			return pageRequest{}, err
+		}
+		page.Cursor = &cursor
+	}
+	return page, nil
+}
+
+// after selects the items following the cursor when sorting by keyField, or
+// everything on the first page
+func (p pageRequest) after(keyField string) bson.M {
+	if p.Cursor == nil {
+		return bson.M{}
+	}
+	return bson.M{"$or": []bson.M{
+		{keyField: bson.M{"$lt": p.Cursor.Key}},
+		{keyField: p.Cursor.Key, "_id": bson.M{"$lt": p.Cursor.ID}},
+	}}
+}
+
+// sort orders items newest first by keyField
+func (p pageRequest) sort(keyField string) bson.D {
+	return bson.D{{Key: keyField, Value: -1}, {Key: "_id", Value: -1}}
+}
+
+// findOptions sorts by keyField and fetches one item more than the page so
+// pageOf can tell whether another page follows
+func (p pageRequest) findOptions(keyField string) *options.FindOptions {
+	return options.Find().SetSort(p.sort(keyField)).SetLimit(p.Limit + 1)
+}
+
+// stages are the aggregation stages selecting the page from the documents
+// matching match, sorted by keyField like findOptions. Lookups and other
+// per-item stages belong after them so they only run for the page.
+func (p pageRequest) stages(keyField string, match bson.M) []bson.M {
+	return []bson.M{
+		{"$match": bson.M{"$and": []bson.M{match, p.after(keyField)}}},
+		{"$sort": p.sort(keyField)},
+		{"$limit": p.Limit + 1},
+	}
+}
+
+// pageOf trims items fetched with findOptions or stages to the page and
+// returns the cursor of the next page, "" on the last one
+func pageOf[T any](p pageRequest, items []T, key func(T) (time.Time, primitive.ObjectID)) ([]T, string) {
+	if int64(len(items)) <= p.Limit {
+		return items, ""
+	}
+	items = items[:p.Limit]
+	sortKey, id := key(items[len(items)-1])
+	return items, encodeCursor(pageCursor{Key: sortKey, ID: id})
+}
+
+// pageData is the data object of a list response; handlers may add to it,
+// e.g. a total count
+func pageData(items interface{}, nextCursor string) map[string]interface{} {
+	return map[string]interface{}{
+		"data":        items,
+		"next_cursor": nextCursor,
+	}
+}
+
+// writePage responds with one page of a list
+func writePage(rw http.ResponseWriter, items interface{}, nextCursor string) {
+	writeResponse(rw, http.StatusOK, "success", pageData(items, nextCursor))
+}
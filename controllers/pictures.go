@@ -0,0 +1,327 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"upload-service/apierrors"
+	"upload-service/configs"
+	"upload-service/media"
+	"upload-service/models"
+	"upload-service/storage"
+
+	"github.com/disintegration/imaging"
+)
+
+// width of the legacy single thumbnail that Content.Posting points at
+const THUMBNAIL_WIDTH = 585
+
+// storedPicture is where storePicture put a picture and its derivatives
+type storedPicture struct {
+	OriginalKey  string
+	ThumbnailKey string
+	MasterKey    string
+	Variants     []models.ImageVariant
+	BlurHash     string
+	Width        int
+	Height       int
+	PHash        string
+	DHash        string
+}
+
+func pictureFromBlob(blob *models.MediaBlob) *storedPicture {
+	pic := &storedPicture{
+		OriginalKey:  blob.Key,
+		ThumbnailKey: blob.ThumbnailKey,
+		MasterKey:    blob.MasterKey,
+		Variants:     blob.Variants,
+		BlurHash:     blob.BlurHash,
+		Width:        blob.Width,
+		Height:       blob.Height,
+		PHash:        blob.PHash,
+		DHash:        blob.DHash,
+	}
+	if pic.ThumbnailKey == "" {
+		pic.ThumbnailKey = pic.OriginalKey
+	}
+	return pic
+}
+
+// storePicture uploads an original picture and its responsive derivatives to
+// the pictures bucket. HEIC uploads are converted to a web-safe JPEG first and
+// kept untouched in the archive bucket. Pictures that can't be decoded,
+// including HEIC ones, are rejected with an *apierrors.Error. Pictures
+// already stored with the same SHA-256 are not processed again; their
+// existing keys are returned instead.
+// Pictures matching a banned perceptual hash are rejected with ErrBannedImage.
+func storePicture(ctx context.Context, userID, imageID string, fileBytes []byte, mimeType, extension string) (*storedPicture, error) {
+	bucket := configs.EnvPicturesBucket()
+	// hashed as uploaded so retries are recognised before any processing
+	contentHash := sha256Hex(fileBytes)
+	uploadedSize := int64(len(fileBytes))
+	logger := configs.LogWithRequest(ctx, "pictures", "store")
+
+	existing, err := reuseBlob(ctx, bucket, contentHash)
+	if err != nil {
+		logger.Warn("Error looking up media blob", "error", err)
+	}
+	if existing != nil {
+		// the ban list may have grown since this picture was first stored
+		if err := rejectBannedHash(ctx, userID, existing.PHash); err != nil {
+			releaseBlob(ctx, bucket, existing.Key)
+			return nil, err
+		}
+		logger.Info("Picture already stored, reusing it", "bucket", bucket, "key", existing.Key)
+		return pictureFromBlob(existing), nil
+	}
+
+	store := storage.Backend()
+	pic := &storedPicture{}
+
+	master, masterType, masterExtension := fileBytes, mimeType, extension
+	fileBytes, mimeType, extension, err = webSafePicture(ctx, fileBytes, mimeType, extension)
+	if err != nil {
+		return nil, err
+	}
+
+	fileBytes, mimeType, err = sanitizePicture(ctx, userID, imageID, fileBytes, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	if ext := pictureExtensionFor(mimeType); ext != "" {
+		extension = ext
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(fileBytes))
+	if err != nil {
+		logger.Warn("Error decoding image", "error", err)
+		img = nil
+	}
+	if img != nil {
+		hashes := media.NewPerceptualHashes(img)
+		pic.PHash, pic.DHash = hashes.PHash, hashes.DHash
+		if err := rejectBannedHash(ctx, userID, pic.PHash); err != nil {
+			return nil, err
+		}
+	}
+
+	if media.IsHEIC(masterType) {
+		pic.MasterKey = fmt.Sprintf("%s/masters/%s.%s", userID, imageID, masterExtension)
+		logger.Debug("Archiving HEIC master", "bucket", configs.EnvArchiveBucket(), "key", pic.MasterKey)
+		if err := store.Put(ctx, configs.EnvArchiveBucket(), pic.MasterKey, bytes.NewReader(master), masterType); err != nil {
+			return nil, err
+		}
+	}
+
+	s3OriginalKey := fmt.Sprintf("%s/%s.%s", userID, imageID, extension)
+
+	logger.Debug("Uploading original image", "bucket", bucket, "key", s3OriginalKey)
+
+	err = store.Put(ctx, bucket, s3OriginalKey, bytes.NewReader(fileBytes), mimeType)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Original image uploaded")
+
+	// the original doubles as thumbnail when no derivative could be made
+	pic.OriginalKey, pic.ThumbnailKey = s3OriginalKey, s3OriginalKey
+	if img != nil {
+		pic.Variants = uploadImageVariants(ctx, userID, imageID, img)
+		if placeholder, err := media.NewPlaceholder(img); err != nil {
+			logger.Warn("Error computing blurhash", "error", err)
+		} else {
+			pic.BlurHash, pic.Width, pic.Height = placeholder.BlurHash, placeholder.Width, placeholder.Height
+		}
+	}
+	if thumb := thumbnailVariant(pic.Variants); thumb != nil {
+		pic.ThumbnailKey = thumb.Key
+	}
+
+	blob, reused, err := registerBlob(ctx, models.MediaBlob{
+		Hash:         contentHash,
+		Bucket:       bucket,
+		Key:          pic.OriginalKey,
+		ThumbnailKey: pic.ThumbnailKey,
+		MasterKey:    pic.MasterKey,
+		Variants:     pic.Variants,
+		BlurHash:     pic.BlurHash,
+		Width:        pic.Width,
+		Height:       pic.Height,
+		PHash:        pic.PHash,
+		DHash:        pic.DHash,
+		Size:         uploadedSize,
+		MimeType:     mimeType,
+	})
+	if err != nil {
+		logger.Warn("Error registering media blob", "error", err)
+		return pic, nil
+	}
+	if reused {
+		// lost the race against a concurrent upload of the same picture
+		for _, key := range pictureKeys(pic.OriginalKey, pic.ThumbnailKey, pic.Variants) {
+			deleteStoredObject(bucket, key)
+		}
+		deleteStoredObject(configs.EnvArchiveBucket(), pic.MasterKey)
+		return pictureFromBlob(blob), nil
+	}
+	return pic, nil
+}
+
+// webSafePicture converts a HEIC/HEIF upload to JPEG and returns other
+// pictures as they are. A HEIC that can't be decoded is rejected, as it could
+// be neither displayed, stripped of its metadata nor checked against the ban
+// list.
+func webSafePicture(ctx context.Context, fileBytes []byte, mimeType, extension string) ([]byte, string, string, error) {
+	if !media.IsHEIC(mimeType) {
+		return fileBytes, mimeType, extension, nil
+	}
+	webSafe, err := convertHEIC(fileBytes)
+	if err != nil {
+		configs.LogWithRequest(ctx, "pictures", "convert-heic").Warn("Error decoding HEIC", "error", err)
+		return nil, "", "", apierrors.Validation("the HEIC image could not be decoded")
+	}
+	return webSafe, media.ContentTypeFor(media.FORMAT_JPEG), "jpeg", nil
+}
+
+// convertHEIC decodes a HEIC/HEIF upload into a JPEG that browsers can show
+func convertHEIC(data []byte) ([]byte, error) {
+	img, err := media.DecodeHEIC(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := media.Encode(&buf, img, media.FORMAT_JPEG); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sanitizePicture applies the EXIF orientation and strips privacy-sensitive
+// metadata (GPS, camera serials, XMP, ...) before a picture is stored, and
+// records what was removed in the audit log. Formats the sanitizer can't
+// parse, and pictures it fails on, are re-encoded from their pixels, so the
+// returned MIME type may differ from the upload's. Pictures that can't be
+// decoded at all are rejected rather than stored with their metadata.
+func sanitizePicture(ctx context.Context, userID, imageID string, fileBytes []byte, mimeType string) ([]byte, string, error) {
+	storedType := mimeType
+	sanitized, err := media.Sanitize(fileBytes, mimeType, configs.EnvImageMetadataAllowlist())
+	if err != nil {
+		configs.LogWithRequest(ctx, "pictures", "sanitize").Warn("Could not strip metadata, re-encoding it", "image_id", imageID, "mime", mimeType, "error", err)
+		img, decodeErr := imaging.Decode(bytes.NewReader(fileBytes), imaging.AutoOrientation(true))
+		if decodeErr != nil {
+			return nil, "", apierrors.Validation("the file could not be read as an image")
+		}
+		format := encodingFormatFor(mimeType)
+		var buf bytes.Buffer
+		if err := media.Encode(&buf, img, format); err != nil {
+			return nil, "", err
+		}
+		storedType = media.ContentTypeFor(format)
+		sanitized = &media.Sanitized{Data: buf.Bytes(), Orientation: media.ORIENTATION_NORMAL, Removed: []string{"all"}}
+	}
+
+	if len(sanitized.Removed) > 0 || sanitized.Orientation != media.ORIENTATION_NORMAL {
+		configs.LogWithRequest(ctx, "pictures", "sanitize").Debug("Stripped metadata", "image_id", imageID, "fields", len(sanitized.Removed), "orientation", sanitized.Orientation)
+		writeAuditLog(ctx, AUDIT_METADATA_STRIPPED, userID, imageID, map[string]interface{}{
+			"mime_type":     mimeType,
+			"stored_type":   storedType,
+			"removed":       sanitized.Removed,
+			"orientation":   sanitized.Orientation,
+			"original_size": len(fileBytes),
+			"stored_size":   len(sanitized.Data),
+		})
+	}
+	return sanitized.Data, storedType, nil
+}
+
+// encodingFormatFor picks the encoder matching an upload's type. Other
+// formats become PNG when they may carry transparency, JPEG otherwise.
+func encodingFormatFor(mimeType string) string {
+	switch mimeType {
+	case "image/webp":
+		return media.FORMAT_WEBP
+	case "image/png", "image/gif", "image/bmp", "image/tiff":
+		return media.FORMAT_PNG
+	}
+	return media.FORMAT_JPEG
+}
+
+// uploadImageVariants stores every configured width/format derivative of img.
+// Derivatives that fail to upload are left out rather than failing the post.
+func uploadImageVariants(ctx context.Context, userID, imageID string, img image.Image) []models.ImageVariant {
+	logger := configs.LogWithRequest(ctx, "pictures", "variants")
+	derivatives, err := media.Derive(img, configs.EnvImageVariantWidths(), configs.EnvImageVariantFormats())
+	if err != nil {
+		logger.Warn("Error generating image variants", "error", err)
+		return nil
+	}
+
+	store := storage.Backend()
+	bucket := configs.EnvPicturesBucket()
+	variants := make([]models.ImageVariant, 0, len(derivatives))
+	for _, d := range derivatives {
+		key := fmt.Sprintf("%s/%s_%d.%s", userID, imageID, d.Width, media.ExtensionFor(d.Format))
+		if err := store.Put(ctx, bucket, key, bytes.NewReader(d.Data), d.ContentType); err != nil {
+			logger.Warn("Error uploading variant", "key", key, "error", err)
+			continue
+		}
+		variants = append(variants, models.ImageVariant{
+			Width:  d.Width,
+			Height: d.Height,
+			Format: d.Format,
+			Key:    key,
+			URL:    store.PublicURL(bucket, key),
+		})
+	}
+	logger.Debug("Uploaded image variants", "image_id", imageID, "count", len(variants))
+	return variants
+}
+
+// thumbnailVariant picks the rendition closest to the legacy thumbnail
+// width, preferring JPEG since older clients may not decode WebP
+func thumbnailVariant(variants []models.ImageVariant) *models.ImageVariant {
+	var best *models.ImageVariant
+	for i := range variants {
+		v := &variants[i]
+		if best == nil {
+			best = v
+			continue
+		}
+		if (v.Format == media.FORMAT_JPEG) != (best.Format == media.FORMAT_JPEG) {
+			if v.Format == media.FORMAT_JPEG {
+				best = v
+			}
+			continue
+		}
+		if absInt(v.Width-THUMBNAIL_WIDTH) < absInt(best.Width-THUMBNAIL_WIDTH) {
+			best = v
+		}
+	}
+	return best
+}
+
+// pictureKeys lists every distinct object key a stored picture occupies
+func pictureKeys(originalKey, thumbnailKey string, variants []models.ImageVariant) []string {
+	seen := map[string]bool{}
+	var keys []string
+	add := func(key string) {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	add(originalKey)
+	add(thumbnailKey)
+	for _, v := range variants {
+		add(v.Key)
+	}
+	return keys
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
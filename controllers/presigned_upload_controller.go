@@ -0,0 +1,394 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"upload-service/apierrors"
+	"upload-service/configs"
+	"upload-service/models"
+	"upload-service/storage"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Presigned direct-to-bucket video uploads. The client asks for an upload,
+// PUTs the parts straight to S3 with the returned URLs and then calls the
+// complete endpoint, so the bytes never pass through this service.
+
+// S3 refuses multipart uploads with more parts than this
+const MAX_MULTIPART_PARTS = 10000
+
+type PresignedInitiateRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+type presignedPart struct {
+	PartNumber int32  `json:"part_number"`
+	URL        string `json:"url"`
+}
+
+type PresignedCompleteRequest struct {
+	Parts []storage.CompletedPart `json:"parts"`
+}
+
+func PresignedInitiateUpload() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		vars := mux.Vars(r)
+		userID := vars["UserID"]
+		visibility := vars["Visibility"]
+		tags := vars["Tags"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		show, _ := strconv.ParseBool(vars["Show"])
+		ispayperview, _ := strconv.ParseBool(vars["IsPayPerView"])
+		isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
+		price, err := strconv.ParseFloat(vars["PPVPrice"], 64)
+		if err != nil {
+			price = 0
+		}
+		if visibility != VISIBILITY_FOLLOWERS {
+			visibility = VISIBILITY_EVERYONE
+		}
+
+		var body PresignedInitiateRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, fmt.Errorf("invalid request body: %w", err)))
+			return
+		}
+		ext := videoExtensionFor(body.ContentType, body.Filename)
+		if ext == "" {
+			errorResponse(rw, apierrors.New(apierrors.UNSUPPORTED_MEDIA_TYPE, fmt.Sprintf("unsupported video type: %s", body.ContentType)))
+			return
+		}
+		if body.Size <= 0 {
+			errorResponse(rw, apierrors.Validation("size is required"))
+			return
+		}
+		if body.Size > configs.EnvMaxVideoSize() {
+			errorResponse(rw, apierrors.New(apierrors.PAYLOAD_TOO_LARGE, fmt.Sprintf("upload exceeds maximum size of %d bytes", configs.EnvMaxVideoSize())))
+			return
+		}
+
+		presigner, ok := storage.Backend().(storage.MultipartPresigner)
+		if !ok {
+			errorResponse(rw, apierrors.New(apierrors.NOT_IMPLEMENTED, fmt.Sprintf("presigned uploads are not supported by the %s storage backend", configs.EnvStorageBackend())))
+			return
+		}
+
+		partSize := configs.EnvPresignedPartSize()
+		if minPart := (body.Size + MAX_MULTIPART_PARTS - 1) / MAX_MULTIPART_PARTS; partSize < minPart {
+			partSize = minPart
+		}
+		partCount := int32((body.Size + partSize - 1) / partSize)
+
+		videoID := strings.Replace(uuid.New().String(), "-", "", -1)
+		s3VideoKey := fmt.Sprintf("%s/%s.%s", userID, videoID, ext)
+		multipartID, err := presigner.CreateMultipartUpload(ctx, configs.EnvRawBucket(), s3VideoKey, body.ContentType)
+		if err != nil {
+			errorResponse(rw, apierrors.Upstream("starting multipart upload failed", err))
+			return
+		}
+
+		expiry := configs.EnvPresignedURLExpiry()
+		parts := make([]presignedPart, 0, partCount)
+		for n := int32(1); n <= partCount; n++ {
+			url, err := presigner.PresignUploadPart(ctx, configs.EnvRawBucket(), s3VideoKey, multipartID, n, expiry)
+			if err != nil {
+				presigner.AbortMultipartUpload(ctx, configs.EnvRawBucket(), s3VideoKey, multipartID)
+				errorResponse(rw, apierrors.Upstream("presigning upload part failed", err))
+				return
+			}
+			parts = append(parts, presignedPart{PartNumber: n, URL: url})
+		}
+
+		newPostVid := models.Content{
+			VideoID:      videoID,
+			UserID:       userID,
+			Poster:       userID,
+			Title:        body.Title,
+			Description:  body.Description,
+			S3RawKey:     s3VideoKey,
+			DateCreated:  time.Now(),
+			Show:         show,
+			IsPayPerView: ispayperview,
+			IsDeleted:    isdeleted,
+			PPVPrice:     price,
+			Type:         TYPE_VIDEO,
+			Visibility:   visibility,
+			Transcoding:  TRANSCODING_UPLOADING,
+		}
+		newPostVid.Tags = strings.Split(tags, ",")
+		for i, s := range newPostVid.Tags {
+			newPostVid.Tags[i] = strings.TrimSpace(s)
+		}
+		result, err := getContentCollection().InsertOne(ctx, newPostVid)
+		if err != nil {
+			presigner.AbortMultipartUpload(ctx, configs.EnvRawBucket(), s3VideoKey, multipartID)
+			errorResponse(rw, apierrors.Internal("failed to create content", err))
+			return
+		}
+		contentID := result.InsertedID.(primitive.ObjectID).Hex()
+
+		now := time.Now()
+		upload := models.Upload{
+			ID:           videoID,
+			Method:       UPLOAD_METHOD_PRESIGNED,
+			MultipartID:  multipartID,
+			RawKey:       s3VideoKey,
+			UserID:       userID,
+			Length:       body.Size,
+			Metadata:     map[string]string{"title": body.Title, "description": body.Description, "filename": body.Filename},
+			Parts:        []models.UploadPart{},
+			MimeType:     body.ContentType,
+			Extension:    ext,
+			Show:         show,
+			IsPayPerView: ispayperview,
+			IsDeleted:    isdeleted,
+			PPVPrice:     price,
+			Tags:         newPostVid.Tags,
+			Visibility:   visibility,
+			Status:       UPLOAD_STATUS_UPLOADING,
+			ContentID:    contentID,
+			VideoID:      videoID,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+			ExpiresAt:    now.Add(expiry),
+		}
+		if _, err := getUploadsCollection().InsertOne(ctx, upload); err != nil {
+			abortPresignedUpload(ctx, &upload)
+			errorResponse(rw, apierrors.Internal("failed to record upload", err))
+			return
+		}
+
+		configs.LogWithRequest(r.Context(), "uploads", "presign").Info("Created presigned upload", "upload_id", upload.ID, "user_id", userID, "size", body.Size, "parts", partCount)
+
+		successResponse(rw, map[string]interface{}{
+			"upload_id":  upload.ID,
+			"content_id": contentID,
+			"video_id":   videoID,
+			"key":        s3VideoKey,
+			"part_size":  partSize,
+			"parts":      parts,
+			"expires_at": upload.ExpiresAt,
+		})
+	}
+}
+
+func PresignedCompleteUpload() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		// long enough to download the video for probing
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		defer cancel()
+
+		upload := models.Upload{}
+		err := getUploadsCollection().FindOne(ctx, bson.M{"_id": mux.Vars(r)["UploadID"], "method": UPLOAD_METHOD_PRESIGNED}).Decode(&upload)
+		if err == mongo.ErrNoDocuments {
+			errorResponse(rw, apierrors.NotFound("upload not found"))
+			return
+		}
+		if err != nil {
+			errorResponse(rw, apierrors.Internal("failed to load upload", err))
+			return
+		}
+		if err := authorizeUser(r, upload.UserID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		switch {
+		case upload.Status == UPLOAD_STATUS_COMPLETED:
+			successResponse(rw, map[string]string{"content_id": upload.ContentID, "video_id": upload.VideoID})
+			return
+		case upload.Status == UPLOAD_STATUS_TERMINATED:
+			errorResponse(rw, apierrors.New(apierrors.GONE, "upload was terminated"))
+			return
+		case upload.Status == UPLOAD_STATUS_UPLOADING && time.Now().After(upload.ExpiresAt):
+			errorResponse(rw, apierrors.New(apierrors.GONE, "upload expired"))
+			return
+		}
+
+		store := storage.Backend()
+		// A retry after S3 assembled the object carries on from there: the
+		// multipart upload is gone and completing it again would fail
+		if upload.Status == UPLOAD_STATUS_UPLOADING {
+			var body PresignedCompleteRequest
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, fmt.Errorf("invalid request body: %w", err)))
+				return
+			}
+			if len(body.Parts) == 0 {
+				errorResponse(rw, apierrors.Validation("parts are required"))
+				return
+			}
+
+			presigner, ok := store.(storage.MultipartPresigner)
+			if !ok {
+				errorResponse(rw, apierrors.New(apierrors.NOT_IMPLEMENTED, fmt.Sprintf("presigned uploads are not supported by the %s storage backend", configs.EnvStorageBackend())))
+				return
+			}
+			if err := presigner.CompleteMultipartUpload(ctx, configs.EnvRawBucket(), upload.RawKey, upload.MultipartID, body.Parts); err != nil {
+				errorResponse(rw, apierrors.Upstream("completing multipart upload failed", err))
+				return
+			}
+			res, err := getUploadsCollection().UpdateOne(ctx,
+				bson.M{"_id": upload.ID, "status": UPLOAD_STATUS_UPLOADING},
+				bson.M{"$set": bson.M{"status": UPLOAD_STATUS_COMPLETING, "updated_at": time.Now()}})
+			if err != nil {
+				errorResponse(rw, apierrors.Internal("failed to record completed upload", err))
+				return
+			}
+			if res.MatchedCount == 0 {
+				errorResponse(rw, apierrors.Conflict("upload is already being completed"))
+				return
+			}
+			upload.Status = UPLOAD_STATUS_COMPLETING
+		}
+
+		if err := verifyPresignedObject(ctx, store, &upload); err != nil {
+			configs.LogWithRequest(r.Context(), "uploads", "complete-presigned").Warn("Presigned upload failed verification", "upload_id", upload.ID, "error", err)
+			rejectPresignedUpload(ctx, &upload)
+			errorResponse(rw, apierrors.Wrap(apierrors.UNPROCESSABLE, err))
+			return
+		}
+		mediaInfo, err := inspectStoredVideo(ctx, r, store, configs.EnvRawBucket(), upload.RawKey)
+		var rejected *apierrors.Error
+		if errors.As(err, &rejected) {
+			configs.LogWithRequest(r.Context(), "uploads", "complete-presigned").Warn("Presigned upload rejected", "upload_id", upload.ID, "error", err)
+			rejectPresignedUpload(ctx, &upload)
+			errorResponse(rw, err)
+			return
+		}
+		if err != nil {
+			errorResponse(rw, apierrors.Internal("failed to inspect video", err))
+			return
+		}
+
+		set := bson.M{"transcoding": TRANSCODING_PENDING}
+		if mediaInfo != nil {
+			set["media_info"] = mediaInfo
+		}
+		contentObjectID, _ := primitive.ObjectIDFromHex(upload.ContentID)
+		res, err := getContentCollection().UpdateOne(ctx,
+			bson.M{"_id": contentObjectID, "transcoding": TRANSCODING_UPLOADING},
+			bson.M{"$set": set})
+		if err != nil {
+			errorResponse(rw, apierrors.Internal("failed to update content", err))
+			return
+		}
+		if res.MatchedCount > 0 {
+			enqueueTranscoding(ctx, contentObjectID, upload.VideoID, upload.UserID, upload.RawKey)
+		} else if !presignedContentReleased(ctx, contentObjectID) {
+			// the Content no longer waits for this video
+			errorResponse(rw, apierrors.Conflict("upload's content is no longer awaiting the video"))
+			return
+		}
+		_, err = getUploadsCollection().UpdateOne(ctx, bson.M{"_id": upload.ID}, bson.M{"$set": bson.M{
+			"status":     UPLOAD_STATUS_COMPLETED,
+			"offset":     upload.Length,
+			"updated_at": time.Now(),
+		}})
+		if err != nil {
+			errorResponse(rw, apierrors.Internal("failed to record completed upload", err))
+			return
+		}
+
+		configs.LogWithRequest(r.Context(), "uploads", "complete-presigned").Info("Presigned upload completed", "upload_id", upload.ID, "content_id", upload.ContentID)
+		successResponse(rw, map[string]string{"content_id": upload.ContentID, "video_id": upload.VideoID})
+	}
+}
+
+// presignedContentReleased tells whether an earlier attempt already handed the
+// upload's Content over to transcoding, so a retry only has to finish up
+func presignedContentReleased(ctx context.Context, contentID primitive.ObjectID) bool {
+	content := models.Content{}
+	if err := getContentCollection().FindOne(ctx, bson.M{"_id": contentID}).Decode(&content); err != nil {
+		return false
+	}
+	return content.Transcoding != TRANSCODING_UPLOADING && content.Transcoding != TRANSCODING_FAILED
+}
+
+// verifyPresignedObject checks that what landed in the bucket is the video
+// the client announced when the upload was initiated
+func verifyPresignedObject(ctx context.Context, store storage.Storage, upload *models.Upload) error {
+	info, err := store.Head(ctx, configs.EnvRawBucket(), upload.RawKey)
+	if err != nil {
+		return fmt.Errorf("uploaded object not found: %w", err)
+	}
+	if info.Size != upload.Length {
+		return fmt.Errorf("uploaded size %d does not match announced size %d", info.Size, upload.Length)
+	}
+
+	rc, _, err := store.Get(ctx, configs.EnvRawBucket(), upload.RawKey)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(rc, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	mime := mimetype.Detect(head[:n]).String()
+	if videoExtensionFor(mime, upload.Metadata["filename"]) == "" {
+		return fmt.Errorf("uploaded object is not a supported video (%s)", mime)
+	}
+	return nil
+}
+
+// rejectPresignedUpload removes an upload that failed verification together
+// with the pending Content created for it
+func rejectPresignedUpload(ctx context.Context, upload *models.Upload) {
+	deleteStoredObject(configs.EnvRawBucket(), upload.RawKey)
+	markPresignedContentFailed(ctx, upload)
+	_, err := getUploadsCollection().UpdateOne(ctx, bson.M{"_id": upload.ID}, bson.M{"$set": bson.M{
+		"status":     UPLOAD_STATUS_TERMINATED,
+		"updated_at": time.Now(),
+	}})
+	if err != nil {
+		configs.LogWithRequest(ctx, "uploads", "terminate").Error("Error terminating upload", "upload_id", upload.ID, "error", err)
+	}
+}
+
+// abortPresignedUpload releases the parts S3 is holding for an unfinished
+// multipart upload and hides its pending Content
+func abortPresignedUpload(ctx context.Context, upload *models.Upload) {
+	if presigner, ok := storage.Backend().(storage.MultipartPresigner); ok {
+		if err := presigner.AbortMultipartUpload(ctx, configs.EnvRawBucket(), upload.RawKey, upload.MultipartID); err != nil {
+			configs.LogWithRequest(ctx, "uploads", "terminate").Error("Error aborting multipart upload", "upload_id", upload.ID, "error", err)
+		}
+	}
+	markPresignedContentFailed(ctx, upload)
+}
+
+func markPresignedContentFailed(ctx context.Context, upload *models.Upload) {
+	contentObjectID, err := primitive.ObjectIDFromHex(upload.ContentID)
+	if err != nil {
+		return
+	}
+	_, err = getContentCollection().UpdateOne(ctx,
+		bson.M{"_id": contentObjectID, "transcoding": TRANSCODING_UPLOADING},
+		bson.M{"$set": bson.M{"transcoding": TRANSCODING_FAILED, "isdeleted": true}})
+	if err != nil {
+		configs.LogWithRequest(ctx, "uploads", "terminate").Error("Error marking content failed", "upload_id", upload.ID, "error", err)
+	}
+}
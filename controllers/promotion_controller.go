@@ -1,7 +1,9 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,12 +13,15 @@ import (
 	"strings"
 	"time"
 
+	"upload-service/apierrors"
 	"upload-service/configs"
 	"upload-service/models"
 
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 func PostVideoToPostgres() http.HandlerFunc {
@@ -27,6 +32,10 @@ func PostVideoToPostgres() http.HandlerFunc {
 		visibility := vars["Visibility"]
 		description := vars["Description"]
 		tags := vars["Tags"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
 		show, _ := strconv.ParseBool(vars["Show"])
 		isPayPerView, _ := strconv.ParseBool(vars["IsPayPerView"])
 		isDeleted, _ := strconv.ParseBool(vars["IsDeleted"])
@@ -74,7 +83,7 @@ func PostVideoToPostgres() http.HandlerFunc {
 		err = os.MkdirAll(newPostVid.Location, 0777)
 		if err != nil {
 			fmt.Println("Error creating directory:", err)
-			http.Error(rw, "Error creating directory", http.StatusInternalServerError)
+			errorResponse(rw, apierrors.Internal("Error creating directory", err))
 			return
 		}
 
@@ -84,7 +93,7 @@ func PostVideoToPostgres() http.HandlerFunc {
 		file, fileHeader, err := r.FormFile("video")
 		if err != nil {
 			fmt.Println("Error retrieving the file:", err)
-			http.Error(rw, "Error retrieving file", http.StatusBadRequest)
+			errorResponse(rw, apierrors.Validation("Error retrieving file"))
 			return
 		}
 		defer file.Close()
@@ -93,12 +102,12 @@ func PostVideoToPostgres() http.HandlerFunc {
 		fileHeaderBuffer := make([]byte, 512)
 		if _, err := file.Read(fileHeaderBuffer); err != nil {
 			fmt.Println("Error reading file header:", err)
-			http.Error(rw, "Error reading file", http.StatusInternalServerError)
+			errorResponse(rw, apierrors.Validation("Error reading file"))
 			return
 		}
 		if _, err := file.Seek(0, 0); err != nil {
 			fmt.Println("Error resetting file pointer:", err)
-			http.Error(rw, "Error resetting file", http.StatusInternalServerError)
+			errorResponse(rw, apierrors.Internal("Error resetting file", err))
 			return
 		}
 
@@ -119,12 +128,17 @@ func PostVideoToPostgres() http.HandlerFunc {
 			// Handle octet-stream: infer extension from the uploaded filename
 			extension = filepath.Ext(fileHeader.Filename)
 			if extension == "" {
-				http.Error(rw, "Unsupported video format and no extension found", http.StatusBadRequest)
+				errorResponse(rw, apierrors.New(apierrors.UNSUPPORTED_MEDIA_TYPE, "Unsupported video format and no extension found"))
 				return
 			}
 			extension = extension[1:] // Remove the dot from the extension
 		default:
-			http.Error(rw, "Unsupported video format", http.StatusBadRequest)
+			errorResponse(rw, apierrors.New(apierrors.UNSUPPORTED_MEDIA_TYPE, "Unsupported video format"))
+			return
+		}
+
+		if _, err := inspectUploadedVideo(r.Context(), r, file); err != nil {
+			errorResponse(rw, err)
 			return
 		}
 
@@ -136,7 +150,7 @@ func PostVideoToPostgres() http.HandlerFunc {
 		outFile, err := os.OpenFile(videoFilePath, os.O_WRONLY|os.O_CREATE, 0666)
 		if err != nil {
 			fmt.Println("Error creating video file:", err)
-			http.Error(rw, "Error saving video file", http.StatusInternalServerError)
+			errorResponse(rw, apierrors.Internal("Error saving video file", err))
 			return
 		}
 		defer outFile.Close()
@@ -145,7 +159,7 @@ func PostVideoToPostgres() http.HandlerFunc {
 		_, err = io.Copy(outFile, file)
 		if err != nil {
 			fmt.Println("Error saving video file:", err)
-			http.Error(rw, "Error saving video file", http.StatusInternalServerError)
+			errorResponse(rw, apierrors.Internal("Error saving video file", err))
 			return
 		}
 
@@ -156,7 +170,7 @@ func PostVideoToPostgres() http.HandlerFunc {
 		result := configs.PGDB.Table("promoted_content").Create(&newPostVid)
 		if result.Error != nil {
 			fmt.Println("Error inserting video content into PostgreSQL:", result.Error)
-			http.Error(rw, "Error saving content", http.StatusInternalServerError)
+			errorResponse(rw, apierrors.Internal("Error saving content", result.Error))
 			return
 		}
 
@@ -177,7 +191,7 @@ func RegisterUser() http.HandlerFunc {
 		// Decode the request body into the newUser struct
 		err := json.NewDecoder(r.Body).Decode(&newUser)
 		if err != nil {
-			errorResponse(w, fmt.Errorf("bad request: invalid input"), 400)
+			errorResponse(w, apierrors.Validation("bad request: invalid input"))
 			return
 		}
 		// Check if a user with the same email already exists
@@ -185,12 +199,12 @@ func RegisterUser() http.HandlerFunc {
 		err = configs.PGDB.Table("promotion_users").Where("email = ?", newUser.Email).Count(&count).Error
 		if err != nil {
 			fmt.Println(err)
-			errorResponse(w, fmt.Errorf("error checking if user exists"), 500)
+			errorResponse(w, apierrors.Internal("error checking if user exists", err))
 			return
 		}
 
 		if count >= 1 {
-			errorResponse(w, fmt.Errorf("user with this email already exists"), 400)
+			errorResponse(w, apierrors.Conflict("user with this email already exists"))
 			return
 		}
 
@@ -205,7 +219,7 @@ func RegisterUser() http.HandlerFunc {
 		// Hash the user's password before storing
 		newUser.Password, err = hashPassword(newUser.Password)
 		if err != nil {
-			errorResponse(w, fmt.Errorf("couldn't hash password"), 500)
+			errorResponse(w, apierrors.Internal("couldn't hash password", err))
 			return
 		}
 
@@ -219,7 +233,7 @@ func RegisterUser() http.HandlerFunc {
 		newUser.Categories = nil
 		result := configs.PGDB.Table("promotion_users").Create(&newUser)
 		if result.Error != nil {
-			errorResponse(w, fmt.Errorf("couldn't store new user: %v", result.Error), 500)
+			errorResponse(w, apierrors.Internal("couldn't store new user", result.Error))
 			return
 		}
 
@@ -227,12 +241,41 @@ func RegisterUser() http.HandlerFunc {
 	}
 }
 
+// authorizePromotion checks the caller owns the content to promote. Posts
+// made for promoting live in PostgreSQL, everything else in MongoDB.
+func authorizePromotion(r *http.Request, contentID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	oID, err := primitive.ObjectIDFromHex(contentID)
+	if err != nil {
+		return apierrors.Validation("invalid content ID")
+	}
+	_, err = authorizeContentOwner(ctx, r, ACTION_PROMOTE_CONTENT, oID)
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	content := models.Content{}
+	err = configs.PGDB.WithContext(ctx).Table("promoted_content").Where("_id = ?", contentID).First(&content).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apierrors.NotFound("content not found")
+	}
+	if err != nil {
+		return apierrors.Internal("failed to load content", err)
+	}
+	return authorize(r, ACTION_PROMOTE_CONTENT, contentID, ownerPolicy(content.UserID), adminPolicy)
+}
+
 func Promote() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 
 		// Extract parameters from the route
 		contentID := vars["ContentID"]
+		if err := authorizePromotion(r, contentID); err != nil {
+			errorResponse(w, err)
+			return
+		}
 		startStr := vars["Start"]
 		endStr := vars["End"]
 		targetAgeStr := vars["TargetAge"]
@@ -240,36 +283,36 @@ func Promote() http.HandlerFunc {
 		amountStr := vars["Amount"]
 		startDate, err := time.Parse("2006-01-02", startStr)
 		if err != nil {
-			errorResponse(w, err, 200)
+			errorResponse(w, apierrors.Wrap(apierrors.VALIDATION, err))
 			return
 		}
 
 		endDate, err := time.Parse("2006-01-02", endStr)
 		if err != nil {
-			errorResponse(w, err, 200)
+			errorResponse(w, apierrors.Wrap(apierrors.VALIDATION, err))
 			return
 		}
 		targetAges := strings.Split(targetAgeStr, ",")
 		if len(targetAges) != 2 {
-			errorResponse(w, fmt.Errorf("error parsing target ages"), 200)
+			errorResponse(w, apierrors.Validation("error parsing target ages"))
 			return
 		}
 
 		startTargetAge, err := strconv.ParseInt(strings.TrimSpace(targetAges[0]), 10, 64)
 		if err != nil {
-			errorResponse(w, err, 200)
+			errorResponse(w, apierrors.Wrap(apierrors.VALIDATION, err))
 			return
 		}
 
 		endTargetAge, err := strconv.ParseInt(strings.TrimSpace(targetAges[1]), 10, 64)
 		if err != nil {
-			errorResponse(w, err, 200)
+			errorResponse(w, apierrors.Wrap(apierrors.VALIDATION, err))
 			return
 		}
 
 		amount, err := strconv.ParseFloat(amountStr, 64)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Invalid amount: %v", err), http.StatusBadRequest)
+			errorResponse(w, apierrors.Validation(fmt.Sprintf("Invalid amount: %v", err)))
 			return
 		}
 
@@ -285,7 +328,7 @@ func Promote() http.HandlerFunc {
 
 		result := configs.PGDB.Create(&newPromotion)
 		if result.Error != nil {
-			errorResponse(w, result.Error, 200)
+			errorResponse(w, result.Error)
 			return
 		}
 		successResponse(w, "ok")
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
+	"upload-service/apierrors"
 	"upload-service/configs"
 	"upload-service/models"
 
@@ -14,7 +16,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func getRepostRequestCollection() *mongo.Collection {
@@ -29,17 +30,21 @@ const (
 
 func RepostRequest() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := requestContext(r, 10*time.Second)
 		defer cancel()
 		repostRequest := models.RepostRequest{}
 
 		err := json.NewDecoder(r.Body).Decode(&repostRequest)
 		if err != nil {
-			errorResponse(w, fmt.Errorf("couldn't decode body"), 200)
+			errorResponse(w, apierrors.Validation("couldn't decode body"))
 			return
 		}
 		if repostRequest.RepostRequest == repostRequest.RequestTo {
-			errorResponse(w, fmt.Errorf("invalid repost request"), 200)
+			errorResponse(w, apierrors.Validation("invalid repost request"))
+			return
+		}
+		if err := authorizeUser(r, repostRequest.RepostRequest); err != nil {
+			errorResponse(w, err)
 			return
 		}
 		response := struct {
@@ -53,34 +58,34 @@ func RepostRequest() http.HandlerFunc {
 
 		oID, err := primitive.ObjectIDFromHex(ownerID)
 		if err != nil {
-			errorResponse(w, fmt.Errorf("invalid object id"), 200)
+			errorResponse(w, apierrors.Validation("invalid object id"))
 			return
 		}
 		err = getUsersCollection().FindOne(ctx, bson.M{"_id": oID}).Decode(&userObj)
 		if err != nil {
-			errorResponse(w, fmt.Errorf("couldn't find/decode user"), 200)
+			errorResponse(w, apierrors.From(err))
 			return
 		}
 		if userObj.MySettings.RepostRequestAction == "Approve" {
 			contentOID, err := primitive.ObjectIDFromHex(repostRequest.ContentID)
 			if err != nil {
-				errorResponse(w, err, 200)
+				errorResponse(w, apierrors.Wrap(apierrors.VALIDATION, err))
 				return
 			}
 			contentResult := getContentCollection().FindOne(ctx, bson.M{"_id": contentOID})
 			content := models.Content{}
 			err = contentResult.Decode(&content)
 			if err != nil {
-				errorResponse(w, err, 200)
+				errorResponse(w, err)
 				return
 			}
 			content.OriginalID = repostRequest.ContentID
 			content.Poster = repostRequest.RepostRequest
 			content.DateCreated = time.Now()
 			content.Id = primitive.NilObjectID
-			contentRes, err := getContentCollection().InsertOne(ctx, content)
+			contentRes, err := insertRepost(ctx, &content)
 			if err != nil {
-				errorResponse(w, fmt.Errorf("couldn't insert into content"), 200)
+				errorResponse(w, apierrors.Internal("couldn't insert into content", err))
 				return
 			}
 			response.Result = contentRes
@@ -100,7 +105,7 @@ func RepostRequest() http.HandlerFunc {
 				res, err := getRepostRequestCollection().InsertOne(ctx, repostRequest)
 				if err != nil {
 					fmt.Println(err)
-					errorResponse(w, fmt.Errorf("couldn't insert into repost requests"), 200)
+					errorResponse(w, apierrors.Internal("couldn't insert into repost requests", err))
 					return
 				}
 				response.Action = "Repost Request"
@@ -108,13 +113,13 @@ func RepostRequest() http.HandlerFunc {
 				successResponse(w, response)
 				return
 			}
-			errorResponse(w, fmt.Errorf("something went wrong"), 200)
+			errorResponse(w, apierrors.Internal("something went wrong", err))
 			return
 		}
 		//if it exists delete repost request
 		deleteResult, err := getRepostRequestCollection().DeleteOne(ctx, bson.M{"repostRequest": repostRequest.RepostRequest, "requestTo": repostRequest.RequestTo})
 		if err != nil {
-			errorResponse(w, fmt.Errorf("couldn't remove repost request"), 200)
+			errorResponse(w, apierrors.Internal("couldn't remove repost request", err))
 			return
 		}
 		response.Action = "Repost Request Removed"
@@ -125,14 +130,14 @@ func RepostRequest() http.HandlerFunc {
 
 func ApproveRequest() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := requestContext(r, 10*time.Second)
 		defer cancel()
 		vars := mux.Vars(r)
 		requestID := vars["requestID"]
 
 		oID, err := primitive.ObjectIDFromHex(requestID)
 		if err != nil {
-			errorResponse(w, fmt.Errorf("invalid object id"), 200)
+			errorResponse(w, apierrors.Validation("invalid object id"))
 			return
 		}
 		filter := bson.M{"_id": oID}
@@ -140,33 +145,46 @@ func ApproveRequest() http.HandlerFunc {
 		request := models.RepostRequest{}
 		err = res.Decode(&request)
 		if err != nil {
-			errorResponse(w, err, 200)
+			errorResponse(w, err)
+			return
+		}
+		if err := authorize(r, ACTION_ANSWER_REPOST, requestID, userPolicy(POLICY_REQUEST_RECIPIENT, request.RequestTo), adminPolicy); err != nil {
+			errorResponse(w, err)
 			return
 		}
 		contentOID, err := primitive.ObjectIDFromHex(request.ContentID)
 		if err != nil {
-			errorResponse(w, err, 200)
+			errorResponse(w, apierrors.Wrap(apierrors.VALIDATION, err))
 			return
 		}
 		contentResult := getContentCollection().FindOne(ctx, bson.M{"_id": contentOID})
 		content := models.Content{}
 		err = contentResult.Decode(&content)
 		if err != nil {
-			errorResponse(w, err, 200)
+			errorResponse(w, err)
 			return
 		}
-		content.Poster = request.RepostRequest
-		content.DateCreated = time.Now()
-		content.Id = primitive.NilObjectID
-		contentRes, err := getContentCollection().InsertOne(ctx, content)
+
+		// accept the request before reposting so answering it twice can't
+		// repost twice
+		pending := bson.M{"_id": oID, "status": STATUS_PENDING}
+		accepted, err := getRepostRequestCollection().UpdateOne(ctx, pending, bson.M{"$set": bson.M{"status": STATUS_ACCEPTED, "updated_at": time.Now()}})
 		if err != nil {
-			errorResponse(w, fmt.Errorf("couldn't insert into content"), 200)
+			errorResponse(w, apierrors.Internal("failed to accept request", err))
+			return
+		}
+		if accepted.MatchedCount == 0 {
+			errorResponse(w, apierrors.Conflict("repost request was already answered"))
 			return
 		}
-		_, err = getRepostRequestCollection().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"status": STATUS_ACCEPTED}})
+		content.OriginalID = request.ContentID
+		content.Poster = request.RepostRequest
+		content.DateCreated = time.Now()
+		content.Id = primitive.NilObjectID
+		contentRes, err := insertRepost(ctx, &content)
 		if err != nil {
-			getContentCollection().DeleteOne(ctx, bson.M{"_id": contentRes.InsertedID})
-			errorResponse(w, fmt.Errorf("failed to accept request"), 200)
+			getRepostRequestCollection().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"status": STATUS_PENDING}})
+			errorResponse(w, apierrors.Internal("couldn't insert into content", err))
 			return
 		}
 		response := struct {
@@ -180,6 +198,20 @@ func ApproveRequest() http.HandlerFunc {
 	}
 }
 
+// insertRepost stores a copy of the original as a repost, with references of
+// its own on the original's media so neither outlives the other's files
+func insertRepost(ctx context.Context, repost *models.Content) (*mongo.InsertOneResult, error) {
+	if err := retainContentBlobs(ctx, repost); err != nil {
+		return nil, err
+	}
+	res, err := getContentCollection().InsertOne(ctx, repost)
+	if err != nil {
+		releaseContentBlobs(ctx, repost)
+		return nil, err
+	}
+	return res, nil
+}
+
 func DeclineRequest() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -189,13 +221,22 @@ func DeclineRequest() http.HandlerFunc {
 
 		oID, err := primitive.ObjectIDFromHex(requestID)
 		if err != nil {
-			errorResponse(w, fmt.Errorf("invalid object id"), 200)
+			errorResponse(w, apierrors.Validation("invalid object id"))
 			return
 		}
 		filter := bson.M{"_id": oID}
+		request := models.RepostRequest{}
+		if err := getRepostRequestCollection().FindOne(ctx, filter).Decode(&request); err != nil {
+			errorResponse(w, err)
+			return
+		}
+		if err := authorize(r, ACTION_ANSWER_REPOST, requestID, userPolicy(POLICY_REQUEST_RECIPIENT, request.RequestTo), adminPolicy); err != nil {
+			errorResponse(w, err)
+			return
+		}
 		res, err := getRepostRequestCollection().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"status": STATUS_DECLINED}})
 		if err != nil {
-			errorResponse(w, fmt.Errorf("couldn't decline the request"), 200)
+			errorResponse(w, apierrors.Internal("couldn't decline the request", err))
 			return
 		}
 
@@ -209,109 +250,183 @@ func DeclineRequest() http.HandlerFunc {
 	}
 }
 
-func GetRepostRequestsByUserID() http.HandlerFunc {
+// repostRequestStatuses maps the status query parameter to stored statuses
+var repostRequestStatuses = map[string]string{
+	"pending":  STATUS_PENDING,
+	"accepted": STATUS_ACCEPTED,
+	"declined": STATUS_DECLINED,
+}
+
+// repostContentLookup joins each repost request with the content it's about.
+// contentid is stored as a hex string, so it's converted to match _id; ids
+// that don't convert join nothing.
+func repostContentLookup() []bson.M {
+	return []bson.M{
+		{"$lookup": bson.M{
+			"from": getContentCollection().Name(),
+			"let": bson.M{"contentID": bson.M{"$convert": bson.M{
+				"input": "$contentid", "to": "objectId", "onError": nil, "onNull": nil,
+			}}},
+			"pipeline": []bson.M{{"$match": bson.M{"$expr": bson.M{"$eq": []string{"$_id", "$$contentID"}}}}},
+			"as":       "content",
+		}},
+		{"$unwind": bson.M{"path": "$content", "preserveNullAndEmptyArrays": true}},
+	}
+}
+
+// repostRequestsWithContent matches the repost requests in match whose
+// content still exists, so pages and counts leave out the same requests
+func repostRequestsWithContent(match bson.M) []bson.M {
+	pipeline := append([]bson.M{{"$match": match}}, repostContentLookup()...)
+	return append(pipeline, bson.M{"$match": bson.M{"content._id": bson.M{"$exists": true}}})
+}
+
+// findRepostRequests runs paging over the repost requests in match whose
+// content still exists
+func findRepostRequests(ctx context.Context, match bson.M, paging []bson.M) ([]models.RepostRequest, error) {
+	cur, err := getRepostRequestCollection().Aggregate(ctx, append(repostRequestsWithContent(match), paging...))
+	if err != nil {
+		return nil, err
+	}
+	requests := []models.RepostRequest{}
+	if err := cur.All(ctx, &requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// countRepostRequests counts the repost requests in match whose content
+// still exists
+func countRepostRequests(ctx context.Context, match bson.M) (int64, error) {
+	cur, err := getRepostRequestCollection().Aggregate(ctx, append(repostRequestsWithContent(match), bson.M{"$count": "total"}))
+	if err != nil {
+		return 0, err
+	}
+	var counts []struct {
+		Total int64 `bson:"total"`
+	}
+	if err := cur.All(ctx, &counts); err != nil || len(counts) == 0 {
+		return 0, err
+	}
+	return counts[0].Total, nil
+}
+
+// GetReceivedRepostRequests lists the repost requests sent to a user
+func GetReceivedRepostRequests() http.HandlerFunc {
+	return repostRequestPage("requestTo")
+}
+
+// GetSentRepostRequests lists the repost requests a user sent
+func GetSentRepostRequests() http.HandlerFunc {
+	return repostRequestPage("repostRequest")
+}
+
+// repostRequestPage lists the repost requests whose userField is the user,
+// newest first, one cursor page at a time, with the total matching. The
+// status query parameter takes a comma-separated list of pending, accepted
+// and declined and defaults to pending.
+func repostRequestPage(userField string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
-		vars := mux.Vars(r)
-		responder := vars["userID"]
-
-		limit, err := strconv.ParseInt(vars["limit"], 10, 64)
-		if err != nil {
-			fmt.Println("limit not an int")
-			errorResponse(w, err, 400)
+		userID := mux.Vars(r)["userID"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(w, err)
 			return
 		}
-		skip, err := strconv.ParseInt(vars["skip"], 10, 64)
+
+		page, err := parsePageRequest(r)
 		if err != nil {
-			fmt.Println("skip not an int")
-			errorResponse(w, err, 400)
+			errorResponse(w, err)
 			return
 		}
+		statuses := []string{}
+		for _, status := range strings.Split(r.URL.Query().Get("status"), ",") {
+			if status = strings.TrimSpace(status); status == "" {
+				continue
+			}
+			stored, ok := repostRequestStatuses[status]
+			if !ok {
+				errorResponse(w, apierrors.InvalidFields([]models.FieldError{
+					{Field: "status", Message: "must be pending, accepted or declined"},
+				}))
+				return
+			}
+			statuses = append(statuses, stored)
+		}
+		if len(statuses) == 0 {
+			statuses = []string{STATUS_PENDING}
+		}
+		filter := bson.M{userField: userID, "status": bson.M{"$in": statuses}}
 
-		paginate := options.Find()
-		paginate.SetSkip(skip)
-		paginate.SetLimit(limit)
-		filter := bson.M{"requestTo": responder, "status": STATUS_PENDING}
-
-		cur, err := getRepostRequestCollection().Find(ctx, filter, paginate)
+		total, err := countRepostRequests(ctx, filter)
 		if err != nil {
-			errorResponse(w, fmt.Errorf("decoding db results"), 200)
+			errorResponse(w, apierrors.Internal("failed to count repost requests", err))
 			return
 		}
-		requests := []models.RepostRequest{}
-		if err := cur.All(ctx, &requests); err != nil {
-			errorResponse(w, fmt.Errorf("error parsing results from db"), 200)
+		requests, err := findRepostRequests(ctx, filter, page.stages("created_at", bson.M{}))
+		if err != nil {
+			errorResponse(w, apierrors.Internal("failed to list repost requests", err))
 			return
 		}
-		var result []interface{}
-		for _, v := range requests {
-			contentOID, err := primitive.ObjectIDFromHex(v.ContentID)
-			if err != nil {
-				continue
-			}
-			singleRes := getContentCollection().FindOne(ctx, bson.M{"_id": contentOID})
-			var content models.Content
-			if err = singleRes.Decode(&content); err != nil {
-				continue
-			}
-			v.Content = content
-			result = append(result, v)
-		}
-		successResponse(w, result)
+		requests, next := pageOf(page, requests, func(request models.RepostRequest) (time.Time, primitive.ObjectID) {
+			return request.CreatedAt, request.ID
+		})
+
+		data := pageData(requests, next)
+		data["total"] = total
+		writeResponse(w, http.StatusOK, "success", data)
 	}
 }
 
+// GetRepostRequestsByUserID lists pending requests sent to a user by
+// limit/skip. Superseded by GetReceivedRepostRequests.
+func GetRepostRequestsByUserID() http.HandlerFunc {
+	return legacyRepostRequestList("requestTo")
+}
+
+// GetMyRepostRequests lists pending requests a user sent by limit/skip.
+// Superseded by GetSentRepostRequests.
 func GetMyRepostRequests() http.HandlerFunc {
+	return legacyRepostRequestList("repostRequest")
+}
+
+func legacyRepostRequestList(userField string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		vars := mux.Vars(r)
-		requester := vars["userID"]
+		userID := vars["userID"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(w, err)
+			return
+		}
 
 		limit, err := strconv.ParseInt(vars["limit"], 10, 64)
 		if err != nil {
 			fmt.Println("limit not an int")
-			errorResponse(w, err, 400)
+			errorResponse(w, apierrors.Wrap(apierrors.VALIDATION, err))
 			return
 		}
 		skip, err := strconv.ParseInt(vars["skip"], 10, 64)
 		if err != nil {
 			fmt.Println("skip not an int")
-			errorResponse(w, err, 400)
+			errorResponse(w, apierrors.Wrap(apierrors.VALIDATION, err))
 			return
 		}
 
-		paginate := options.Find()
-		paginate.SetSkip(skip)
-		paginate.SetLimit(limit)
-		filter := bson.M{"repostRequest": requester, "status": STATUS_PENDING}
-
-		cur, err := getRepostRequestCollection().Find(ctx, filter, paginate)
-		if err != nil {
-			errorResponse(w, fmt.Errorf("decoding db results"), 200)
-			return
+		paging := []bson.M{
+			{"$sort": bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+			{"$skip": skip},
 		}
-
-		requests := []models.RepostRequest{}
-		if err := cur.All(ctx, &requests); err != nil {
-			errorResponse(w, fmt.Errorf("error parsing results from db"), 200)
-			return
+		if limit > 0 {
+			paging = append(paging, bson.M{"$limit": limit})
 		}
-		var result []interface{}
-		for _, v := range requests {
-			contentOID, err := primitive.ObjectIDFromHex(v.ContentID)
-			if err != nil {
-				continue
-			}
-			singleRes := getContentCollection().FindOne(ctx, bson.M{"_id": contentOID})
-			var content models.Content
-			if err = singleRes.Decode(&content); err != nil {
-				continue
-			}
-			v.Content = content
-			result = append(result, v)
+		requests, err := findRepostRequests(ctx, bson.M{userField: userID, "status": STATUS_PENDING}, paging)
+		if err != nil {
+			errorResponse(w, apierrors.Internal("decoding db results", err))
+			return
 		}
-		successResponse(w, result)
+		successResponse(w, requests)
 	}
 }
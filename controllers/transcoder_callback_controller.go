@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"upload-service/apierrors"
+	"upload-service/configs"
+	"upload-service/models"
+	"upload-service/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// External transcoders report finished videos to the callback endpoint. The
+// request is signed with TRANSCODER_CALLBACK_SECRET: the signature header
+// carries "sha256=" and the hex HMAC-SHA256 of the timestamp header, a dot
+// and the raw body. Stale timestamps and signatures seen before are rejected,
+// so a captured callback can't be replayed.
+const (
+	TRANSCODER_TIMESTAMP_HEADER = "X-Transcoder-Timestamp"
+	TRANSCODER_SIGNATURE_HEADER = "X-Transcoder-Signature"
+
+	MAX_TRANSCODER_CALLBACK = 1 * MB
+)
+
+// TranscoderCallback is the manifest of a transcoded video. Keys are object
+// keys in the processed bucket: the playlists under the video's ID, the
+// thumbnail there or under thumbnails/.
+type TranscoderCallback struct {
+	VideoID      string             `json:"video_id"`
+	PlaylistKey  string             `json:"playlist_key"`
+	ThumbnailKey string             `json:"thumbnail_key,omitempty"`
+	Duration     float64            `json:"duration"`
+	Renditions   []models.Rendition `json:"renditions"`
+}
+
+// validate checks the manifest only points at the video's own objects
+func (c TranscoderCallback) validate() error {
+	fields := []models.FieldError{}
+	videoPrefix := c.VideoID + "/"
+	if c.VideoID == "" || strings.ContainsAny(c.VideoID, "/.") {
+		fields = append(fields, models.FieldError{Field: "video_id", Message: "must be a video ID"})
+	}
+	if !isObjectKeyUnder(c.PlaylistKey, videoPrefix) || !strings.HasSuffix(c.PlaylistKey, ".m3u8") {
+		fields = append(fields, models.FieldError{Field: "playlist_key", Message: "must be an .m3u8 key under the video ID"})
+	}
+	if c.ThumbnailKey != "" && !isObjectKeyUnder(c.ThumbnailKey, videoPrefix) && !isObjectKeyUnder(c.ThumbnailKey, "thumbnails/") {
+		fields = append(fields, models.FieldError{Field: "thumbnail_key", Message: "must be a key under the video ID or thumbnails/"})
+	}
+	if c.Duration < 0 {
+		fields = append(fields, models.FieldError{Field: "duration", Message: "must not be negative"})
+	}
+	if len(c.Renditions) == 0 {
+		fields = append(fields, models.FieldError{Field: "renditions", Message: "at least one rendition is required"})
+	}
+	for i, rendition := range c.Renditions {
+		field := fmt.Sprintf("renditions[%d]", i)
+		if rendition.Name == "" {
+			fields = append(fields, models.FieldError{Field: field + ".name", Message: "is required"})
+		}
+		if rendition.Bandwidth <= 0 {
+			fields = append(fields, models.FieldError{Field: field + ".bandwidth", Message: "must be positive"})
+		}
+		if !isObjectKeyUnder(rendition.PlaylistKey, videoPrefix) {
+			fields = append(fields, models.FieldError{Field: field + ".playlist_key", Message: "must be a key under the video ID"})
+		}
+	}
+	if len(fields) > 0 {
+		return apierrors.InvalidFields(fields)
+	}
+	return nil
+}
+
+func isObjectKeyUnder(key, prefix string) bool {
+	return strings.HasPrefix(key, prefix) && len(key) > len(prefix) && !strings.Contains(key, "..")
+}
+
+// verifyTranscoderSignature authenticates a callback body and records its
+// signature so the same callback isn't accepted twice
+func verifyTranscoderSignature(ctx context.Context, r *http.Request, body []byte) error {
+	secret := configs.EnvTranscoderCallbackSecret()
+	if secret == "" {
+		return apierrors.New(apierrors.NOT_IMPLEMENTED, "transcoder callbacks are not configured")
+	}
+
+	rawTimestamp := r.Header.Get(TRANSCODER_TIMESTAMP_HEADER)
+	timestamp, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return apierrors.Unauthorized("missing or invalid " + TRANSCODER_TIMESTAMP_HEADER)
+	}
+	skew := time.Since(time.Unix(timestamp, 0))
+	tolerance := configs.EnvTranscoderCallbackTolerance()
+	if skew > tolerance || skew < -tolerance {
+		return apierrors.Unauthorized("callback timestamp is outside the accepted window")
+	}
+
+	signature, ok := strings.CutPrefix(r.Header.Get(TRANSCODER_SIGNATURE_HEADER), "sha256=")
+	given, err := hex.DecodeString(signature)
+	if !ok || err != nil {
+		return apierrors.Unauthorized("missing or invalid " + TRANSCODER_SIGNATURE_HEADER)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(rawTimestamp + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	if !hmac.Equal(given, expected) {
+		return apierrors.Unauthorized("callback signature does not match")
+	}
+
+	// A signature stays valid for tolerance either side of its timestamp, so
+	// remembering it that long covers every replay that could pass the check
+	// above. It's keyed on the computed MAC: the header's hex could be resent
+	// in another case. Without Redis replays can't be ruled out, so refuse.
+	replayKey := "transcoder-callback:" + hex.EncodeToString(expected)
+	fresh, err := configs.GetRedisClient().SetNX(ctx, replayKey, rawTimestamp, 2*tolerance).Result()
+	if err != nil {
+		return apierrors.Internal("failed to check callback replay", err)
+	}
+	if !fresh {
+		return apierrors.Conflict("callback was already received")
+	}
+	return nil
+}
+
+// TranscoderCallbackHandler completes a video an external transcoder
+// finished. Only content still pending transcoding is updated; a callback for
+// a video that's already done or failed is answered with a 409.
+func TranscoderCallbackHandler() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		logger := configs.LogWithRequest(r.Context(), "transcoding", "callback")
+
+		body, err := io.ReadAll(http.MaxBytesReader(rw, r.Body, MAX_TRANSCODER_CALLBACK))
+		if err != nil {
+			errorResponse(rw, apierrors.Validation("could not read request body"))
+			return
+		}
+		if err := verifyTranscoderSignature(ctx, r, body); err != nil {
+			logger.Warn("Rejected transcoder callback", "error", err)
+			errorResponse(rw, err)
+			return
+		}
+
+		var callback TranscoderCallback
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&callback); err != nil {
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, fmt.Errorf("invalid manifest: %w", err)))
+			return
+		}
+		if err := callback.validate(); err != nil {
+			errorResponse(rw, err)
+			return
+		}
+
+		store := storage.Backend()
+		hlsURL := store.PublicURL(configs.EnvProcessedBucket(), callback.PlaylistKey)
+		set := bson.M{
+			"transcoding":  TRANSCODING_DONE,
+			"hls_url":      hlsURL,
+			"posting":      hlsURL,
+			"renditions":   callback.Renditions,
+			"duration":     callback.Duration,
+			"date_updated": time.Now(),
+		}
+		if callback.ThumbnailKey != "" {
+			set["thumbnail_key"] = store.PublicURL(configs.EnvProcessedBucket(), callback.ThumbnailKey)
+		}
+
+		var content models.Content
+		err = getContentCollection().FindOneAndUpdate(ctx,
+			bson.M{"video_id": callback.VideoID, "transcoding": TRANSCODING_PENDING},
+			bson.M{"$set": set},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&content)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			count, countErr := getContentCollection().CountDocuments(ctx, bson.M{"video_id": callback.VideoID})
+			if countErr == nil && count == 0 {
+				errorResponse(rw, apierrors.NotFound("no video with this video_id"))
+				return
+			}
+			errorResponse(rw, apierrors.Conflict("video is not pending transcoding"))
+			return
+		}
+		if err != nil {
+			errorResponse(rw, apierrors.Internal("failed to update content", err))
+			return
+		}
+
+		// the video no longer needs the in-process queue
+		now := time.Now()
+		_, err = getTranscodingJobsCollection().UpdateOne(ctx,
+			bson.M{"content_id": content.Id, "state": JOB_QUEUED},
+			bson.M{"$set": bson.M{"state": JOB_SUCCEEDED, "hls_url": hlsURL, "finished_at": now, "updated_at": now}})
+		if err != nil {
+			logger.Warn("Failed to close queued transcoding job", "error", err, "content_id", content.Id.Hex())
+		}
+
+		publishTranscodingEvent(content.Id, models.TranscodingEvent{State: TRANSCODING_DONE, HLSURL: hlsURL})
+		logger.Info("Transcoder callback completed video", "video_id", callback.VideoID, "content_id", content.Id.Hex())
+		writeResponse(rw, http.StatusOK, "success", map[string]interface{}{"data": content})
+	}
+}
@@ -0,0 +1,624 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"upload-service/apierrors"
+	"upload-service/configs"
+	"upload-service/models"
+	"upload-service/storage"
+	"upload-service/transcode"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Videos are transcoded through a job queue kept in Mongo. Uploads enqueue a
+// job once the raw object is complete; worker goroutines claim due jobs, run
+// the HLS ladder through a transcode.Runner, upload the renditions to the
+// processed bucket and mark the content done. Failed attempts are retried
+// with exponential backoff until TRANSCODE_MAX_ATTEMPTS is reached.
+
+const (
+	JOB_QUEUED    = "queued"
+	JOB_RUNNING   = "running"
+	JOB_SUCCEEDED = "succeeded"
+	JOB_FAILED    = "failed"
+	JOB_CANCELLED = "cancelled"
+
+	// idle workers look for due jobs this often, or sooner when one is queued
+	TRANSCODE_POLL_INTERVAL = 5 * time.Second
+	// a running job whose lease isn't renewed for this long is taken over
+	TRANSCODE_LEASE      = 2 * time.Minute
+	TRANSCODE_RETRY_BASE = 30 * time.Second
+	TRANSCODE_RETRY_MAX  = 30 * time.Minute
+)
+
+var (
+	errJobCancelled = errors.New("transcoding job cancelled")
+	errLeaseLost    = errors.New("transcoding job lease lost")
+
+	// how often a running job's lease is renewed; tests shorten it
+	transcodeLeaseRenewal = TRANSCODE_LEASE / 3
+)
+
+func getTranscodingJobsCollection() *mongo.Collection {
+	return configs.GetCollection(configs.DB, "transcoding_jobs")
+}
+
+// EnsureTranscodingJobIndexes keeps one job per content and makes claiming
+// due jobs cheap
+func EnsureTranscodingJobIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := getTranscodingJobsCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "content_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "state", Value: 1}, {Key: "run_at", Value: 1}},
+		},
+	})
+	return err
+}
+
+// enqueueTranscoding queues a video's raw object for transcoding. Queueing is
+// idempotent per content. A failure is only logged: the content stays
+// pending, and the external processor can still pick it up.
+func enqueueTranscoding(ctx context.Context, contentID primitive.ObjectID, videoID, userID, rawKey string) {
+	now := time.Now()
+	job := models.TranscodingJob{
+		ContentID:   contentID,
+		VideoID:     videoID,
+		UserID:      userID,
+		RawKey:      rawKey,
+		State:       JOB_QUEUED,
+		MaxAttempts: configs.EnvTranscodeMaxAttempts(),
+		RunAt:       now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	result, err := getTranscodingJobsCollection().UpdateOne(ctx,
+		bson.M{"content_id": contentID},
+		bson.M{"$setOnInsert": job},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		logger := configs.LogWithRequest(ctx, "transcoding", "enqueue")
+		logger.Error("Failed to queue transcoding job", "error", err, "content_id", contentID.Hex())
+		return
+	}
+	if result.UpsertedCount > 0 {
+		publishTranscodingEvent(contentID, models.TranscodingEvent{State: JOB_QUEUED})
+	}
+	if transcoder != nil {
+		transcoder.notify()
+	}
+}
+
+// transcodingQueue runs queued jobs on this instance
+type transcodingQueue struct {
+	runner transcode.Runner
+	worker string // marks the jobs this instance holds
+	wake   chan struct{}
+	stop   context.CancelFunc
+	done   sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[primitive.ObjectID]context.CancelCauseFunc
+}
+
+var transcoder *transcodingQueue
+
+// StartTranscodingWorkers starts workers goroutines transcoding queued jobs
+// with runner, so at most workers videos are transcoded at once
+func StartTranscodingWorkers(runner transcode.Runner, workers int) {
+	ctx, stop := context.WithCancel(context.Background())
+	hostname, _ := os.Hostname()
+	q := &transcodingQueue{
+		runner:  runner,
+		worker:  hostname + "-" + uuid.New().String()[:8],
+		wake:    make(chan struct{}, 1),
+		stop:    stop,
+		running: map[primitive.ObjectID]context.CancelCauseFunc{},
+	}
+	for i := 0; i < workers; i++ {
+		q.done.Add(1)
+		go q.work(ctx)
+	}
+	transcoder = q
+}
+
+// StopTranscodingWorkers stops taking jobs and hands the running ones back to
+// the queue, without counting the interrupted attempt
+func StopTranscodingWorkers() {
+	if transcoder == nil {
+		return
+	}
+	transcoder.stop()
+	transcoder.done.Wait()
+}
+
+func (q *transcodingQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// cancel stops a job if it's running on this instance
+func (q *transcodingQueue) cancel(jobID primitive.ObjectID) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if cancel, ok := q.running[jobID]; ok {
+		cancel(errJobCancelled)
+	}
+}
+
+func (q *transcodingQueue) work(ctx context.Context) {
+	defer q.done.Done()
+	logger := configs.LogWithContext("transcoding", "worker")
+	for ctx.Err() == nil {
+		job, err := q.claim(ctx)
+		if err == nil {
+			q.run(ctx, job)
+			continue
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) && ctx.Err() == nil {
+			logger.Error("Failed to claim transcoding job", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-time.After(TRANSCODE_POLL_INTERVAL):
+		}
+	}
+}
+
+// claim takes the next due job: a queued one whose retry time has come, or a
+// running one whose worker stopped renewing its lease
+func (q *transcodingQueue) claim(ctx context.Context) (models.TranscodingJob, error) {
+	claimCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	now := time.Now()
+	filter := bson.M{"$or": []bson.M{
+		{"state": JOB_QUEUED, "run_at": bson.M{"$lte": now}},
+		{"state": JOB_RUNNING, "lease_until": bson.M{"$lt": now}},
+	}}
+	update := bson.M{
+		"$set": bson.M{
+			"state":       JOB_RUNNING,
+			"worker":      q.worker,
+			"lease_until": now.Add(TRANSCODE_LEASE),
+			"started_at":  now,
+			"updated_at":  now,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.M{"run_at": 1}).
+		SetReturnDocument(options.After)
+
+	var job models.TranscodingJob
+	err := getTranscodingJobsCollection().FindOneAndUpdate(claimCtx, filter, update, opts).Decode(&job)
+	return job, err
+}
+
+func (q *transcodingQueue) run(ctx context.Context, job models.TranscodingJob) {
+	logger := configs.LogWithContext("transcoding", "run").WithFields(logrus.Fields{
+		"job_id":     job.ID.Hex(),
+		"content_id": job.ContentID.Hex(),
+		"attempt":    job.Attempts,
+	})
+	if job.CancelRequested {
+		q.finish(job, "", errJobCancelled)
+		return
+	}
+	if job.Attempts > job.MaxAttempts {
+		// the last attempt's worker died mid-run
+		q.finish(job, "", errors.New("worker stopped during the last attempt"))
+		return
+	}
+
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	jobCtx, cancelTimeout := context.WithTimeout(jobCtx, configs.EnvTranscodeTimeout())
+	q.mu.Lock()
+	q.running[job.ID] = cancel
+	q.mu.Unlock()
+	go q.holdLease(jobCtx, job.ID, cancel)
+
+	logger.Info("Transcoding video", "video_id", job.VideoID)
+	publishTranscodingProgress(job.ContentID, 0)
+	reported := 0.0
+	progress := func(percent float64) {
+		// whole percents are plenty for a progress bar
+		if percent-reported >= 1 {
+			reported = percent
+			publishTranscodingProgress(job.ContentID, percent)
+		}
+	}
+	hlsURL, err := q.transcode(jobCtx, job, progress)
+	cause := context.Cause(jobCtx)
+
+	cancelTimeout()
+	cancel(nil)
+	q.mu.Lock()
+	delete(q.running, job.ID)
+	q.mu.Unlock()
+
+	switch {
+	case err != nil && cause == errLeaseLost:
+		logger.Warn("Lost transcoding job to another worker")
+		return
+	case err != nil && cause == errJobCancelled:
+		err = errJobCancelled
+	case err != nil && ctx.Err() != nil:
+		q.release(job)
+		return
+	}
+	if err != nil {
+		logger.Warn("Transcoding attempt failed", "error", err)
+	} else {
+		logger.Info("Transcoding finished", "hls_url", hlsURL)
+	}
+	q.finish(job, hlsURL, err)
+}
+
+// holdLease renews the job's lease while it runs and stops the job when
+// someone asked to cancel it or another worker took it over
+func (q *transcodingQueue) holdLease(ctx context.Context, jobID primitive.ObjectID, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(transcodeLeaseRenewal)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		renewCtx, renewCancel := context.WithTimeout(ctx, 10*time.Second)
+		var job models.TranscodingJob
+		err := getTranscodingJobsCollection().FindOneAndUpdate(renewCtx,
+			bson.M{"_id": jobID, "worker": q.worker, "state": JOB_RUNNING},
+			bson.M{"$set": bson.M{"lease_until": time.Now().Add(TRANSCODE_LEASE), "updated_at": time.Now()}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&job)
+		renewCancel()
+
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			cancel(errLeaseLost)
+			return
+		case err != nil:
+			// keep running; the lease has time left for the next renewal
+			configs.LogWithContext("transcoding", "lease").Warn("Failed to renew transcoding lease", "error", err, "job_id", jobID.Hex())
+		case job.CancelRequested:
+			cancel(errJobCancelled)
+			return
+		}
+	}
+}
+
+// transcode downloads the raw video, runs the ladder and publishes the
+// renditions, returning the master playlist's URL
+func (q *transcodingQueue) transcode(ctx context.Context, job models.TranscodingJob, progress transcode.Progress) (string, error) {
+	store := storage.Backend()
+	dir, err := os.MkdirTemp("", "transcode-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source"+path.Ext(job.RawKey))
+	if err := downloadObject(ctx, store, configs.EnvRawBucket(), job.RawKey, source); err != nil {
+		return "", fmt.Errorf("downloading raw video: %w", err)
+	}
+
+	output := filepath.Join(dir, "hls")
+	if err := q.runner.Run(ctx, source, output, progress); err != nil {
+		return "", err
+	}
+	ladder, err := checkLadderDir(output)
+	if err != nil {
+		return "", err
+	}
+
+	err = filepath.WalkDir(output, func(file string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(output, file)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		key := job.VideoID + "/" + filepath.ToSlash(rel)
+		return store.Put(ctx, configs.EnvProcessedBucket(), key, f, getContentType(entry.Name()))
+	})
+	if err != nil {
+		return "", fmt.Errorf("uploading renditions: %w", err)
+	}
+
+	hlsURL := store.PublicURL(configs.EnvProcessedBucket(), job.VideoID+"/"+ladder.Master)
+	_, err = getContentCollection().UpdateOne(ctx,
+		bson.M{"_id": job.ContentID, "transcoding": TRANSCODING_PENDING},
+		bson.M{
+			"$set": bson.M{
+				"transcoding":  TRANSCODING_DONE,
+				"hls_url":      hlsURL,
+				"posting":      hlsURL,
+				"renditions":   renditionsOf(ladder, job.VideoID+"/"),
+				"duration":     ladder.Duration,
+				"date_updated": time.Now(),
+			},
+			"$unset": bson.M{"transcoding_report": ""},
+		})
+	if err != nil {
+		return "", fmt.Errorf("updating content: %w", err)
+	}
+	return hlsURL, nil
+}
+
+// checkLadderDir checks the ladder a Runner wrote to dir
+func checkLadderDir(dir string) (transcode.Ladder, error) {
+	playlists := map[string][]byte{}
+	files := map[string]bool{}
+	err := filepath.WalkDir(dir, func(file string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if path.Ext(rel) != ".m3u8" {
+			files[rel] = true
+			return nil
+		}
+		playlists[rel], err = os.ReadFile(file)
+		return err
+	})
+	if err != nil {
+		return transcode.Ladder{}, err
+	}
+	return transcode.CheckLadder(playlists, files)
+}
+
+// renditionsOf lists a checked ladder's renditions, with their playlists
+// stored under prefix
+func renditionsOf(ladder transcode.Ladder, prefix string) []models.Rendition {
+	renditions := []models.Rendition{}
+	for _, variant := range ladder.Variants {
+		renditions = append(renditions, models.Rendition{
+			Name:        strings.TrimSuffix(path.Base(variant.URI), path.Ext(variant.URI)),
+			Bandwidth:   variant.Bandwidth,
+			Resolution:  variant.Resolution,
+			PlaylistKey: prefix + variant.URI,
+		})
+	}
+	return renditions
+}
+
+// transcodingReport details a failure for the content record: one entry per
+// problem of a broken ladder, nothing for other failures
+func transcodingReport(err error) []models.TranscodingProblem {
+	var ladderErr *transcode.LadderError
+	if !errors.As(err, &ladderErr) {
+		return nil
+	}
+	report := []models.TranscodingProblem{}
+	for _, problem := range ladderErr.Problems {
+		report = append(report, models.TranscodingProblem{File: problem.File, Problem: problem.Problem})
+	}
+	return report
+}
+
+// downloadObject copies a stored object to a local file
+func downloadObject(ctx context.Context, store storage.Storage, bucket, key, file string) error {
+	body, _, err := store.Get(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// finish records the outcome of an attempt: success, cancellation, a retry
+// after backoff, or failure once the attempts are used up
+func (q *transcodingQueue) finish(job models.TranscodingJob, hlsURL string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	now := time.Now()
+	set := bson.M{"updated_at": now}
+	var event models.TranscodingEvent
+	switch {
+	case err == nil:
+		set["state"] = JOB_SUCCEEDED
+		set["hls_url"] = hlsURL
+		set["finished_at"] = now
+		event = models.TranscodingEvent{State: TRANSCODING_DONE, HLSURL: hlsURL}
+	case errors.Is(err, errJobCancelled):
+		set["state"] = JOB_CANCELLED
+		set["finished_at"] = now
+		event = models.TranscodingEvent{State: JOB_CANCELLED}
+	case job.Attempts >= job.MaxAttempts:
+		set["state"] = JOB_FAILED
+		set["last_error"] = err.Error()
+		set["finished_at"] = now
+		event = models.TranscodingEvent{State: TRANSCODING_FAILED, Reason: err.Error()}
+	default:
+		set["state"] = JOB_QUEUED
+		set["last_error"] = err.Error()
+		set["run_at"] = now.Add(transcodeBackoff(job.Attempts))
+		event = models.TranscodingEvent{State: JOB_QUEUED, Reason: err.Error()}
+	}
+
+	_, updateErr := getTranscodingJobsCollection().UpdateOne(ctx,
+		bson.M{"_id": job.ID, "worker": q.worker, "state": JOB_RUNNING},
+		bson.M{"$set": set, "$unset": bson.M{"worker": "", "lease_until": ""}})
+	if updateErr != nil {
+		configs.LogWithContext("transcoding", "finish").Error("Failed to record transcoding outcome", "error", updateErr, "job_id", job.ID.Hex())
+	}
+	if set["state"] == JOB_FAILED || set["state"] == JOB_CANCELLED {
+		failContentTranscoding(ctx, job.ContentID, transcodingReport(err))
+	}
+	publishTranscodingEvent(job.ContentID, event)
+}
+
+// release hands a job interrupted by shutdown back to the queue
+func (q *transcodingQueue) release(job models.TranscodingJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := getTranscodingJobsCollection().UpdateOne(ctx,
+		bson.M{"_id": job.ID, "worker": q.worker, "state": JOB_RUNNING},
+		bson.M{
+			"$set":   bson.M{"state": JOB_QUEUED, "run_at": time.Now(), "updated_at": time.Now()},
+			"$inc":   bson.M{"attempts": -1},
+			"$unset": bson.M{"worker": "", "lease_until": ""},
+		})
+	if err != nil {
+		configs.LogWithContext("transcoding", "release").Error("Failed to requeue transcoding job", "error", err, "job_id", job.ID.Hex())
+		return
+	}
+	publishTranscodingEvent(job.ContentID, models.TranscodingEvent{State: JOB_QUEUED})
+}
+
+// transcodeBackoff is the wait before retrying after the given attempt:
+// TRANSCODE_RETRY_BASE doubling per attempt, capped at TRANSCODE_RETRY_MAX
+func transcodeBackoff(attempt int) time.Duration {
+	backoff := TRANSCODE_RETRY_BASE
+	for i := 1; i < attempt && backoff < TRANSCODE_RETRY_MAX; i++ {
+		backoff *= 2
+	}
+	if backoff > TRANSCODE_RETRY_MAX {
+		backoff = TRANSCODE_RETRY_MAX
+	}
+	return backoff
+}
+
+// failContentTranscoding marks pending content failed, recording report when
+// there is one
+func failContentTranscoding(ctx context.Context, contentID primitive.ObjectID, report []models.TranscodingProblem) {
+	set := bson.M{"transcoding": TRANSCODING_FAILED, "date_updated": time.Now()}
+	if report != nil {
+		set["transcoding_report"] = report
+	}
+	_, err := getContentCollection().UpdateOne(ctx,
+		bson.M{"_id": contentID, "transcoding": TRANSCODING_PENDING},
+		bson.M{"$set": set})
+	if err != nil {
+		configs.LogWithContext("transcoding", "finish").Error("Failed to mark content transcoding failed", "error", err, "content_id", contentID.Hex())
+	}
+}
+
+// transcodingJobFor loads the job of content the caller owns
+func transcodingJobFor(ctx context.Context, r *http.Request) (models.TranscodingJob, error) {
+	contentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["ContentID"])
+	if err != nil {
+		return models.TranscodingJob{}, apierrors.Validation("invalid content ID")
+	}
+	if _, err := authorizeContentOwner(ctx, r, ACTION_MANAGE_TRANSCODING, contentID); err != nil {
+		return models.TranscodingJob{}, err
+	}
+	var job models.TranscodingJob
+	err = getTranscodingJobsCollection().FindOne(ctx, bson.M{"content_id": contentID}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return job, apierrors.NotFound("no transcoding job for this content")
+	}
+	if err != nil {
+		return job, apierrors.Internal("failed to load transcoding job", err)
+	}
+	return job, nil
+}
+
+// GetTranscodingJob reports the state of a video's transcoding job
+func GetTranscodingJob() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		job, err := transcodingJobFor(ctx, r)
+		if err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		writeResponse(rw, http.StatusOK, "success", map[string]interface{}{"data": job})
+	}
+}
+
+// CancelTranscodingJob stops a video's transcoding. Queued jobs are cancelled
+// straight away; running ones stop at their worker's next lease renewal, or
+// at once when they run on this instance.
+func CancelTranscodingJob() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		job, err := transcodingJobFor(ctx, r)
+		if err != nil {
+			errorResponse(rw, err)
+			return
+		}
+
+		now := time.Now()
+		after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = getTranscodingJobsCollection().FindOneAndUpdate(ctx,
+			bson.M{"_id": job.ID, "state": JOB_QUEUED},
+			bson.M{"$set": bson.M{"state": JOB_CANCELLED, "finished_at": now, "updated_at": now}},
+			after).Decode(&job)
+		if err == nil {
+			failContentTranscoding(ctx, job.ContentID, nil)
+			publishTranscodingEvent(job.ContentID, models.TranscodingEvent{State: JOB_CANCELLED})
+			writeResponse(rw, http.StatusOK, "success", map[string]interface{}{"data": job})
+			return
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			errorResponse(rw, apierrors.Internal("failed to cancel transcoding job", err))
+			return
+		}
+
+		err = getTranscodingJobsCollection().FindOneAndUpdate(ctx,
+			bson.M{"_id": job.ID, "state": JOB_RUNNING},
+			bson.M{"$set": bson.M{"cancel_requested": true, "updated_at": now}},
+			after).Decode(&job)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			errorResponse(rw, apierrors.Conflict("transcoding job has already finished"))
+			return
+		}
+		if err != nil {
+			errorResponse(rw, apierrors.Internal("failed to cancel transcoding job", err))
+			return
+		}
+		if transcoder != nil {
+			transcoder.cancel(job.ID)
+		}
+		writeResponse(rw, http.StatusAccepted, "cancelling", map[string]interface{}{"data": job})
+	}
+}
@@ -0,0 +1,318 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"upload-service/configs"
+	"upload-service/models"
+	"upload-service/storage"
+	"upload-service/transcode"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The queue tests run against the MongoDB in MONGODB_URI and are skipped
+// without one. Point it at a scratch database: the transcoding_jobs
+// collection is dropped between tests.
+
+func newTestQueue(t *testing.T, runner transcode.Runner) *transcodingQueue {
+	t.Helper()
+	if os.Getenv("MONGODB_URI") == "" {
+		t.Skip("MONGODB_URI is not set")
+	}
+	configs.Logger = logrus.New()
+	configs.Logger.SetOutput(io.Discard)
+	if err := configs.ConnectDB(); err != nil {
+		t.Fatalf("connecting to MongoDB: %v", err)
+	}
+	// events are best effort, so publishing may fail without Redis
+	configs.ConnectREDISDB()
+	storage.SetBackend(storage.NewMemoryStorage())
+
+	ctx := context.Background()
+	if err := getTranscodingJobsCollection().Drop(ctx); err != nil {
+		t.Fatalf("dropping jobs: %v", err)
+	}
+	t.Cleanup(func() { getTranscodingJobsCollection().Drop(context.Background()) })
+
+	return &transcodingQueue{
+		runner:  runner,
+		worker:  "test-" + primitive.NewObjectID().Hex(),
+		wake:    make(chan struct{}, 1),
+		stop:    func() {},
+		running: map[primitive.ObjectID]context.CancelCauseFunc{},
+	}
+}
+
+// insertTestVideo stores a raw video and its pending content
+func insertTestVideo(t *testing.T) models.Content {
+	t.Helper()
+	ctx := context.Background()
+	content := models.Content{
+		Id:          primitive.NewObjectID(),
+		VideoID:     primitive.NewObjectID().Hex(),
+		UserID:      "test-user",
+		Type:        TYPE_VIDEO,
+		Transcoding: TRANSCODING_PENDING,
+	}
+	content.S3RawKey = content.UserID + "/" + content.VideoID + ".mp4"
+	if err := storage.Backend().Put(ctx, configs.EnvRawBucket(), content.S3RawKey, strings.NewReader("raw video"), "video/mp4"); err != nil {
+		t.Fatalf("storing raw video: %v", err)
+	}
+	if _, err := getContentCollection().InsertOne(ctx, content); err != nil {
+		t.Fatalf("inserting content: %v", err)
+	}
+	t.Cleanup(func() { getContentCollection().DeleteOne(context.Background(), bson.M{"_id": content.Id}) })
+	return content
+}
+
+// insertTestJob queues job, filling in what every job has
+func insertTestJob(t *testing.T, job models.TranscodingJob) models.TranscodingJob {
+	t.Helper()
+	now := time.Now()
+	job.ID = primitive.NewObjectID()
+	if job.ContentID.IsZero() {
+		job.ContentID = primitive.NewObjectID()
+	}
+	if job.State == "" {
+		job.State = JOB_QUEUED
+	}
+	if job.MaxAttempts == 0 {
+		job.MaxAttempts = 3
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = now
+	}
+	job.CreatedAt, job.UpdatedAt = now, now
+	if _, err := getTranscodingJobsCollection().InsertOne(context.Background(), job); err != nil {
+		t.Fatalf("inserting job: %v", err)
+	}
+	return job
+}
+
+func loadTestJob(t *testing.T, id primitive.ObjectID) models.TranscodingJob {
+	t.Helper()
+	var job models.TranscodingJob
+	if err := getTranscodingJobsCollection().FindOne(context.Background(), bson.M{"_id": id}).Decode(&job); err != nil {
+		t.Fatalf("loading job: %v", err)
+	}
+	return job
+}
+
+func loadTestContent(t *testing.T, id primitive.ObjectID) models.Content {
+	t.Helper()
+	var content models.Content
+	if err := getContentCollection().FindOne(context.Background(), bson.M{"_id": id}).Decode(&content); err != nil {
+		t.Fatalf("loading content: %v", err)
+	}
+	return content
+}
+
+// waitForRun waits for a run started with go q.run to return
+func waitForRun(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("job kept running")
+	}
+}
+
+// waitForRunner waits until runner has been handed a source
+func waitForRunner(t *testing.T, runner *transcode.FakeRunner) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for len(runner.Sources()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("runner never started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTranscodeBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, TRANSCODE_RETRY_BASE},
+		{2, 2 * TRANSCODE_RETRY_BASE},
+		{3, 4 * TRANSCODE_RETRY_BASE},
+		{20, TRANSCODE_RETRY_MAX},
+	}
+	for _, tt := range tests {
+		if got := transcodeBackoff(tt.attempt); got != tt.want {
+			t.Errorf("transcodeBackoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestClaimTakesDueJobs(t *testing.T) {
+	q := newTestQueue(t, &transcode.FakeRunner{})
+	ctx := context.Background()
+
+	due := insertTestJob(t, models.TranscodingJob{RunAt: time.Now().Add(-time.Minute)})
+	insertTestJob(t, models.TranscodingJob{RunAt: time.Now().Add(time.Hour)})
+	insertTestJob(t, models.TranscodingJob{State: JOB_RUNNING, Worker: "other", LeaseUntil: time.Now().Add(time.Minute), Attempts: 1})
+
+	job, err := q.claim(ctx)
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if job.ID != due.ID {
+		t.Fatalf("claimed %s, want the due job %s", job.ID.Hex(), due.ID.Hex())
+	}
+	if job.State != JOB_RUNNING || job.Worker != q.worker || job.Attempts != 1 {
+		t.Errorf("claimed job is %s by %q after %d attempts, want running by %q after 1", job.State, job.Worker, job.Attempts, q.worker)
+	}
+	if !job.LeaseUntil.After(time.Now()) {
+		t.Errorf("claimed job's lease ended at %s", job.LeaseUntil)
+	}
+
+	if _, err := q.claim(ctx); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("second claim: got %v, want no job", err)
+	}
+}
+
+func TestClaimTakesOverExpiredLease(t *testing.T) {
+	q := newTestQueue(t, &transcode.FakeRunner{})
+
+	stale := insertTestJob(t, models.TranscodingJob{State: JOB_RUNNING, Worker: "gone", LeaseUntil: time.Now().Add(-time.Second), Attempts: 1})
+
+	job, err := q.claim(context.Background())
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if job.ID != stale.ID || job.Worker != q.worker || job.Attempts != 2 {
+		t.Errorf("claimed %s by %q after %d attempts, want %s by %q after 2", job.ID.Hex(), job.Worker, job.Attempts, stale.ID.Hex(), q.worker)
+	}
+}
+
+func TestFailedAttemptBacksOff(t *testing.T) {
+	q := newTestQueue(t, &transcode.FakeRunner{})
+	content := insertTestVideo(t)
+	job := insertTestJob(t, models.TranscodingJob{ContentID: content.Id, State: JOB_RUNNING, Worker: q.worker, Attempts: 1, MaxAttempts: 2})
+
+	before := time.Now()
+	q.finish(job, "", errors.New("ffmpeg crashed"))
+
+	retried := loadTestJob(t, job.ID)
+	if retried.State != JOB_QUEUED || retried.LastError != "ffmpeg crashed" || retried.Worker != "" {
+		t.Fatalf("after a failed attempt the job is %s by %q with error %q, want queued by nobody", retried.State, retried.Worker, retried.LastError)
+	}
+	if wait := retried.RunAt.Sub(before); wait < transcodeBackoff(1)-time.Second || wait > transcodeBackoff(1)+time.Second {
+		t.Errorf("retry runs after %s, want %s", wait, transcodeBackoff(1))
+	}
+	if got := loadTestContent(t, content.Id).Transcoding; got != TRANSCODING_PENDING {
+		t.Errorf("content is %s while the job retries, want pending", got)
+	}
+
+	// the last attempt gives up
+	job.Attempts = 2
+	getTranscodingJobsCollection().UpdateOne(context.Background(), bson.M{"_id": job.ID},
+		bson.M{"$set": bson.M{"state": JOB_RUNNING, "worker": q.worker, "attempts": 2}})
+	q.finish(job, "", errors.New("ffmpeg crashed"))
+
+	if got := loadTestJob(t, job.ID).State; got != JOB_FAILED {
+		t.Errorf("after the last attempt the job is %s, want failed", got)
+	}
+	if got := loadTestContent(t, content.Id).Transcoding; got != TRANSCODING_FAILED {
+		t.Errorf("after the last attempt the content is %s, want failed", got)
+	}
+}
+
+func TestRunPublishesRenditions(t *testing.T) {
+	runner := &transcode.FakeRunner{}
+	q := newTestQueue(t, runner)
+	content := insertTestVideo(t)
+	insertTestJob(t, models.TranscodingJob{ContentID: content.Id, VideoID: content.VideoID, RawKey: content.S3RawKey})
+
+	job, err := q.claim(context.Background())
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	q.run(context.Background(), job)
+
+	finished := loadTestJob(t, job.ID)
+	if finished.State != JOB_SUCCEEDED || finished.HLSURL == "" {
+		t.Fatalf("job is %s with HLS URL %q, want succeeded with one", finished.State, finished.HLSURL)
+	}
+	transcoded := loadTestContent(t, content.Id)
+	if transcoded.Transcoding != TRANSCODING_DONE || transcoded.HLSURL != finished.HLSURL {
+		t.Errorf("content is %s at %q, want done at %q", transcoded.Transcoding, transcoded.HLSURL, finished.HLSURL)
+	}
+	master := content.VideoID + "/" + transcode.MASTER_PLAYLIST
+	if _, err := storage.Backend().Head(context.Background(), configs.EnvProcessedBucket(), master); err != nil {
+		t.Errorf("master playlist %s not published: %v", master, err)
+	}
+}
+
+func TestCancelStopsRunningJob(t *testing.T) {
+	runner := &transcode.FakeRunner{Delay: time.Minute}
+	q := newTestQueue(t, runner)
+	content := insertTestVideo(t)
+	insertTestJob(t, models.TranscodingJob{ContentID: content.Id, VideoID: content.VideoID, RawKey: content.S3RawKey})
+
+	job, err := q.claim(context.Background())
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.run(context.Background(), job)
+	}()
+	waitForRunner(t, runner)
+	q.cancel(job.ID)
+	waitForRun(t, done)
+
+	if got := loadTestJob(t, job.ID).State; got != JOB_CANCELLED {
+		t.Errorf("cancelled job is %s", got)
+	}
+	if got := loadTestContent(t, content.Id).Transcoding; got != TRANSCODING_FAILED {
+		t.Errorf("content of a cancelled job is %s, want failed", got)
+	}
+}
+
+func TestLostLeaseStopsJob(t *testing.T) {
+	renewal := transcodeLeaseRenewal
+	transcodeLeaseRenewal = 50 * time.Millisecond
+	t.Cleanup(func() { transcodeLeaseRenewal = renewal })
+
+	runner := &transcode.FakeRunner{Delay: time.Minute}
+	q := newTestQueue(t, runner)
+	content := insertTestVideo(t)
+	insertTestJob(t, models.TranscodingJob{ContentID: content.Id, VideoID: content.VideoID, RawKey: content.S3RawKey})
+
+	job, err := q.claim(context.Background())
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.run(context.Background(), job)
+	}()
+	waitForRunner(t, runner)
+
+	// another worker took the job over after the lease lapsed
+	getTranscodingJobsCollection().UpdateOne(context.Background(), bson.M{"_id": job.ID},
+		bson.M{"$set": bson.M{"worker": "other", "attempts": job.Attempts + 1}})
+	waitForRun(t, done)
+
+	taken := loadTestJob(t, job.ID)
+	if taken.State != JOB_RUNNING || taken.Worker != "other" {
+		t.Errorf("job is %s by %q, want left running by the worker that took it", taken.State, taken.Worker)
+	}
+	if got := loadTestContent(t, content.Id).Transcoding; got != TRANSCODING_PENDING {
+		t.Errorf("content is %s, want pending for the new worker", got)
+	}
+}
@@ -0,0 +1,286 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"upload-service/apierrors"
+	"upload-service/configs"
+	"upload-service/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Whatever changes a video's transcoding publishes a models.TranscodingEvent
+// on the content's Redis channel, so every instance can stream it to the
+// clients following that video with Server-Sent Events. Each instance holds
+// one pattern subscription to all those channels and fans the events out to
+// its own streams.
+
+const (
+	TRANSCODING_CHANNEL_PREFIX = "transcoding:"
+	// idle streams get a comment this often so proxies keep them open
+	TRANSCODING_EVENTS_HEARTBEAT = 15 * time.Second
+	// how long EventSource clients wait before reconnecting, in ms
+	TRANSCODING_EVENTS_RETRY = 5000
+	// events a stream may fall behind by before it's closed
+	TRANSCODING_EVENTS_BUFFER = 64
+)
+
+func transcodingChannel(contentID primitive.ObjectID) string {
+	return TRANSCODING_CHANNEL_PREFIX + contentID.Hex()
+}
+
+// transcodingEventHub hands the events of this instance's subscription to the
+// streams following each video
+type transcodingEventHub struct {
+	mu         sync.Mutex
+	subscribed bool
+	followers  map[string]map[chan models.TranscodingEvent]bool
+}
+
+var transcodingEvents = &transcodingEventHub{followers: map[string]map[chan models.TranscodingEvent]bool{}}
+
+// follow starts collecting a video's events. The returned function stops it;
+// the channel is closed early if the stream falls too far behind.
+func (h *transcodingEventHub) follow(contentID string) (<-chan models.TranscodingEvent, func(), error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.subscribed {
+		return nil, nil, errors.New("not subscribed to transcoding events")
+	}
+	events := make(chan models.TranscodingEvent, TRANSCODING_EVENTS_BUFFER)
+	if h.followers[contentID] == nil {
+		h.followers[contentID] = map[chan models.TranscodingEvent]bool{}
+	}
+	h.followers[contentID][events] = true
+	unfollow := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.drop(contentID, events)
+	}
+	return events, unfollow, nil
+}
+
+func (h *transcodingEventHub) drop(contentID string, events chan models.TranscodingEvent) {
+	delete(h.followers[contentID], events)
+	if len(h.followers[contentID]) == 0 {
+		delete(h.followers, contentID)
+	}
+}
+
+func (h *transcodingEventHub) publish(contentID string, event models.TranscodingEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for events := range h.followers[contentID] {
+		select {
+		case events <- event:
+		default:
+			// the client reconnects and starts over from the current state
+			h.drop(contentID, events)
+			close(events)
+		}
+	}
+}
+
+func (h *transcodingEventHub) setSubscribed(subscribed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribed = subscribed
+}
+
+// RelayTranscodingEvents subscribes this instance to every video's
+// transcoding channel and passes the events on to the streams following
+// them. The Redis client resubscribes by itself after a dropped connection.
+func RelayTranscodingEvents() {
+	ctx := context.Background()
+	logger := configs.LogWithContext("transcoding", "relay")
+	pubsub := configs.GetRedisClient().PSubscribe(ctx, TRANSCODING_CHANNEL_PREFIX+"*")
+	defer pubsub.Close()
+	for {
+		_, err := pubsub.Receive(ctx)
+		if err == nil {
+			break
+		}
+		logger.Warn("Failed to subscribe to transcoding events, retrying", "error", err)
+		time.Sleep(TRANSCODING_EVENTS_HEARTBEAT)
+	}
+	transcodingEvents.setSubscribed(true)
+	defer transcodingEvents.setSubscribed(false)
+
+	for message := range pubsub.Channel() {
+		var event models.TranscodingEvent
+		if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+			logger.Warn("Dropped malformed transcoding event", "error", err, "channel", message.Channel)
+			continue
+		}
+		transcodingEvents.publish(strings.TrimPrefix(message.Channel, TRANSCODING_CHANNEL_PREFIX), event)
+	}
+}
+
+// publishTranscodingEvent tells the video's followers about a change. Events
+// are best effort: a failure is logged and the change itself stands.
+func publishTranscodingEvent(contentID primitive.ObjectID, event models.TranscodingEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	event.ContentID = contentID.Hex()
+	event.At = time.Now()
+	data, _ := json.Marshal(event)
+	if err := configs.GetRedisClient().Publish(ctx, transcodingChannel(contentID), data).Err(); err != nil {
+		logger := configs.LogWithContext("transcoding", "publish")
+		logger.Warn("Failed to publish transcoding event", "error", err, "content_id", contentID.Hex(), "state", event.State)
+	}
+}
+
+// publishTranscodingProgress reports a running video's progress
+func publishTranscodingProgress(contentID primitive.ObjectID, percent float64) {
+	publishTranscodingEvent(contentID, models.TranscodingEvent{State: JOB_RUNNING, Progress: &percent})
+}
+
+// notifyVideoTranscoding publishes event for the video matching filter, for
+// updates made by video ID rather than content ID
+func notifyVideoTranscoding(ctx context.Context, filter bson.M, event models.TranscodingEvent) {
+	var content models.Content
+	if err := getContentCollection().FindOne(ctx, filter).Decode(&content); err != nil {
+		return
+	}
+	publishTranscodingEvent(content.Id, event)
+}
+
+// terminalTranscodingEvent tells whether a stream ends after the event
+func terminalTranscodingEvent(event models.TranscodingEvent) bool {
+	switch event.State {
+	case TRANSCODING_DONE, TRANSCODING_FAILED, JOB_CANCELLED:
+		return true
+	}
+	return false
+}
+
+// currentTranscodingEvent describes where a video's transcoding stands, for
+// clients that start following it midway
+func currentTranscodingEvent(ctx context.Context, content models.Content) (models.TranscodingEvent, error) {
+	event := models.TranscodingEvent{ContentID: content.Id.Hex(), At: time.Now()}
+	if content.Transcoding == "" || content.Transcoding == TRANSCODING_DONE {
+		// videos from before transcoding was tracked are published
+		event.State = TRANSCODING_DONE
+		event.HLSURL = content.HLSURL
+		return event, nil
+	}
+
+	var job models.TranscodingJob
+	err := getTranscodingJobsCollection().FindOne(ctx, bson.M{"content_id": content.Id}).Decode(&job)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return event, apierrors.Internal("failed to load transcoding job", err)
+	}
+	event.Reason = job.LastError
+	if event.Reason == "" && len(content.TranscodingReport) > 0 {
+		first := content.TranscodingReport[0]
+		event.Reason = first.File + ": " + first.Problem
+	}
+
+	switch {
+	case content.Transcoding == TRANSCODING_FAILED && job.State == JOB_CANCELLED:
+		event.State = JOB_CANCELLED
+	case content.Transcoding == TRANSCODING_FAILED:
+		event.State = TRANSCODING_FAILED
+	case job.State == JOB_RUNNING:
+		event.State = JOB_RUNNING
+	default:
+		// queued here, or waiting for the external processor
+		event.State = JOB_QUEUED
+	}
+	return event, nil
+}
+
+// StreamTranscodingEvents follows a video's transcoding with Server-Sent
+// Events. The stream opens with the current state, then sends every change
+// (queued, running with progress, done, failed with a reason, cancelled) and
+// ends once the video is done, failed or cancelled.
+func StreamTranscodingEvents() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		flusher, ok := rw.(http.Flusher)
+		if !ok {
+			errorResponse(rw, apierrors.New(apierrors.NOT_IMPLEMENTED, "streaming is not supported"))
+			return
+		}
+		contentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["ContentID"])
+		if err != nil {
+			errorResponse(rw, apierrors.Validation("invalid content ID"))
+			return
+		}
+		lookupCtx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		content, err := authorizeContentOwner(lookupCtx, r, ACTION_WATCH_TRANSCODING, contentID)
+		if err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		if content.Type != TYPE_VIDEO {
+			errorResponse(rw, apierrors.Validation("content is not a video"))
+			return
+		}
+
+		// Follow before reading the current state so no change falls
+		// between the two
+		events, unfollow, err := transcodingEvents.follow(contentID.Hex())
+		if err != nil {
+			errorResponse(rw, apierrors.Internal("failed to subscribe to transcoding events", err))
+			return
+		}
+		defer unfollow()
+		current, err := currentTranscodingEvent(lookupCtx, content)
+		if err != nil {
+			errorResponse(rw, err)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.Header().Set("Cache-Control", "no-cache")
+		rw.Header().Set("Connection", "keep-alive")
+		// nginx buffers proxied responses unless told otherwise
+		rw.Header().Set("X-Accel-Buffering", "no")
+		rw.WriteHeader(http.StatusOK)
+		fmt.Fprintf(rw, "retry: %d\n\n", TRANSCODING_EVENTS_RETRY)
+		if writeTranscodingEvent(rw, flusher, current) != nil || terminalTranscodingEvent(current) {
+			return
+		}
+
+		heartbeat := time.NewTicker(TRANSCODING_EVENTS_HEARTBEAT)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(rw, ": keepalive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if writeTranscodingEvent(rw, flusher, event) != nil || terminalTranscodingEvent(event) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func writeTranscodingEvent(rw http.ResponseWriter, flusher http.Flusher, event models.TranscodingEvent) error {
+	data, _ := json.Marshal(event)
+	if _, err := fmt.Fprintf(rw, "event: %s\ndata: %s\n\n", event.State, data); err != nil {
+		return err
+	}
+	flusher.Flush()
+	return nil
+}
@@ -12,6 +12,7 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+	"upload-service/auth"
 	"upload-service/models"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -19,17 +20,21 @@ import (
 
 func TransferProfilePics() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if err := requireService(r, auth.SCOPE_MAINTENANCE); err != nil {
+			errorResponse(w, err)
+			return
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 		defer cancel()
 		cur, err := getProfilePicsCollection().Find(ctx, bson.M{"location": bson.M{"$exists": false}})
 		if err != nil {
-			errorResponse(w, err, 200)
+			errorResponse(w, err)
 			return
 		}
 		basePath := "/mnt/storage/hls/vod/"
 		profilepics := []models.ProfilePic{}
 		if err := cur.All(ctx, &profilepics); err != nil {
-			errorResponse(w, err, 200)
+			errorResponse(w, err)
 			return
 		}
 		for _, profilePic := range profilepics {
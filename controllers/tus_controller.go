@@ -0,0 +1,612 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"upload-service/apierrors"
+	"upload-service/configs"
+	"upload-service/models"
+	"upload-service/storage"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Resumable video uploads following the tus 1.0.0 protocol (core, creation,
+// termination and expiration extensions). Every PATCH is stored as its own
+// part object in the raw bucket, so an upload can resume on any instance.
+// Once the last byte arrives the parts are stitched into the final raw key;
+// if that fails the next HEAD or PATCH tries again.
+
+const (
+	TUS_VERSION    = "1.0.0"
+	TUS_EXTENSIONS = "creation,termination,expiration"
+	TUS_UPLOAD_URL = "/uploadmicro/v1/tus/uploads/"
+
+	UPLOAD_STATUS_UPLOADING = "uploading"
+	// every byte is in place and the Content is being created
+	UPLOAD_STATUS_COMPLETING = "completing"
+	UPLOAD_STATUS_COMPLETED  = "completed"
+	UPLOAD_STATUS_TERMINATED = "terminated"
+
+	// how long completing an upload may take before another request may
+	// take it over
+	UPLOAD_COMPLETION_TIMEOUT = 30 * time.Minute
+
+	UPLOAD_METHOD_TUS       = "tus"
+	UPLOAD_METHOD_PRESIGNED = "presigned"
+)
+
+func getUploadsCollection() *mongo.Collection {
+	return configs.GetCollection(configs.DB, "uploads")
+}
+
+func setTusHeaders(rw http.ResponseWriter) {
+	rw.Header().Set("Tus-Resumable", TUS_VERSION)
+	rw.Header().Set("Cache-Control", "no-store")
+}
+
+// checkTusVersion rejects clients speaking a protocol version we don't support
+func checkTusVersion(rw http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") != TUS_VERSION {
+		rw.Header().Set("Tus-Version", TUS_VERSION)
+		rw.WriteHeader(http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// parseTusMetadata decodes the Upload-Metadata header ("key base64value,...")
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid metadata value for %q", fields[0])
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("malformed Upload-Metadata header")
+		}
+	}
+	return metadata, nil
+}
+
+func TusOptions() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Tus-Resumable", TUS_VERSION)
+		rw.Header().Set("Tus-Version", TUS_VERSION)
+		rw.Header().Set("Tus-Extension", TUS_EXTENSIONS)
+		rw.Header().Set("Tus-Max-Size", strconv.FormatInt(configs.EnvTusMaxSize(), 10))
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TusCreateUpload() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		setTusHeaders(rw)
+		if !checkTusVersion(rw, r) {
+			return
+		}
+
+		vars := mux.Vars(r)
+		userID := vars["UserID"]
+		visibility := vars["Visibility"]
+		tags := vars["Tags"]
+		if err := authorizeUser(r, userID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		show, _ := strconv.ParseBool(vars["Show"])
+		ispayperview, _ := strconv.ParseBool(vars["IsPayPerView"])
+		isdeleted, _ := strconv.ParseBool(vars["IsDeleted"])
+		price, err := strconv.ParseFloat(vars["PPVPrice"], 64)
+		if err != nil {
+			price = 0
+		}
+		if visibility != VISIBILITY_FOLLOWERS {
+			visibility = VISIBILITY_EVERYONE
+		}
+
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || length <= 0 {
+			errorResponse(rw, apierrors.Validation("invalid Upload-Length"))
+			return
+		}
+		if length > configs.EnvTusMaxSize() {
+			errorResponse(rw, apierrors.New(apierrors.PAYLOAD_TOO_LARGE, fmt.Sprintf("upload exceeds maximum size of %d bytes", configs.EnvTusMaxSize())))
+			return
+		}
+
+		metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+		if err != nil {
+			errorResponse(rw, apierrors.Wrap(apierrors.VALIDATION, err))
+			return
+		}
+
+		now := time.Now()
+		upload := models.Upload{
+			ID:           strings.Replace(uuid.New().String(), "-", "", -1),
+			Method:       UPLOAD_METHOD_TUS,
+			UserID:       userID,
+			Length:       length,
+			Metadata:     metadata,
+			Parts:        []models.UploadPart{},
+			Show:         show,
+			IsPayPerView: ispayperview,
+			IsDeleted:    isdeleted,
+			PPVPrice:     price,
+			Visibility:   visibility,
+			Status:       UPLOAD_STATUS_UPLOADING,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+			ExpiresAt:    now.Add(configs.EnvTusUploadExpiry()),
+		}
+		upload.Tags = strings.Split(tags, ",")
+		for i, s := range upload.Tags {
+			upload.Tags[i] = strings.TrimSpace(s)
+		}
+
+		if _, err := getUploadsCollection().InsertOne(ctx, upload); err != nil {
+			errorResponse(rw, err)
+			return
+		}
+
+		configs.LogWithRequest(r.Context(), "uploads", "create").Info("Created resumable upload", "upload_id", upload.ID, "user_id", userID, "length", length)
+
+		rw.Header().Set("Location", TUS_UPLOAD_URL+upload.ID)
+		rw.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+		rw.WriteHeader(http.StatusCreated)
+	}
+}
+
+// findActiveUpload loads an upload and writes the tus error status when it
+// can no longer receive data
+func findActiveUpload(ctx context.Context, rw http.ResponseWriter, uploadID string) (*models.Upload, bool) {
+	upload := models.Upload{}
+	err := getUploadsCollection().FindOne(ctx, bson.M{"_id": uploadID}).Decode(&upload)
+	if err == mongo.ErrNoDocuments || (err == nil && upload.Status == UPLOAD_STATUS_TERMINATED) {
+		rw.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		configs.LogWithRequest(ctx, "uploads", "load").Error("Error loading upload", "upload_id", uploadID, "error", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	if upload.Status == UPLOAD_STATUS_UPLOADING && time.Now().After(upload.ExpiresAt) {
+		rw.WriteHeader(http.StatusGone)
+		return nil, false
+	}
+	return &upload, true
+}
+
+func TusUploadOffset() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		setTusHeaders(rw)
+		if !checkTusVersion(rw, r) {
+			return
+		}
+
+		upload, ok := findActiveUpload(ctx, rw, mux.Vars(r)["UploadID"])
+		if !ok {
+			return
+		}
+		if err := authorizeUser(r, upload.UserID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		// clients take a full offset as done, so finish what a failed
+		// completion left behind before reporting it
+		if tusUploadUnfinished(upload) && !finishTusUpload(rw, r, upload) {
+			return
+		}
+
+		rw.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		rw.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		if upload.Status == UPLOAD_STATUS_UPLOADING {
+			rw.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+		}
+		if upload.ContentID != "" {
+			rw.Header().Set("Upload-Content-ID", upload.ContentID)
+		}
+		rw.WriteHeader(http.StatusOK)
+	}
+}
+
+func TusPatchUpload() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 120*time.Minute)
+		defer cancel()
+		setTusHeaders(rw)
+		if !checkTusVersion(rw, r) {
+			return
+		}
+
+		if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+			rw.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		upload, ok := findActiveUpload(ctx, rw, mux.Vars(r)["UploadID"])
+		if !ok {
+			return
+		}
+		if err := authorizeUser(r, upload.UserID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		if offset != upload.Offset || (upload.Status != UPLOAD_STATUS_UPLOADING && !tusUploadUnfinished(upload)) {
+			rw.WriteHeader(http.StatusConflict)
+			return
+		}
+		if tusUploadUnfinished(upload) {
+			if finishTusUpload(rw, r, upload) {
+				rw.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+				rw.WriteHeader(http.StatusNoContent)
+			}
+			return
+		}
+
+		// Spool the chunk to disk first so the bytes received before a dropped
+		// connection are kept and the client can resume after them
+		tmp, err := os.CreateTemp("", "tus-chunk-*")
+		if err != nil {
+			configs.LogWithRequest(r.Context(), "uploads", "patch").Error("Error creating chunk file", "error", err)
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		received, copyErr := io.Copy(tmp, io.LimitReader(r.Body, upload.Length-upload.Offset))
+		if copyErr != nil {
+			configs.LogWithRequest(r.Context(), "uploads", "patch").Warn("Chunk interrupted", "upload_id", upload.ID, "received", received, "error", copyErr)
+		}
+		if received == 0 {
+			rw.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		update := bson.M{"updated_at": time.Now(), "offset": offset + received}
+
+		// The first chunk tells us what kind of file this is
+		if offset == 0 {
+			sniff := make([]byte, 512)
+			n, _ := io.ReadFull(tmp, sniff)
+			mime := http.DetectContentType(sniff[:n])
+			extension := videoExtensionFor(mime, upload.Metadata["filename"])
+			if extension == "" {
+				terminateUpload(ctx, upload)
+				errorResponse(rw, apierrors.New(apierrors.UNSUPPORTED_MEDIA_TYPE, "invalid video file type"))
+				return
+			}
+			upload.MimeType = mime
+			upload.Extension = extension
+			update["mime_type"] = mime
+			update["extension"] = extension
+			if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+				rw.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		part := models.UploadPart{
+			Key:    fmt.Sprintf("tus/%s/%020d-%s", upload.ID, offset, strings.Replace(uuid.New().String(), "-", "", -1)[:8]),
+			Offset: offset,
+			Size:   received,
+		}
+		store := storage.Backend()
+		if err := store.Put(ctx, configs.EnvRawBucket(), part.Key, tmp, "application/octet-stream"); err != nil {
+			configs.LogWithRequest(r.Context(), "uploads", "patch").Error("Error storing upload chunk", "upload_id", upload.ID, "error", err)
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Only advance the offset if no other request got there first
+		res, err := getUploadsCollection().UpdateOne(ctx,
+			bson.M{"_id": upload.ID, "offset": offset, "status": UPLOAD_STATUS_UPLOADING},
+			bson.M{"$set": update, "$push": bson.M{"parts": part}},
+		)
+		if err != nil || res.MatchedCount == 0 {
+			store.Delete(ctx, configs.EnvRawBucket(), part.Key)
+			if err != nil {
+				configs.LogWithRequest(r.Context(), "uploads", "patch").Error("Error updating upload offset", "upload_id", upload.ID, "error", err)
+				rw.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			rw.WriteHeader(http.StatusConflict)
+			return
+		}
+		upload.Offset = offset + received
+		upload.Parts = append(upload.Parts, part)
+
+		if upload.Offset == upload.Length && !finishTusUpload(rw, r, upload) {
+			return
+		}
+
+		rw.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TusTerminateUpload() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		setTusHeaders(rw)
+		if !checkTusVersion(rw, r) {
+			return
+		}
+
+		upload, ok := findActiveUpload(ctx, rw, mux.Vars(r)["UploadID"])
+		if !ok {
+			return
+		}
+		if err := authorizeUser(r, upload.UserID); err != nil {
+			errorResponse(rw, err)
+			return
+		}
+		switch upload.Status {
+		case UPLOAD_STATUS_COMPLETED:
+			errorResponse(rw, apierrors.Conflict("upload already completed"))
+			return
+		case UPLOAD_STATUS_COMPLETING:
+			errorResponse(rw, apierrors.Conflict("upload is being completed"))
+			return
+		}
+		terminateUpload(ctx, upload)
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// tusUploadUnfinished tells whether an upload has every byte but no Content
+// yet, because completing it failed or the instance doing it went away
+func tusUploadUnfinished(upload *models.Upload) bool {
+	if upload.Offset != upload.Length {
+		return false
+	}
+	return upload.Status == UPLOAD_STATUS_UPLOADING ||
+		(upload.Status == UPLOAD_STATUS_COMPLETING && time.Since(upload.UpdatedAt) > UPLOAD_COMPLETION_TIMEOUT)
+}
+
+// finishTusUpload completes an upload whose last byte is in and sets the
+// Upload-Content-ID header, or writes the error response. Only one request
+// completes an upload at a time; when completing fails for reasons other than
+// the video being rejected the upload goes back to uploading, so the next
+// HEAD or PATCH retries it.
+func finishTusUpload(rw http.ResponseWriter, r *http.Request, upload *models.Upload) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), UPLOAD_COMPLETION_TIMEOUT)
+	defer cancel()
+	logger := configs.LogWithRequest(r.Context(), "uploads", "complete")
+
+	claimed := time.Now()
+	res, err := getUploadsCollection().UpdateOne(ctx,
+		bson.M{"_id": upload.ID, "offset": upload.Length, "$or": []bson.M{
+			{"status": UPLOAD_STATUS_UPLOADING},
+			{"status": UPLOAD_STATUS_COMPLETING, "updated_at": bson.M{"$lt": claimed.Add(-UPLOAD_COMPLETION_TIMEOUT)}},
+		}},
+		bson.M{"$set": bson.M{"status": UPLOAD_STATUS_COMPLETING, "updated_at": claimed}})
+	if err != nil {
+		logger.Error("Error claiming upload completion", "upload_id", upload.ID, "error", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if res.MatchedCount == 0 {
+		errorResponse(rw, apierrors.Conflict("upload is already being completed"))
+		return false
+	}
+
+	contentID, err := completeTusUpload(ctx, r, upload)
+	var rejected *apierrors.Error
+	if errors.As(err, &rejected) {
+		terminateUpload(ctx, upload)
+		errorResponse(rw, err)
+		return false
+	}
+	if err != nil {
+		logger.Error("Error completing upload", "upload_id", upload.ID, "error", err)
+		_, resetErr := getUploadsCollection().UpdateOne(ctx,
+			bson.M{"_id": upload.ID, "status": UPLOAD_STATUS_COMPLETING, "updated_at": claimed},
+			bson.M{"$set": bson.M{"status": UPLOAD_STATUS_UPLOADING, "updated_at": time.Now()}})
+		if resetErr != nil {
+			logger.Error("Error reopening upload", "upload_id", upload.ID, "error", resetErr)
+		}
+		errorResponse(rw, apierrors.Internal("error assembling upload", err))
+		return false
+	}
+	upload.Status = UPLOAD_STATUS_COMPLETED
+	upload.ContentID = contentID
+	rw.Header().Set("Upload-Content-ID", contentID)
+	return true
+}
+
+// completeTusUpload stitches the parts into the raw bucket and creates the
+// Content record exactly like PostVideoNTWithBody does. The parts are
+// assembled on disk first so the video can be probed; a video that's
+// rejected comes back as an *apierrors.Error. A retry after the Content was
+// created only finishes the bookkeeping.
+func completeTusUpload(ctx context.Context, r *http.Request, upload *models.Upload) (string, error) {
+	store := storage.Backend()
+	videoID := upload.ID
+	s3VideoKey := fmt.Sprintf("%s/%s.%s", upload.UserID, videoID, upload.Extension)
+
+	existing := models.Content{}
+	err := getContentCollection().FindOne(ctx, bson.M{"video_id": videoID}).Decode(&existing)
+	if err == nil {
+		return existing.Id.Hex(), markTusUploadCompleted(ctx, upload, existing.Id.Hex())
+	}
+	if err != mongo.ErrNoDocuments {
+		return "", err
+	}
+
+	logger := configs.LogWithRequest(r.Context(), "uploads", "complete")
+	logger.Info("Assembling upload", "upload_id", upload.ID, "parts", len(upload.Parts), "bucket", configs.EnvRawBucket(), "key", s3VideoKey)
+
+	assembled, err := os.CreateTemp("", "tus-upload-*."+upload.Extension)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(assembled.Name())
+	defer assembled.Close()
+	for _, part := range upload.Parts {
+		rc, _, err := store.Get(ctx, configs.EnvRawBucket(), part.Key)
+		if err != nil {
+			return "", fmt.Errorf("reading part %s: %w", part.Key, err)
+		}
+		_, err = io.Copy(assembled, rc)
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	mediaInfo, err := inspectVideo(ctx, r, assembled.Name())
+	if err != nil {
+		return "", err
+	}
+	if _, err := assembled.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	s3VideoKey, err = storeDedupedVideo(ctx, s3VideoKey, assembled, upload.MimeType)
+	if err != nil {
+		return "", err
+	}
+
+	newPostVid := models.Content{
+		VideoID:      videoID,
+		UserID:       upload.UserID,
+		Poster:       upload.UserID,
+		Title:        upload.Metadata["title"],
+		Description:  upload.Metadata["description"],
+		S3RawKey:     s3VideoKey,
+		DateCreated:  time.Now(),
+		Show:         upload.Show,
+		IsPayPerView: upload.IsPayPerView,
+		IsDeleted:    upload.IsDeleted,
+		PPVPrice:     upload.PPVPrice,
+		Tags:         upload.Tags,
+		Type:         TYPE_VIDEO,
+		Visibility:   upload.Visibility,
+		Transcoding:  TRANSCODING_PENDING,
+		MediaInfo:    mediaInfo,
+	}
+	result, err := getContentCollection().InsertOne(ctx, newPostVid)
+	if err != nil {
+		// the retry stores the video again and takes a new reference
+		releaseBlob(ctx, configs.EnvRawBucket(), s3VideoKey)
+		return "", err
+	}
+	contentObjectID := result.InsertedID.(primitive.ObjectID)
+	contentID := contentObjectID.Hex()
+	enqueueTranscoding(ctx, contentObjectID, videoID, upload.UserID, s3VideoKey)
+
+	if err := markTusUploadCompleted(ctx, upload, contentID); err != nil {
+		return "", err
+	}
+	logger.Info("Upload completed", "upload_id", upload.ID, "content_id", contentID)
+	return contentID, nil
+}
+
+func markTusUploadCompleted(ctx context.Context, upload *models.Upload, contentID string) error {
+	_, err := getUploadsCollection().UpdateOne(ctx, bson.M{"_id": upload.ID}, bson.M{"$set": bson.M{
+		"status":     UPLOAD_STATUS_COMPLETED,
+		"content_id": contentID,
+		"video_id":   upload.ID,
+		"updated_at": time.Now(),
+	}})
+	if err != nil {
+		return fmt.Errorf("marking upload completed: %w", err)
+	}
+	deleteUploadParts(ctx, upload)
+	return nil
+}
+
+func terminateUpload(ctx context.Context, upload *models.Upload) {
+	_, err := getUploadsCollection().UpdateOne(ctx, bson.M{"_id": upload.ID}, bson.M{"$set": bson.M{
+		"status":     UPLOAD_STATUS_TERMINATED,
+		"updated_at": time.Now(),
+	}})
+	if err != nil {
+		configs.LogWithRequest(ctx, "uploads", "terminate").Error("Error terminating upload", "upload_id", upload.ID, "error", err)
+	}
+	if upload.Method == UPLOAD_METHOD_PRESIGNED {
+		abortPresignedUpload(ctx, upload)
+		return
+	}
+	deleteUploadParts(ctx, upload)
+}
+
+func deleteUploadParts(ctx context.Context, upload *models.Upload) {
+	for _, part := range upload.Parts {
+		deleteStoredObject(configs.EnvRawBucket(), part.Key)
+	}
+}
+
+// CleanupExpiredUploads periodically terminates resumable and presigned uploads that were
+// abandoned before completion and removes their stored parts
+func CleanupExpiredUploads() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		removeExpiredUploads()
+	}
+}
+
+func removeExpiredUploads() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	logger := configs.LogWithContext("uploads", "cleanup")
+
+	filter := bson.M{"status": UPLOAD_STATUS_UPLOADING, "expires_at": bson.M{"$lt": time.Now()}}
+	cur, err := getUploadsCollection().Find(ctx, filter)
+	if err != nil {
+		logger.Error("Error finding expired uploads", "error", err)
+		return
+	}
+	var uploads []models.Upload
+	if err := cur.All(ctx, &uploads); err != nil {
+		logger.Error("Error decoding expired uploads", "error", err)
+		return
+	}
+	for i := range uploads {
+		terminateUpload(ctx, &uploads[i])
+	}
+	if len(uploads) > 0 {
+		logger.Info("Removed expired uploads", "count", len(uploads))
+	}
+}
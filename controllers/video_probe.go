@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+	"upload-service/apierrors"
+	"upload-service/auth"
+	"upload-service/configs"
+	"upload-service/models"
+	"upload-service/storage"
+	"upload-service/transcode"
+)
+
+// Raw video uploads are probed before their content is created: the result
+// is kept as the content's media_info, and videos without a video stream or
+// beyond the uploader's tier limits (see configs.EnvVideoTierLimits) are
+// rejected.
+
+const VIDEO_TIER_DEFAULT = "default"
+
+var videoProber transcode.Prober
+
+// SetVideoProber sets how uploaded videos are probed; without a prober they
+// are accepted unprobed. A prober whose tool isn't installed refuses every
+// video, since the tier limits can't be checked.
+func SetVideoProber(prober transcode.Prober) {
+	videoProber = prober
+}
+
+// videoLimitsFor picks the limits of the caller's tier. Admins, services
+// acting for users and everyone while auth is disabled have none.
+func videoLimitsFor(r *http.Request) (configs.VideoLimits, string) {
+	caller, _ := auth.FromContext(r.Context())
+	if !configs.EnvAuthEnabled() || caller.HasScope(auth.SCOPE_USERS) || caller.HasRole(auth.ROLE_ADMIN) {
+		return configs.VideoLimits{}, ""
+	}
+	tiers := configs.EnvVideoTierLimits()
+	for _, role := range caller.Roles {
+		if limits, ok := tiers[role]; ok {
+			return limits, role
+		}
+	}
+	return tiers[VIDEO_TIER_DEFAULT], VIDEO_TIER_DEFAULT
+}
+
+// inspectVideo probes the video at file and checks it against the caller's
+// tier. Rejections are *apierrors.Error; other errors mean the video couldn't
+// be inspected, including when ffprobe is missing. The info is nil when
+// probing is off.
+func inspectVideo(ctx context.Context, r *http.Request, file string) (*models.MediaInfo, error) {
+	if videoProber == nil {
+		return nil, nil
+	}
+	logger := configs.LogWithRequest(r.Context(), "content", "probe-video")
+	probed, err := videoProber.Probe(ctx, file)
+	if errors.Is(err, transcode.ErrProberUnavailable) {
+		logger.Error("Refusing video, it can't be probed", "error", err)
+		return nil, err
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		logger.Info("Rejected unreadable video", "error", err)
+		return nil, apierrors.New(apierrors.UNSUPPORTED_MEDIA_TYPE, "the file could not be read as a video")
+	}
+	if probed.VideoCodec == "" {
+		return nil, apierrors.New(apierrors.UNSUPPORTED_MEDIA_TYPE, "the file has no video stream")
+	}
+
+	width, height := probed.DisplaySize()
+	info := &models.MediaInfo{
+		Duration:   probed.Duration,
+		Width:      width,
+		Height:     height,
+		Rotation:   probed.Rotation,
+		VideoCodec: probed.VideoCodec,
+		AudioCodec: probed.AudioCodec,
+		Bitrate:    probed.Bitrate,
+		FrameRate:  probed.FrameRate,
+		Container:  probed.Container,
+	}
+
+	limits, tier := videoLimitsFor(r)
+	duration := time.Duration(info.Duration * float64(time.Second))
+	if limits.MaxDuration > 0 && duration > limits.MaxDuration {
+		return nil, apierrors.Unprocessable(fmt.Sprintf("video is %s long, the %s tier allows up to %s",
+			duration.Round(time.Second), tier, limits.MaxDuration))
+	}
+	resolution := min(width, height)
+	if limits.MaxResolution > 0 && resolution > limits.MaxResolution {
+		return nil, apierrors.Unprocessable(fmt.Sprintf("video is %dp, the %s tier allows up to %dp",
+			resolution, tier, limits.MaxResolution))
+	}
+	return info, nil
+}
+
+// inspectUploadedVideo inspects a video from a multipart form and rewinds it
+// so it can still be stored. Large parts are already spooled to disk and
+// probed in place; smaller ones are written out first.
+func inspectUploadedVideo(ctx context.Context, r *http.Request, file multipart.File) (*models.MediaInfo, error) {
+	if videoProber == nil {
+		return nil, nil
+	}
+	defer file.Seek(0, io.SeekStart)
+	if spooled, ok := file.(*os.File); ok {
+		return inspectVideo(ctx, r, spooled.Name())
+	}
+
+	tmp, err := os.CreateTemp("", "probe-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(tmp, file); err != nil {
+		return nil, err
+	}
+	return inspectVideo(ctx, r, tmp.Name())
+}
+
+// inspectStoredVideo inspects a video already in storage
+func inspectStoredVideo(ctx context.Context, r *http.Request, store storage.Storage, bucket, key string) (*models.MediaInfo, error) {
+	if videoProber == nil {
+		return nil, nil
+	}
+	dir, err := os.MkdirTemp("", "probe-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "source"+filepath.Ext(key))
+	if err := downloadObject(ctx, store, bucket, key, file); err != nil {
+		return nil, fmt.Errorf("downloading video: %w", err)
+	}
+	return inspectVideo(ctx, r, file)
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"upload-service/apierrors"
+	"upload-service/auth"
+	"upload-service/configs"
+	"upload-service/transcode"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestInspectVideo(t *testing.T) {
+	configs.Logger = logrus.New()
+	configs.Logger.SetOutput(io.Discard)
+	t.Setenv("AUTH_ENABLED", "true")
+	t.Setenv("VIDEO_TIER_LIMITS", "default=10m:720")
+
+	hd := transcode.MediaInfo{Duration: 60, Width: 1280, Height: 720, VideoCodec: "h264", AudioCodec: "aac"}
+	tests := []struct {
+		name  string
+		probe transcode.MediaInfo
+		err   error
+		code  apierrors.Code // "" when the video is accepted
+		fail  bool           // refused without a rejection
+	}{
+		{name: "within the tier", probe: hd},
+		{name: "rotated within the tier", probe: transcode.MediaInfo{Duration: 60, Width: 720, Height: 1280, Rotation: 90, VideoCodec: "h264"}},
+		{name: "no video stream", probe: transcode.MediaInfo{Duration: 60, AudioCodec: "aac"}, code: apierrors.UNSUPPORTED_MEDIA_TYPE},
+		{name: "unreadable", err: errors.New("invalid data found when processing input"), code: apierrors.UNSUPPORTED_MEDIA_TYPE},
+		{name: "too long", probe: transcode.MediaInfo{Duration: 3600, Width: 1280, Height: 720, VideoCodec: "h264"}, code: apierrors.UNPROCESSABLE},
+		{name: "ffprobe missing", err: transcode.ErrProberUnavailable, fail: true},
+		{name: "too large", probe: transcode.MediaInfo{Duration: 60, Width: 1920, Height: 1080, VideoCodec: "h264"}, code: apierrors.UNPROCESSABLE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prober := &transcode.FakeProber{Info: tt.probe, Err: tt.err}
+			SetVideoProber(prober)
+			t.Cleanup(func() { SetVideoProber(nil) })
+
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r = r.WithContext(auth.WithIdentity(r.Context(), auth.Identity{UserID: "user"}))
+			info, err := inspectVideo(context.Background(), r, "upload.mp4")
+
+			if files := prober.Files(); len(files) != 1 || files[0] != "upload.mp4" {
+				t.Errorf("probed %v, want [upload.mp4]", files)
+			}
+			var apiErr *apierrors.Error
+			if tt.fail {
+				if !errors.Is(err, transcode.ErrProberUnavailable) || errors.As(err, &apiErr) || info != nil {
+					t.Errorf("got %+v, %v, want a refusal", info, err)
+				}
+				return
+			}
+			if tt.code == "" {
+				if err != nil {
+					t.Fatalf("rejected: %v", err)
+				}
+				if info == nil || info.VideoCodec != tt.probe.VideoCodec || info.Duration != tt.probe.Duration {
+					t.Errorf("media info = %+v, want the probe's", info)
+				}
+				return
+			}
+			if !errors.As(err, &apiErr) || apiErr.Code != tt.code {
+				t.Errorf("got %v, want a %s rejection", err, tt.code)
+			}
+		})
+	}
+}
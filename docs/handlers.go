@@ -0,0 +1,33 @@
+package docs
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"html/template"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+//go:embed ui.html
+var uiPage []byte
+
+// SpecHandler serves the OpenAPI document. The router is walked on every
+// request so routes registered after this handler are included.
+func SpecHandler(router *mux.Router, title string, version string, ops []Operation) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(Build(router, title, version, ops))
+	}
+}
+
+// UIHandler serves the bundled docs page, which renders the document served
+// at specURL
+func UIHandler(specURL string) http.HandlerFunc {
+	page := bytes.Replace(uiPage, []byte("{{SPEC_URL}}"), []byte(template.JSEscapeString(specURL)), 1)
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Write(page)
+	}
+}
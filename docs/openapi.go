@@ -0,0 +1,381 @@
+// Package docs builds the OpenAPI 3 document of the service. Paths and path
+// parameters come from the routes registered on the mux router, everything
+// else (summaries, request bodies, response models) from the Operation table
+// kept next to the route registrations.
+package docs
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"upload-service/apierrors"
+	"upload-service/models"
+
+	"github.com/gorilla/mux"
+)
+
+const OPENAPI_VERSION = "3.0.3"
+
+// Operation documents one method of one registered route
+type Operation struct {
+	Method  string
+	Path    string // mux path template, e.g. /uploadmicro/v1/like/{UserID}/{LikedContent}
+	Tag     string
+	Summary string
+
+	// Body is the JSON request body model. When Files is set the request is
+	// multipart instead and Body describes its "data" part.
+	Body   interface{}
+	Files  []string // multipart file parts
+	Fields []string // plain form fields
+	Query  []string
+	Header []string // optional request headers
+
+	Status   int         // success status, 201 (what successResponse writes) when unset
+	Response interface{} // model returned under data.data
+	Raw      bool        // Response is written bare instead of in a ContentResponse
+	Paged    bool        // Response is one cursor page; adds limit, cursor and next_cursor
+	Counted  bool        // a Paged Response also reports the total matching items
+}
+
+func (op Operation) key() string {
+	return strings.ToUpper(op.Method) + " " + op.Path
+}
+
+func (op Operation) status() int {
+	if op.Status != 0 {
+		return op.Status
+	}
+	return http.StatusCreated
+}
+
+// Route is a registered method and path template
+type Route struct {
+	Method string
+	Path   string
+}
+
+func (r Route) String() string {
+	return r.Method + " " + r.Path
+}
+
+// Routes lists every method and path template registered on router. Routes
+// without a method matcher (path prefixes, file servers) are reported as GET.
+func Routes(router *mux.Router) []Route {
+	routes := []Route{}
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{http.MethodGet}
+		}
+		for _, method := range methods {
+			routes = append(routes, Route{Method: method, Path: path})
+		}
+		return nil
+	})
+	return routes
+}
+
+// Undocumented lists the registered routes that have no Operation
+func Undocumented(router *mux.Router, ops []Operation) []Route {
+	documented := map[string]bool{}
+	for _, op := range ops {
+		documented[op.key()] = true
+	}
+	missing := []Route{}
+	for _, route := range Routes(router) {
+		if !documented[route.String()] {
+			missing = append(missing, route)
+		}
+	}
+	return sortedRoutes(missing)
+}
+
+// Unrouted lists the Operations whose route isn't registered, usually stale
+// entries left behind by a removed or renamed route
+func Unrouted(router *mux.Router, ops []Operation) []Operation {
+	registered := map[string]bool{}
+	for _, route := range Routes(router) {
+		registered[route.String()] = true
+	}
+	stale := []Operation{}
+	for _, op := range ops {
+		if !registered[op.key()] {
+			stale = append(stale, op)
+		}
+	}
+	return stale
+}
+
+// Build generates the OpenAPI document for the routes registered on router.
+// Routes without an Operation are left out; see Undocumented.
+func Build(router *mux.Router, title string, version string, ops []Operation) map[string]interface{} {
+	byKey := map[string]Operation{}
+	for _, op := range ops {
+		byKey[op.key()] = op
+	}
+
+	gen := newSchemaGenerator()
+	paths := map[string]interface{}{}
+	for _, route := range Routes(router) {
+		op, ok := byKey[route.String()]
+		if !ok {
+			continue
+		}
+		path, params := openAPIPath(route.Path)
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = gen.operation(op, params)
+	}
+
+	components := gen.components
+	components["ContentResponse"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"status":  map[string]interface{}{"type": "integer"},
+			"message": map[string]interface{}{"type": "string"},
+			"code":    map[string]interface{}{"type": "string"},
+			"data":    map[string]interface{}{"type": "object"},
+		},
+	}
+	components["ErrorResponse"] = errorSchema(gen)
+
+	return map[string]interface{}{
+		"openapi": OPENAPI_VERSION,
+		"info":    map[string]interface{}{"title": title, "version": version},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"apiKey":     map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+		// users authenticate with a JWT, internal services with an API key
+		"security": []interface{}{
+			map[string]interface{}{"bearerAuth": []string{}},
+			map[string]interface{}{"apiKey": []string{}},
+		},
+	}
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// openAPIPath turns a mux template into an OpenAPI path, dropping the
+// regular expressions mux allows inside the braces
+func openAPIPath(template string) (string, []string) {
+	params := []string{}
+	for _, match := range pathParamPattern.FindAllStringSubmatch(template, -1) {
+		params = append(params, match[1])
+	}
+	return pathParamPattern.ReplaceAllString(template, "{$1}"), params
+}
+
+func (g *schemaGenerator) operation(op Operation, pathParams []string) map[string]interface{} {
+	params := []interface{}{}
+	for _, name := range pathParams {
+		params = append(params, map[string]interface{}{
+			"name": name, "in": "path", "required": true,
+			"schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	query := op.Query
+	if op.Paged {
+		query = append(append([]string{}, query...), "limit", "cursor")
+	}
+	for _, name := range query {
+		params = append(params, map[string]interface{}{
+			"name": name, "in": "query",
+			"schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, name := range op.Header {
+		params = append(params, map[string]interface{}{
+			"name": name, "in": "header",
+			"schema": map[string]interface{}{"type": "string"},
+		})
+	}
+
+	tags := []string{}
+	if op.Tag != "" {
+		tags = append(tags, op.Tag)
+	}
+	operation := map[string]interface{}{
+		"summary":     op.Summary,
+		"tags":        tags,
+		"operationId": operationID(op),
+		"parameters":  params,
+		"responses": map[string]interface{}{
+			strconv.Itoa(op.status()): g.successResponse(op),
+			"default": map[string]interface{}{
+				"description": "error",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": ref("ErrorResponse")},
+				},
+			},
+		},
+	}
+	if body := g.requestBody(op); body != nil {
+		operation["requestBody"] = body
+	}
+	return operation
+}
+
+func (g *schemaGenerator) requestBody(op Operation) map[string]interface{} {
+	switch {
+	case len(op.Files) > 0:
+		properties := map[string]interface{}{}
+		encoding := map[string]interface{}{}
+		for _, name := range op.Files {
+			properties[name] = map[string]interface{}{"type": "string", "format": "binary"}
+		}
+		for _, name := range op.Fields {
+			properties[name] = map[string]interface{}{"type": "string"}
+		}
+		if op.Body != nil {
+			properties["data"] = g.schemaOf(op.Body)
+			encoding["data"] = map[string]interface{}{"contentType": "application/json"}
+		}
+		media := map[string]interface{}{
+			"schema": map[string]interface{}{"type": "object", "properties": properties, "required": op.Files},
+		}
+		if len(encoding) > 0 {
+			media["encoding"] = encoding
+		}
+		return map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"multipart/form-data": media},
+		}
+	case op.Body != nil:
+		return map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": g.schemaOf(op.Body)},
+			},
+		}
+	case len(op.Fields) > 0:
+		properties := map[string]interface{}{}
+		for _, name := range op.Fields {
+			properties[name] = map[string]interface{}{"type": "string"}
+		}
+		return map[string]interface{}{
+			"content": map[string]interface{}{
+				"application/x-www-form-urlencoded": map[string]interface{}{
+					"schema": map[string]interface{}{"type": "object", "properties": properties},
+				},
+			},
+		}
+	}
+	return nil
+}
+
+// dataProperties describes what a ContentResponse's data object holds
+func (g *schemaGenerator) dataProperties(op Operation) map[string]interface{} {
+	properties := map[string]interface{}{"data": g.schemaOf(op.Response)}
+	if op.Paged {
+		properties["next_cursor"] = map[string]interface{}{
+			"type":        "string",
+			"description": "pass as cursor to get the next page; empty on the last page",
+		}
+	}
+	if op.Counted {
+		properties["total"] = map[string]interface{}{"type": "integer", "format": "int64"}
+	}
+	return properties
+}
+
+func (g *schemaGenerator) successResponse(op Operation) map[string]interface{} {
+	if op.Raw {
+		response := map[string]interface{}{"description": http.StatusText(op.status())}
+		if op.Response != nil {
+			response["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": g.schemaOf(op.Response)},
+			}
+		}
+		return response
+	}
+
+	schema := ref("ContentResponse")
+	if op.Response != nil {
+		schema = map[string]interface{}{
+			"allOf": []interface{}{
+				ref("ContentResponse"),
+				map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"data": map[string]interface{}{
+							"type":       "object",
+							"properties": g.dataProperties(op),
+						},
+					},
+				},
+			},
+		}
+	}
+	return map[string]interface{}{
+		"description": http.StatusText(op.status()),
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schema},
+		},
+	}
+}
+
+func errorSchema(g *schemaGenerator) map[string]interface{} {
+	codes := []string{}
+	for _, code := range apierrors.Codes() {
+		codes = append(codes, string(code))
+	}
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"status":  map[string]interface{}{"type": "integer"},
+			"message": map[string]interface{}{"type": "string", "enum": []string{"error"}},
+			"code":    map[string]interface{}{"type": "string", "enum": codes},
+			"data": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"data":   map[string]interface{}{"type": "string"},
+					"errors": map[string]interface{}{"type": "array", "items": g.schemaOf(models.FieldError{})},
+				},
+			},
+		},
+	}
+}
+
+// operationID derives a stable id from method and path, since many legacy
+// routes share a handler
+func operationID(op Operation) string {
+	path, _ := openAPIPath(op.Path)
+	parts := []string{strings.ToLower(op.Method)}
+	for _, segment := range strings.Split(path, "/") {
+		segment = strings.Trim(segment, "{}")
+		if segment != "" {
+			parts = append(parts, segment)
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// sortedRoutes orders routes by path then method, for stable logs
+func sortedRoutes(routes []Route) []Route {
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
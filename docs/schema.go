@@ -0,0 +1,134 @@
+package docs
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	dateTimeType = reflect.TypeOf(primitive.DateTime(0))
+	bytesType    = reflect.TypeOf([]byte(nil))
+)
+
+// schemaGenerator derives JSON schemas from Go models the way encoding/json
+// would serialise them. Named structs become components referenced by $ref.
+type schemaGenerator struct {
+	components map[string]interface{}
+	names      map[reflect.Type]string
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{components: map[string]interface{}{}, names: map[reflect.Type]string{}}
+}
+
+func (g *schemaGenerator) schemaOf(v interface{}) map[string]interface{} {
+	return g.schema(reflect.TypeOf(v))
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType, dateTimeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case objectIDType:
+		return map[string]interface{}{"type": "string", "pattern": "^[0-9a-f]{24}$"}
+	case bytesType:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return ref(g.component(t))
+	}
+	// interface{} and anything encoding/json can't describe statically
+	return map[string]interface{}{}
+}
+
+// component registers a named struct once and returns its component name.
+// Types from different packages sharing a name are told apart by package.
+func (g *schemaGenerator) component(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	for other := range g.names {
+		if g.names[other] == name {
+			name = pkgName(t) + name
+			break
+		}
+	}
+	g.names[t] = name
+	// reserve the name before recursing so self-referencing models terminate
+	g.components[name] = map[string]interface{}{}
+	g.components[name] = g.structSchema(t)
+	return name
+}
+
+func pkgName(t reflect.Type) string {
+	path := t.PkgPath()
+	name := path[strings.LastIndex(path, "/")+1:]
+	if name == "" {
+		return ""
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	g.addFields(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func (g *schemaGenerator) addFields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		// untagged embedded structs are flattened, as encoding/json does
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				g.addFields(embedded, properties)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schema(field.Type)
+	}
+}
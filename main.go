@@ -5,15 +5,21 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/exec"
 	"os/signal"
 	"syscall"
 	"time"
+	"upload-service/auth"
 	"upload-service/configs"
 	"upload-service/controllers"
+	"upload-service/docs"
+	"upload-service/media"
 
 	//"upload-service/controllers"
 	"upload-service/middleware"
 	"upload-service/routes"
+	"upload-service/storage"
+	"upload-service/transcode"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
@@ -29,8 +35,10 @@ func main() {
 	router := mux.NewRouter()
 
 	// Add middleware
+	router.Use(middleware.RequestID)
 	router.Use(middleware.LoggingMiddleware)
 	router.Use(middleware.RecoveryMiddleware)
+	router.Use(middleware.Authenticate(initializeAuth(logger)))
 
 	logger.Info("Middleware configured")
 
@@ -49,30 +57,56 @@ func main() {
 	}
 	logger.Info("Redis connected successfully")
 
-	logger.Info("Connecting to AWS S3...")
-	if err := initializeAWS(logger); err != nil {
-		logger.Fatal("Failed to initialize AWS S3", "error", err)
+	logger.Info("Initializing media storage...")
+	if err := initializeStorage(logger); err != nil {
+		logger.Fatal("Failed to initialize media storage", "error", err)
 		return
 	}
 
+	media.Init()
+	logger.Info("HEIC decoder configured", "decoder", configs.EnvHEICDecoder())
+
+	controllers.SetVideoProber(transcode.NewFFprobe(configs.EnvFFprobePath()))
+	logger.Info("Video probing configured", "ffprobe", configs.EnvFFprobePath())
+	if _, err := exec.LookPath(configs.EnvFFprobePath()); err != nil {
+		logger.Error("ffprobe not found, video uploads will be refused", "error", err)
+	}
+
+	if err := controllers.EnsureMediaBlobIndexes(); err != nil {
+		logger.Warn("Failed to create media blob indexes", "error", err)
+	}
+
+	if err := controllers.EnsurePerceptualHashIndexes(); err != nil {
+		logger.Warn("Failed to create perceptual hash indexes", "error", err)
+	}
+
+	if err := controllers.EnsureTranscodingJobIndexes(); err != nil {
+		logger.Warn("Failed to create transcoding job indexes", "error", err)
+	}
+
 	// Start live stream monitor TODO STOP FOR NOW
 	go controllers.MonitorLiveStreams()
 	logger.Info("Live stream monitor started")
 
+	go controllers.CleanupExpiredUploads()
+	logger.Info("Resumable upload cleanup started")
+
+	go controllers.PurgeDeletedContent()
+	logger.Info("Deleted content purge started", "after", configs.EnvContentPurgeAfter())
+
+	go controllers.RelayTranscodingEvents()
+	logger.Info("Transcoding event relay started")
+
+	if workers := configs.EnvTranscodeWorkers(); workers > 0 {
+		controllers.StartTranscodingWorkers(transcode.NewScriptRunner(configs.EnvTranscodeScript()), workers)
+		logger.Info("Transcoding workers started", "workers", workers)
+	}
+
 	// Register routes with logging
 	logger.Info("Registering API routes...")
-	registerRoutes(router, logger)
+	routes.Register(router, logger)
 
-	// Health check endpoints
-	router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintln(w, "OK")
-	}).Methods("GET")
-
-	router.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintln(w, "Ready")
-	}).Methods("GET")
+	checkRouteDocs(router, logger)
 
 	// Get port configuration
 	port := os.Getenv("PORT")
@@ -117,6 +151,29 @@ func main() {
 		logger.Info("Server shutdown complete")
 	}
 
+	// Jobs still running go back on the queue for another instance
+	controllers.StopTranscodingWorkers()
+
+}
+
+func initializeAuth(logger *logrus.Entry) (*auth.Verifier, *auth.APIKeys) {
+	if !configs.EnvAuthEnabled() {
+		logger.Warn("Authentication is disabled, every caller may act as any user")
+	}
+	keys := auth.NewAPIKeys(configs.EnvServiceAPIKeys(), configs.EnvServiceScopes())
+	for service := range configs.EnvServiceAPIKeys() {
+		logger.Info("Service API key configured", "service", service, "scopes", configs.EnvServiceScopes()[service])
+	}
+
+	verifier, err := auth.NewVerifierFromEnv()
+	if err == auth.ErrNoVerificationKey {
+		logger.Warn("No JWT verification key configured, only service API keys are accepted")
+		return nil, keys
+	}
+	if err != nil {
+		logger.Fatal("Failed to load JWT verification keys", "error", err)
+	}
+	return verifier, keys
 }
 
 func initializeAWS(logger *logrus.Entry) error {
@@ -133,6 +190,21 @@ func initializeAWS(logger *logrus.Entry) error {
 	return nil
 }
 
+func initializeStorage(logger *logrus.Entry) error {
+	backend := configs.EnvStorageBackend()
+	if backend == storage.BackendS3 {
+		logger.Info("Connecting to AWS S3...")
+		if err := initializeAWS(logger); err != nil {
+			return err
+		}
+	}
+	if err := storage.Init(); err != nil {
+		return fmt.Errorf("storage init failed: %w", err)
+	}
+	logger.Info("Media storage initialized", "backend", backend, "media_dir", configs.EnvMediaDir())
+	return nil
+}
+
 func initializeDatabases(logger *logrus.Entry) error {
 	// Connect to MongoDB
 	start := time.Now()
@@ -176,34 +248,13 @@ func connectPostgreSQL() error {
 	return configs.ConnectPSQLDatabase()
 }
 
-func registerRoutes(router *mux.Router, logger *logrus.Entry) {
-	// Register all route groups with logging
-	routes.ContentRoutes(router)
-	logger.Info("Content routes registered")
-
-	routes.CommentRoutes(router)
-	logger.Info("Comment routes registered")
-
-	routes.LikesRoutes(router)
-	logger.Info("Likes routes registered")
-
-	routes.FavoritesRoutes(router)
-	logger.Info("Favorites routes registered")
-
-	routes.TransferRoutes(router)
-	logger.Info("Transfer routes registered")
-
-	routes.PromotionRoutes(router)
-	logger.Info("Promotion routes registered")
-
-	routes.FeedbackRoutes(router)
-	logger.Info("Feedback routes registered")
-
-	routes.MediaURLRoutes(router)
-	logger.Info("Media URL routes registered")
-
-	// Add static file serving for media files
-	router.PathPrefix("/files/").Handler(http.StripPrefix("/files/",
-		http.FileServer(http.Dir("/app/media/"))))
-	logger.Info("Static file serving routes registered")
+// checkRouteDocs warns about routes missing from the OpenAPI document and
+// documented routes that no longer exist
+func checkRouteDocs(router *mux.Router, logger *logrus.Entry) {
+	for _, route := range docs.Undocumented(router, routes.Operations) {
+		logger.Warn("Route missing from OpenAPI document", "route", route.String())
+	}
+	for _, op := range docs.Unrouted(router, routes.Operations) {
+		logger.Warn("OpenAPI operation has no registered route", "method", op.Method, "path", op.Path)
+	}
 }
@@ -0,0 +1,105 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/disintegration/imaging"
+)
+
+const (
+	FORMAT_JPEG = "jpeg"
+	FORMAT_WEBP = "webp"
+	FORMAT_PNG  = "png"
+
+	JPEG_QUALITY = 85
+)
+
+// Derivative is one resized and re-encoded copy of a picture
+type Derivative struct {
+	Width       int
+	Height      int
+	Format      string
+	ContentType string
+	Data        []byte
+}
+
+// ContentTypeFor returns the MIME type of an encoded derivative format
+func ContentTypeFor(format string) string {
+	switch format {
+	case FORMAT_WEBP:
+		return "image/webp"
+	case FORMAT_PNG:
+		return "image/png"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// ExtensionFor returns the file extension used for a derivative format
+func ExtensionFor(format string) string {
+	switch format {
+	case FORMAT_WEBP:
+		return "webp"
+	case FORMAT_PNG:
+		return "png"
+	default:
+		return "jpg"
+	}
+}
+
+// Encode writes img in the given derivative format
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case FORMAT_JPEG:
+		return imaging.Encode(w, img, imaging.JPEG, imaging.JPEGQuality(JPEG_QUALITY))
+	case FORMAT_WEBP:
+		// lossless only; worth it for small renditions and graphics
+		return nativewebp.Encode(w, img, nil)
+	case FORMAT_PNG:
+		return imaging.Encode(w, img, imaging.PNG)
+	}
+	return fmt.Errorf("unsupported derivative format: %s", format)
+}
+
+// Derive resizes img to each width and encodes every size in every format.
+// Widths wider than the source are skipped so pictures are never upscaled;
+// a source narrower than all of them is encoded once at its own width.
+func Derive(img image.Image, widths []int, formats []string) ([]Derivative, error) {
+	srcWidth := img.Bounds().Dx()
+
+	var targets []int
+	for _, w := range widths {
+		if w > 0 && w <= srcWidth {
+			targets = append(targets, w)
+		}
+	}
+	if len(targets) == 0 {
+		targets = []int{srcWidth}
+	}
+
+	var derivatives []Derivative
+	for _, w := range targets {
+		resized := img
+		if w != srcWidth {
+			resized = imaging.Resize(img, w, 0, imaging.Lanczos)
+		}
+		for _, format := range formats {
+			var buf bytes.Buffer
+			if err := Encode(&buf, resized, format); err != nil {
+				return nil, err
+			}
+			derivatives = append(derivatives, Derivative{
+				Width:       resized.Bounds().Dx(),
+				Height:      resized.Bounds().Dy(),
+				Format:      format,
+				ContentType: ContentTypeFor(format),
+				Data:        buf.Bytes(),
+			})
+		}
+	}
+	return derivatives, nil
+}
@@ -0,0 +1,85 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"upload-service/configs"
+
+	"github.com/gen2brain/heic"
+)
+
+const HEIC_DECODER_WASM = "wasm"
+
+// HEICDecoder turns HEIC/HEIF bytes into pixels
+type HEICDecoder interface {
+	Decode(data []byte) (image.Image, error)
+}
+
+// WASMHEICDecoder runs libheif compiled to WebAssembly, so no system
+// libraries or cgo are needed
+type WASMHEICDecoder struct{}
+
+func (WASMHEICDecoder) Decode(data []byte) (image.Image, error) {
+	return heic.Decode(bytes.NewReader(data))
+}
+
+// CommandHEICDecoder shells out to a converter invoked as
+// "<path> input.heic output.png", such as libheif's heif-convert
+type CommandHEICDecoder struct {
+	Path string
+}
+
+func (d CommandHEICDecoder) Decode(data []byte) (image.Image, error) {
+	dir, err := os.MkdirTemp("", "heic-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input.heic")
+	output := filepath.Join(dir, "output.png")
+	if err := os.WriteFile(input, data, 0600); err != nil {
+		return nil, err
+	}
+	if out, err := exec.Command(d.Path, input, output).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s failed: %v: %s", d.Path, err, out)
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+var heicDecoder HEICDecoder = WASMHEICDecoder{}
+
+// Init selects the HEIC decoder configured by HEIC_DECODER
+func Init() {
+	if decoder := configs.EnvHEICDecoder(); decoder != HEIC_DECODER_WASM {
+		SetHEICDecoder(CommandHEICDecoder{Path: decoder})
+		return
+	}
+	SetHEICDecoder(WASMHEICDecoder{})
+}
+
+// SetHEICDecoder replaces the decoder used by DecodeHEIC
+func SetHEICDecoder(d HEICDecoder) {
+	heicDecoder = d
+}
+
+// IsHEIC reports whether a detected MIME type is HEIC or HEIF
+func IsHEIC(mimeType string) bool {
+	return mimeType == "image/heic" || mimeType == "image/heif"
+}
+
+// DecodeHEIC decodes a HEIC/HEIF picture with the configured decoder
+func DecodeHEIC(data []byte) (image.Image, error) {
+	return heicDecoder.Decode(data)
+}
@@ -0,0 +1,397 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/disintegration/imaging"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Metadata that may be kept on a stored original. Everything else (EXIF
+// including GPS and serial numbers, XMP, IPTC, comments, text chunks) is
+// always removed.
+const METADATA_ICC = "icc"
+
+// ORIENTATION_NORMAL is the EXIF orientation of an upright picture
+const ORIENTATION_NORMAL = 1
+
+var ErrUnsupportedFormat = errors.New("metadata stripping is not supported for this format")
+
+// Sanitized is a picture with its orientation applied and its metadata removed
+type Sanitized struct {
+	Data []byte
+	// Orientation is the EXIF orientation that was baked into the pixels
+	Orientation int
+	// Removed names every metadata field or block that was dropped
+	Removed []string
+}
+
+// Sanitize rotates a JPEG, PNG or WebP picture upright according to its EXIF
+// orientation and strips its metadata, keeping only the kinds listed in keep
+// (see METADATA_ICC). Pictures without metadata come back byte-for-byte.
+func Sanitize(data []byte, mimeType string, keep []string) (*Sanitized, error) {
+	keepICC := false
+	for _, k := range keep {
+		if k == METADATA_ICC {
+			keepICC = true
+		}
+	}
+
+	switch mimeType {
+	case "image/jpeg":
+		return sanitizeJPEG(data, keepICC)
+	case "image/png":
+		return sanitizePNG(data, keepICC)
+	case "image/webp":
+		return sanitizeWebP(data, keepICC)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// exifFields lists the field names in a raw EXIF block and its orientation
+func exifFields(raw []byte) ([]string, int) {
+	x, err := exif.Decode(bytes.NewReader(raw))
+	if err != nil && (x == nil || exif.IsCriticalError(err)) {
+		return []string{"EXIF"}, ORIENTATION_NORMAL
+	}
+
+	var names []string
+	x.Walk(exifWalker(func(name exif.FieldName, tag *tiff.Tag) error {
+		names = append(names, string(name))
+		return nil
+	}))
+	if len(names) == 0 {
+		names = []string{"EXIF"}
+	}
+
+	orientation := ORIENTATION_NORMAL
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if o, err := tag.Int(0); err == nil && o >= 1 && o <= 8 {
+			orientation = o
+		}
+	}
+	return names, orientation
+}
+
+type exifWalker func(name exif.FieldName, tag *tiff.Tag) error
+
+func (w exifWalker) Walk(name exif.FieldName, tag *tiff.Tag) error {
+	return w(name, tag)
+}
+
+// orient applies an EXIF orientation so the picture displays upright
+func orient(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	}
+	return img
+}
+
+func decodeOriented(data []byte, orientation int) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return orient(img, orientation), nil
+}
+
+// JPEG
+
+const (
+	jpegSOI   = 0xD8
+	jpegSOS   = 0xDA
+	jpegAPP0  = 0xE0
+	jpegAPP1  = 0xE1
+	jpegAPP2  = 0xE2
+	jpegAPP13 = 0xED
+	jpegAPP14 = 0xEE
+	jpegAPP15 = 0xEF
+	jpegCOM   = 0xFE
+)
+
+func sanitizeJPEG(data []byte, keepICC bool) (*Sanitized, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != jpegSOI {
+		return nil, fmt.Errorf("not a JPEG file")
+	}
+
+	result := &Sanitized{Orientation: ORIENTATION_NORMAL}
+	var kept bytes.Buffer
+	var icc [][]byte
+
+	pos := 2
+	for {
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return nil, fmt.Errorf("malformed JPEG segment at offset %d", pos)
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			pos++ // fill byte
+			continue
+		}
+		if marker == jpegSOS {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, fmt.Errorf("malformed JPEG segment at offset %d", pos)
+		}
+		segment := data[pos:end]
+		payload := data[pos+4 : end]
+
+		switch {
+		case marker == jpegAPP1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")):
+			names, orientation := exifFields(payload)
+			result.Removed = append(result.Removed, names...)
+			result.Orientation = orientation
+		case marker == jpegAPP1 && bytes.HasPrefix(payload, []byte("http://ns.adobe.com/xap/1.0/")):
+			result.Removed = append(result.Removed, "XMP")
+		case marker == jpegAPP2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00")):
+			if keepICC {
+				icc = append(icc, segment)
+				kept.Write(segment)
+			} else {
+				result.Removed = append(result.Removed, "ICCProfile")
+			}
+		case marker == jpegAPP13:
+			result.Removed = append(result.Removed, "IPTC")
+		case marker == jpegCOM:
+			result.Removed = append(result.Removed, "Comment")
+		case marker == jpegAPP0 || marker == jpegAPP14:
+			// JFIF and Adobe headers describe how to decode the pixels
+			kept.Write(segment)
+		case marker > jpegAPP0 && marker <= jpegAPP15:
+			result.Removed = append(result.Removed, fmt.Sprintf("APP%d", marker-jpegAPP0))
+		default:
+			kept.Write(segment)
+		}
+		pos = end
+	}
+
+	if result.Orientation != ORIENTATION_NORMAL {
+		img, err := decodeOriented(data, result.Orientation)
+		if err != nil {
+			return nil, err
+		}
+		var encoded bytes.Buffer
+		if err := imaging.Encode(&encoded, img, imaging.JPEG, imaging.JPEGQuality(95)); err != nil {
+			return nil, err
+		}
+		out := bytes.NewBuffer([]byte{0xFF, jpegSOI})
+		for _, segment := range icc {
+			out.Write(segment)
+		}
+		out.Write(encoded.Bytes()[2:])
+		result.Data = out.Bytes()
+		return result, nil
+	}
+
+	if len(result.Removed) == 0 {
+		result.Data = data
+		return result, nil
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write([]byte{0xFF, jpegSOI})
+	out.Write(kept.Bytes())
+	out.Write(data[pos:])
+	result.Data = out.Bytes()
+	return result, nil
+}
+
+// PNG
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func sanitizePNG(data []byte, keepICC bool) (*Sanitized, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, fmt.Errorf("not a PNG file")
+	}
+
+	result := &Sanitized{Orientation: ORIENTATION_NORMAL}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+	var icc []byte
+
+	pos := len(pngSignature)
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, fmt.Errorf("malformed PNG chunk at offset %d", pos)
+		}
+		chunkType := string(data[pos+4 : pos+8])
+		chunk := data[pos:end]
+		payload := data[pos+8 : pos+8+length]
+
+		switch chunkType {
+		case "eXIf":
+			names, orientation := exifFields(payload)
+			result.Removed = append(result.Removed, names...)
+			result.Orientation = orientation
+		case "tEXt", "zTXt", "iTXt":
+			keyword := payload
+			if i := bytes.IndexByte(payload, 0); i >= 0 {
+				keyword = payload[:i]
+			}
+			result.Removed = append(result.Removed, "Text:"+string(keyword))
+		case "tIME":
+			result.Removed = append(result.Removed, "ModifyDate")
+		case "iCCP":
+			if keepICC {
+				icc = chunk
+				out.Write(chunk)
+			} else {
+				result.Removed = append(result.Removed, "ICCProfile")
+			}
+		default:
+			out.Write(chunk)
+		}
+		pos = end
+	}
+
+	if result.Orientation != ORIENTATION_NORMAL {
+		img, err := decodeOriented(data, result.Orientation)
+		if err != nil {
+			return nil, err
+		}
+		var encoded bytes.Buffer
+		if err := png.Encode(&encoded, img); err != nil {
+			return nil, err
+		}
+		reencoded := encoded.Bytes()
+		if icc != nil {
+			// the colour profile must come right after IHDR
+			ihdrEnd := len(pngSignature) + 12 + int(binary.BigEndian.Uint32(reencoded[8:12]))
+			withICC := append([]byte{}, reencoded[:ihdrEnd]...)
+			withICC = append(withICC, icc...)
+			reencoded = append(withICC, reencoded[ihdrEnd:]...)
+		}
+		result.Data = reencoded
+		return result, nil
+	}
+
+	if len(result.Removed) == 0 {
+		result.Data = data
+		return result, nil
+	}
+	result.Data = out.Bytes()
+	return result, nil
+}
+
+// WebP
+
+const (
+	vp8xFlagICC  = 0x20
+	vp8xFlagEXIF = 0x08
+	vp8xFlagXMP  = 0x04
+)
+
+func sanitizeWebP(data []byte, keepICC bool) (*Sanitized, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("not a WebP file")
+	}
+
+	result := &Sanitized{Orientation: ORIENTATION_NORMAL}
+	var chunks [][]byte
+	var vp8x []byte
+	keptICC, droppedICC := false, false
+
+	pos := 12
+	for pos+8 <= len(data) {
+		fourCC := string(data[pos : pos+4])
+		length := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		end := pos + 8 + length + length%2
+		if length < 0 || pos+8+length > len(data) {
+			return nil, fmt.Errorf("malformed WebP chunk at offset %d", pos)
+		}
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := data[pos:end]
+		payload := data[pos+8 : pos+8+length]
+
+		switch fourCC {
+		case "EXIF":
+			names, orientation := exifFields(payload)
+			result.Removed = append(result.Removed, names...)
+			result.Orientation = orientation
+		case "XMP ":
+			result.Removed = append(result.Removed, "XMP")
+		case "ICCP":
+			if keepICC {
+				keptICC = true
+				chunks = append(chunks, chunk)
+			} else {
+				droppedICC = true
+				result.Removed = append(result.Removed, "ICCProfile")
+			}
+		case "VP8X":
+			vp8x = append([]byte{}, chunk...)
+			chunks = append(chunks, vp8x)
+		default:
+			chunks = append(chunks, chunk)
+		}
+		pos = end
+	}
+
+	if result.Orientation != ORIENTATION_NORMAL {
+		img, err := decodeOriented(data, result.Orientation)
+		if err != nil {
+			return nil, err
+		}
+		var encoded bytes.Buffer
+		if err := nativewebp.Encode(&encoded, img, nil); err != nil {
+			return nil, err
+		}
+		// the lossless encoder writes a plain VP8L file with no room for a profile
+		if keptICC {
+			result.Removed = append(result.Removed, "ICCProfile")
+		}
+		result.Data = encoded.Bytes()
+		return result, nil
+	}
+
+	if len(result.Removed) == 0 {
+		result.Data = data
+		return result, nil
+	}
+
+	if vp8x != nil && len(vp8x) > 8 {
+		vp8x[8] &^= vp8xFlagEXIF | vp8xFlagXMP
+		if droppedICC {
+			vp8x[8] &^= vp8xFlagICC
+		}
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.WriteString("RIFF")
+	binary.Write(out, binary.LittleEndian, uint32(0))
+	out.WriteString("WEBP")
+	for _, chunk := range chunks {
+		out.Write(chunk)
+	}
+	sanitized := out.Bytes()
+	binary.LittleEndian.PutUint32(sanitized[4:8], uint32(len(sanitized)-8))
+	result.Data = sanitized
+	return result, nil
+}
@@ -0,0 +1,139 @@
+package media
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// Perceptual hashes are 64-bit fingerprints that stay close (in Hamming
+// distance) when a picture is resized, recompressed or slightly edited.
+// They are stored as 16 hex characters.
+
+// HASH_BANDS splits a hash into byte-sized bands for indexed lookups. Two
+// hashes within HASH_BANDS-1 bits of each other share at least one band.
+const HASH_BANDS = 8
+
+// PerceptualHashes holds both fingerprints of a picture
+type PerceptualHashes struct {
+	PHash string
+	DHash string
+}
+
+// NewPerceptualHashes computes the pHash and dHash of img
+func NewPerceptualHashes(img image.Image) PerceptualHashes {
+	return PerceptualHashes{
+		PHash: formatHash(pHash(img)),
+		DHash: formatHash(dHash(img)),
+	}
+}
+
+func formatHash(h uint64) string {
+	return fmt.Sprintf("%016x", h)
+}
+
+// ParseHash reads a hash produced by NewPerceptualHashes
+func ParseHash(hash string) (uint64, error) {
+	if len(hash) != 16 {
+		return 0, fmt.Errorf("perceptual hash must be 16 hex characters")
+	}
+	return strconv.ParseUint(hash, 16, 64)
+}
+
+// HammingDistance is the number of differing bits between two hashes, or -1
+// when either is malformed
+func HammingDistance(a, b string) int {
+	x, err := ParseHash(a)
+	if err != nil {
+		return -1
+	}
+	y, err := ParseHash(b)
+	if err != nil {
+		return -1
+	}
+	return bits.OnesCount64(x ^ y)
+}
+
+// HashBands returns the position-tagged bands of a hash ("0:ab", "1:cd", ...)
+// so near matches can be found through a multikey index
+func HashBands(hash string) []string {
+	if len(hash) != 2*HASH_BANDS {
+		return nil
+	}
+	bands := make([]string, HASH_BANDS)
+	for i := 0; i < HASH_BANDS; i++ {
+		bands[i] = fmt.Sprintf("%d:%s", i, hash[2*i:2*i+2])
+	}
+	return bands
+}
+
+// dHash compares each pixel of a 9x8 greyscale thumbnail with its right-hand
+// neighbour
+func dHash(img image.Image) uint64 {
+	small := imaging.Grayscale(imaging.Resize(img, 9, 8, imaging.Box))
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if small.Pix[small.PixOffset(x, y)] < small.Pix[small.PixOffset(x+1, y)] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// pHash keeps the lowest 8x8 frequencies of a 32x32 DCT and compares each
+// with their median
+func pHash(img image.Image) uint64 {
+	const size = 32
+	small := imaging.Grayscale(imaging.Resize(img, size, size, imaging.Box))
+
+	var pixels [size][size]float64
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			pixels[y][x] = float64(small.Pix[small.PixOffset(x, y)])
+		}
+	}
+
+	// separable DCT-II, only the 8 lowest frequencies are needed per axis
+	var rows [size][8]float64
+	for y := 0; y < size; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < size; x++ {
+				sum += pixels[y][x] * math.Cos(float64(2*x+1)*float64(u)*math.Pi/(2*size))
+			}
+			rows[y][u] = sum
+		}
+	}
+	var coeffs [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < size; y++ {
+				sum += rows[y][u] * math.Cos(float64(2*y+1)*float64(v)*math.Pi/(2*size))
+			}
+			coeffs[v*8+u] = sum
+		}
+	}
+
+	// the DC term only reflects overall brightness, leave it out of the median
+	sorted := append([]float64{}, coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var h uint64
+	for _, c := range coeffs {
+		h <<= 1
+		if c > median {
+			h |= 1
+		}
+	}
+	return h
+}
@@ -0,0 +1,40 @@
+package media
+
+import (
+	"image"
+
+	"github.com/buckket/go-blurhash"
+	"github.com/disintegration/imaging"
+)
+
+// BlurHash is computed on a small copy of the picture; the hash only keeps
+// a handful of frequency components, so this costs nothing in quality
+const placeholderSampleWidth = 64
+
+// Placeholder describes what a client needs to reserve space for a picture
+// and paint a blurred preview before the real bytes arrive
+type Placeholder struct {
+	BlurHash string
+	Width    int
+	Height   int
+}
+
+// NewPlaceholder computes the BlurHash and intrinsic size of img
+func NewPlaceholder(img image.Image) (*Placeholder, error) {
+	bounds := img.Bounds()
+	sample := img
+	if bounds.Dx() > placeholderSampleWidth {
+		sample = imaging.Resize(img, placeholderSampleWidth, 0, imaging.Box)
+	}
+
+	// more components along the longer side keep the blur's shape
+	xComponents, yComponents := 4, 3
+	if bounds.Dy() > bounds.Dx() {
+		xComponents, yComponents = 3, 4
+	}
+	hash, err := blurhash.Encode(xComponents, yComponents, sample)
+	if err != nil {
+		return nil, err
+	}
+	return &Placeholder{BlurHash: hash, Width: bounds.Dx(), Height: bounds.Dy()}, nil
+}
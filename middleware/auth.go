@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"upload-service/apierrors"
+	"upload-service/auth"
+	"upload-service/configs"
+
+	"github.com/gorilla/mux"
+)
+
+const API_KEY_HEADER = "X-API-Key"
+
+// Authenticate resolves the caller of each request and stores it in the
+// request context (see auth.FromContext). Users send "Authorization: Bearer
+// <jwt>", services send their key in X-API-Key, or in an api_key query
+// parameter where they can't set headers (nginx-rtmp callbacks). Bad
+// credentials are rejected with 401; requests without any pass through
+// anonymously and it's up to the handler whether that's acceptable.
+func Authenticate(verifier *auth.Verifier, keys *auth.APIKeys) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !configs.EnvAuthEnabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if header := r.Header.Get("Authorization"); header != "" {
+				token, ok := strings.CutPrefix(header, "Bearer ")
+				if !ok {
+					apierrors.Write(w, apierrors.Unauthorized("authorization must be a bearer token"))
+					return
+				}
+				if verifier == nil {
+					apierrors.Write(w, apierrors.Unauthorized("bearer tokens are not accepted"))
+					return
+				}
+				identity, err := verifier.Verify(strings.TrimSpace(token))
+				if err != nil {
+					logger := configs.LogWithRequest(r.Context(), "http", "authenticate")
+					logger.Debug("Rejected bearer token", "error", err, "path", r.URL.Path)
+					apierrors.Write(w, apierrors.Unauthorized("invalid or expired token"))
+					return
+				}
+				r = r.WithContext(auth.WithIdentity(r.Context(), identity))
+			} else if key := apiKey(r); key != "" {
+				identity, ok := keys.Identity(key)
+				if !ok {
+					apierrors.Write(w, apierrors.Unauthorized("invalid API key"))
+					return
+				}
+				r = r.WithContext(auth.WithIdentity(r.Context(), identity))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get(API_KEY_HEADER); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("api_key")
+}
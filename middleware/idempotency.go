@@ -0,0 +1,311 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"upload-service/apierrors"
+	"upload-service/configs"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	IDEMPOTENCY_KEY_HEADER = "Idempotency-Key"
+	MAX_IDEMPOTENCY_KEY    = 255
+	// a running request holds its key this long at a time, renewing it
+	// while it runs, so a crashed instance doesn't lock the key for the TTL
+	IDEMPOTENCY_LEASE = 30 * time.Second
+
+	idempotencyPending = "pending"
+	idempotencyDone    = "done"
+)
+
+// replayedHeaders are the response headers stored with a response and sent
+// again when it's replayed
+var replayedHeaders = []string{"Content-Type", "Location"}
+
+// idempotencyRecord is what's kept in Redis for a key: the fingerprint of the
+// request that first used it and, once that request finished, its response
+type idempotencyRecord struct {
+	State       string            `json:"state"`
+	Fingerprint string            `json:"fingerprint"`
+	Status      int               `json:"status,omitempty"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+}
+
+// Idempotent makes a create handler safe to retry. The first successful
+// response to a request carrying an Idempotency-Key is stored for
+// IDEMPOTENCY_TTL and replayed to repeats of that request. Reusing a key for
+// a different request is rejected with a 422, and repeats arriving while the
+// first request is still running get a 409. Keys are scoped to the caller.
+// Failures aren't stored so the client can retry them; when Redis is
+// unavailable the request runs without the guarantee. Rate limits go outside
+// Idempotent so rejected requests aren't spooled and digested first.
+func Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKey := r.Header.Get(IDEMPOTENCY_KEY_HEADER)
+		if idempotencyKey == "" {
+			next(w, r)
+			return
+		}
+		if len(idempotencyKey) > MAX_IDEMPOTENCY_KEY {
+			apierrors.Write(w, apierrors.Validation("Idempotency-Key must be at most 255 characters"))
+			return
+		}
+		logger := configs.LogWithRequest(r.Context(), "http", "idempotency")
+
+		// uploads may be large, only their parts up to maxBody are digested
+		maxBody := configs.EnvIdempotencyMaxBody()
+		maxRequest := maxBody
+		if isMultipart(r) {
+			maxRequest = configs.EnvMaxVideoSize()
+		}
+		if r.ContentLength > maxRequest {
+			apierrors.Write(w, apierrors.New(apierrors.PAYLOAD_TOO_LARGE, fmt.Sprintf("request body exceeds %d bytes", maxRequest)))
+			return
+		}
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequest)
+		}
+		body, fingerprint, err := fingerprintRequest(r, maxBody)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apierrors.Write(w, apierrors.New(apierrors.PAYLOAD_TOO_LARGE, fmt.Sprintf("request body exceeds %d bytes", maxRequest)))
+			return
+		}
+		if err != nil {
+			apierrors.Write(w, apierrors.Validation("could not read request body"))
+			return
+		}
+		defer body.Close()
+		r.Body = body
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		client := configs.GetRedisClient()
+		key := "idempotency:" + callerScope(r) + ":" + idempotencyKey
+		ttl := configs.EnvIdempotencyTTL()
+
+		pending, _ := json.Marshal(idempotencyRecord{State: idempotencyPending, Fingerprint: fingerprint})
+		claimed, err := client.SetNX(ctx, key, pending, IDEMPOTENCY_LEASE).Result()
+		if err != nil {
+			logger.Warn("Idempotency store unavailable, running request", "error", err)
+			next(w, r)
+			return
+		}
+		if !claimed {
+			replayResponse(ctx, w, client, key, fingerprint)
+			return
+		}
+
+		stopRenewing := renewLease(client, key)
+		recorder := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+		stopRenewing()
+
+		// The handler may have run for a long time, so don't reuse ctx
+		storeCtx, storeCancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer storeCancel()
+		if recorder.status < http.StatusOK || recorder.status >= http.StatusMultipleChoices {
+			if err := client.Del(storeCtx, key).Err(); err != nil {
+				logger.Warn("Failed to release idempotency key", "error", err)
+			}
+			return
+		}
+		record := idempotencyRecord{
+			State:       idempotencyDone,
+			Fingerprint: fingerprint,
+			Status:      recorder.status,
+			Header:      map[string]string{},
+			Body:        recorder.body.Bytes(),
+		}
+		for _, name := range replayedHeaders {
+			if value := w.Header().Get(name); value != "" {
+				record.Header[name] = value
+			}
+		}
+		done, _ := json.Marshal(record)
+		if err := client.Set(storeCtx, key, done, ttl).Err(); err != nil {
+			logger.Warn("Failed to store idempotent response", "error", err)
+		}
+	}
+}
+
+// renewLease keeps a running request's key from expiring until the returned
+// function is called
+func renewLease(client *redis.Client, key string) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(IDEMPOTENCY_LEASE / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				client.Expire(ctx, key, IDEMPOTENCY_LEASE)
+				cancel()
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// replayResponse answers a request whose key is already taken
+func replayResponse(ctx context.Context, w http.ResponseWriter, client *redis.Client, key, fingerprint string) {
+	data, err := client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// The first request failed and released the key in the meantime
+		apierrors.Write(w, apierrors.Conflict("the request with this Idempotency-Key failed, retry it"))
+		return
+	}
+	var record idempotencyRecord
+	if err == nil {
+		err = json.Unmarshal(data, &record)
+	}
+	if err != nil {
+		apierrors.Write(w, apierrors.Internal("failed to read idempotent response", err))
+		return
+	}
+
+	switch {
+	case record.Fingerprint != fingerprint:
+		apierrors.Write(w, apierrors.Unprocessable("Idempotency-Key was already used for a different request"))
+	case record.State == idempotencyPending:
+		apierrors.Write(w, apierrors.Conflict("a request with this Idempotency-Key is still in progress"))
+	default:
+		for name, value := range record.Header {
+			w.Header().Set(name, value)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(record.Status)
+		w.Write(record.Body)
+	}
+}
+
+// isMultipart reports whether r has a multipart body with a boundary
+func isMultipart(r *http.Request) bool {
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != ""
+}
+
+// fingerprintRequest digests the method, path and body of r. The body is
+// spooled to a temporary file so large uploads aren't held in memory; the
+// returned body reads it back and removes the file on Close. Multipart bodies
+// are digested part by part, since clients pick a new boundary on every retry;
+// parts larger than maxPart are identified by their size instead of content.
+func fingerprintRequest(r *http.Request, maxPart int64) (io.ReadCloser, string, error) {
+	spool, err := os.CreateTemp("", "idempotency-*")
+	if err != nil {
+		return nil, "", err
+	}
+	body := &spooledBody{File: spool}
+	digest := sha256.New()
+	io.WriteString(digest, r.Method+" "+r.URL.Path+"\n")
+
+	multipartBody := isMultipart(r)
+	var target io.Writer = io.MultiWriter(spool, digest)
+	if multipartBody {
+		target = spool
+	}
+	if r.Body != nil {
+		if _, err := io.Copy(target, r.Body); err != nil {
+			body.Close()
+			return nil, "", err
+		}
+		r.Body.Close()
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		body.Close()
+		return nil, "", err
+	}
+	if multipartBody {
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err := digestParts(digest, multipart.NewReader(spool, params["boundary"]), maxPart); err != nil {
+			body.Close()
+			return nil, "", err
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			body.Close()
+			return nil, "", err
+		}
+	}
+	return body, hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// digestParts adds each part's name, file name and content to digest. Parts
+// larger than maxPart add their size rather than their content.
+func digestParts(digest hash.Hash, reader *multipart.Reader, maxPart int64) error {
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		io.WriteString(digest, part.FormName()+"\x00"+part.FileName()+"\x00")
+		content := sha256.New()
+		size, err := io.Copy(content, io.LimitReader(part, maxPart+1))
+		if err != nil {
+			return err
+		}
+		if size <= maxPart {
+			digest.Write(content.Sum(nil))
+			continue
+		}
+		rest, err := io.Copy(io.Discard, part)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(digest, "size:%d", size+rest)
+	}
+}
+
+// spooledBody is a request body read back from a temporary file
+type spooledBody struct {
+	*os.File
+}
+
+func (b *spooledBody) Close() error {
+	b.File.Close()
+	return os.Remove(b.Name())
+}
+
+// recordingWriter passes a response through while keeping a copy of it
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.status = code
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
@@ -1,8 +1,11 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
+	"upload-service/apierrors"
 	"upload-service/configs"
 
 	"github.com/gorilla/mux"
@@ -23,7 +26,7 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 		duration := time.Since(start)
 		clientIP := getClientIP(r)
 
-		configs.LogHTTPRequest(r.Method, r.URL.Path, wrapped.statusCode, duration, clientIP)
+		configs.LogHTTPRequest(r.Method, r.URL.Path, wrapped.statusCode, duration, clientIP, configs.RequestID(r.Context()))
 	})
 }
 
@@ -38,13 +41,21 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Flush lets streaming handlers, like Server-Sent Events, push what they've
+// written so far
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 // getClientIP extracts the client IP address from the request
 func getClientIP(r *http.Request) string {
 	// Check X-Forwarded-For header first (for proxy/load balancer scenarios)
 	xff := r.Header.Get("X-Forwarded-For")
 	if xff != "" {
 		// X-Forwarded-For can contain multiple IPs, take the first one
-		return xff
+		return strings.TrimSpace(strings.Split(xff, ",")[0])
 	}
 
 	// Check X-Real-IP header
@@ -61,7 +72,7 @@ func getClientIP(r *http.Request) string {
 func RouteLoggingMiddleware(routeName string) mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			logger := configs.LogWithContext("http", "route-handler")
+			logger := configs.LogWithRequest(r.Context(), "http", "route-handler")
 			logger.Debug("Route handler called", "route", routeName, "method", r.Method, "path", r.URL.Path)
 
 			next.ServeHTTP(w, r)
@@ -74,11 +85,10 @@ func RecoveryMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				logger := configs.LogWithContext("http", "panic-recovery")
+				logger := configs.LogWithRequest(r.Context(), "http", "panic-recovery")
 				logger.Error("Panic recovered", "error", err, "method", r.Method, "path", r.URL.Path)
 
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte("Internal Server Error"))
+				apierrors.Write(w, apierrors.Internal("internal server error", fmt.Errorf("%v", err)))
 			}
 		}()
 
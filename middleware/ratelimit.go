@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"upload-service/apierrors"
+	"upload-service/auth"
+	"upload-service/configs"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitPolicy allows Limit requests per caller in any sliding Window.
+// Name namespaces the counters, so routes sharing a policy share a budget.
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+// slidingWindow keeps one sorted-set member per admitted request, scored by
+// its time in milliseconds. It drops members older than the window, admits
+// the request when fewer than limit remain, and returns
+// {admitted, requests in window, oldest request in window}.
+var slidingWindow = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now - window)
+local count = redis.call("ZCARD", key)
+local admitted = 0
+if count < limit then
+	redis.call("ZADD", key, now, ARGV[4])
+	count = count + 1
+	admitted = 1
+end
+redis.call("PEXPIRE", key, window)
+
+local oldest = redis.call("ZRANGE", key, 0, 0, "WITHSCORES")
+return {admitted, count, tonumber(oldest[2]) or now}
+`)
+
+// RateLimit wraps a route handler with policy. Callers are counted by user
+// ID when authenticated and by client IP otherwise; internal services aren't
+// limited. Every response carries RateLimit-* headers and rejected requests
+// get a 429 with Retry-After. When Redis is unavailable requests are let
+// through rather than failing uploads.
+func RateLimit(policy RateLimitPolicy, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, _ := auth.FromContext(r.Context())
+		if caller.IsService() {
+			next(w, r)
+			return
+		}
+		key := "ratelimit:" + policy.Name + ":" + callerScope(r)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		now := time.Now()
+		result, err := slidingWindow.Run(ctx, configs.GetRedisClient(), []string{key},
+			now.UnixMilli(), policy.Window.Milliseconds(), policy.Limit, uuid.New().String()).Int64Slice()
+		if err != nil || len(result) != 3 {
+			logger := configs.LogWithRequest(r.Context(), "http", "rate-limit")
+			logger.Warn("Rate limiter unavailable, allowing request", "error", err, "policy", policy.Name)
+			next(w, r)
+			return
+		}
+		admitted, count, oldest := result[0] == 1, int(result[1]), result[2]
+
+		reset := time.UnixMilli(oldest).Add(policy.Window).Sub(now)
+		resetSeconds := int(reset.Round(time.Second) / time.Second)
+		if resetSeconds < 1 {
+			resetSeconds = 1
+		}
+		remaining := policy.Limit - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, int(policy.Window/time.Second)))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(resetSeconds))
+
+		if !admitted {
+			w.Header().Set("Retry-After", strconv.Itoa(resetSeconds))
+			apierrors.Write(w, apierrors.New(apierrors.RATE_LIMITED,
+				fmt.Sprintf("rate limit of %d requests per %s exceeded", policy.Limit, policy.Window)))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// callerScope names who a request counts against: the authenticated user or
+// service, or the client IP for anonymous requests
+func callerScope(r *http.Request) string {
+	caller, _ := auth.FromContext(r.Context())
+	switch {
+	case caller.IsService():
+		return "service:" + caller.Service
+	case caller.IsUser():
+		return "user:" + caller.UserID
+	}
+	return "ip:" + trustedClientIP(r, configs.EnvTrustedProxies())
+}
+
+// trustedClientIP finds the client's address without believing anything the
+// client could have written itself. Proxies append the address they got the
+// request from to X-Forwarded-For, so walking it from the right the first
+// address that isn't one of our proxies is the client; whatever is left of
+// it may be forged.
+func trustedClientIP(r *http.Request, proxies []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !isTrustedProxy(remote, proxies) {
+		return remote
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		client = hop
+		if !isTrustedProxy(hop, proxies) {
+			break
+		}
+	}
+	return client
+}
+
+func isTrustedProxy(address string, proxies []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
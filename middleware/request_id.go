@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"upload-service/configs"
+
+	"github.com/google/uuid"
+)
+
+const (
+	REQUEST_ID_HEADER = "X-Request-ID"
+	MAX_REQUEST_ID    = 128
+)
+
+// RequestID tags each request with a correlation ID: the caller's
+// X-Request-ID when it's usable, a fresh UUID otherwise. The ID is stored in
+// the request context (see configs.RequestID) and echoed in the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(REQUEST_ID_HEADER)
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, requestID)
+		next.ServeHTTP(w, r.WithContext(configs.WithRequestID(r.Context(), requestID)))
+	})
+}
+
+// validRequestID accepts IDs that are safe to log and pass downstream
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > MAX_REQUEST_ID {
+		return false
+	}
+	for _, c := range requestID {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
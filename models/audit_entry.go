@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditEntry records a security- or privacy-relevant action taken by or on
+// behalf of a user
+type AuditEntry struct {
+	Id        primitive.ObjectID     `json:"_id,omitempty" bson:"_id,omitempty"`
+	Action    string                 `json:"action" bson:"action"`
+	ActorID   string                 `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	Subject   string                 `json:"subject,omitempty" bson:"subject,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty" bson:"details,omitempty"`
+	CreatedAt time.Time              `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BannedHash is a perceptual hash moderators have blocked; uploads within a
+// few bits of it are rejected
+type BannedHash struct {
+	Id        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	PHash     string             `json:"phash" bson:"phash"`
+	Bands     []string           `json:"-" bson:"bands"`
+	Reason    string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	ContentID string             `json:"content_id,omitempty" bson:"content_id,omitempty"`
+	AddedBy   string             `json:"added_by,omitempty" bson:"added_by,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
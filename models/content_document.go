@@ -0,0 +1,130 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Limits enforced on v3 content documents
+const (
+	MAX_TITLE_LENGTH       = 200
+	MAX_DESCRIPTION_LENGTH = 5000
+	MAX_POSTING_LENGTH     = 20000
+	MAX_TAGS               = 20
+	MAX_TAG_LENGTH         = 50
+	MIN_PPV_PRICE          = 0.5
+	MAX_PPV_PRICE          = 1000
+)
+
+// ContentDocument is the typed body of the v3 content API, used to create
+// and edit pictures, videos, text posts and streams. The v1/v2 routes build
+// one from their path segments.
+type ContentDocument struct {
+	UserID       string   `json:"user_id"`
+	Title        string   `json:"title"`
+	Description  string   `json:"description"`
+	Posting      string   `json:"posting,omitempty"`
+	Show         *bool    `json:"show,omitempty"` // defaults to true
+	IsPayPerView bool     `json:"is_pay_per_view"`
+	PPVPrice     float64  `json:"ppv_price"`
+	IsDeleted    bool     `json:"is_deleted"`
+	Tags         []string `json:"tags"`
+	Visibility   string   `json:"visibility,omitempty"` // defaults to "everyone"
+
+	// GALLERY EDITS
+	ItemsOrder  []string `json:"items_order,omitempty"`
+	RemoveItems []string `json:"remove_items,omitempty"`
+}
+
+// FieldError reports why one field of a request was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// IsShown reports the show flag, which is on unless explicitly turned off
+func (d *ContentDocument) IsShown() bool {
+	return d.Show == nil || *d.Show
+}
+
+// Normalize trims whitespace and drops empty tags before validation
+func (d *ContentDocument) Normalize() {
+	d.UserID = strings.TrimSpace(d.UserID)
+	d.Title = strings.TrimSpace(d.Title)
+	d.Description = strings.TrimSpace(d.Description)
+	d.Visibility = strings.TrimSpace(d.Visibility)
+	tags := make([]string, 0, len(d.Tags))
+	for _, tag := range d.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	d.Tags = tags
+}
+
+// Validate checks every field and returns one error per problem found
+func (d *ContentDocument) Validate() []FieldError {
+	var errs []FieldError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if d.UserID == "" {
+		add("user_id", "is required")
+	} else if _, err := primitive.ObjectIDFromHex(d.UserID); err != nil {
+		add("user_id", "must be a 24 character hex id")
+	}
+
+	if n := utf8.RuneCountInString(d.Title); n > MAX_TITLE_LENGTH {
+		add("title", "must be at most %d characters, got %d", MAX_TITLE_LENGTH, n)
+	}
+	if n := utf8.RuneCountInString(d.Description); n > MAX_DESCRIPTION_LENGTH {
+		add("description", "must be at most %d characters, got %d", MAX_DESCRIPTION_LENGTH, n)
+	}
+	if n := utf8.RuneCountInString(d.Posting); n > MAX_POSTING_LENGTH {
+		add("posting", "must be at most %d characters, got %d", MAX_POSTING_LENGTH, n)
+	}
+
+	switch {
+	case d.IsPayPerView && (d.PPVPrice < MIN_PPV_PRICE || d.PPVPrice > MAX_PPV_PRICE):
+		add("ppv_price", "must be between %v and %v for pay-per-view content", MIN_PPV_PRICE, MAX_PPV_PRICE)
+	case !d.IsPayPerView && d.PPVPrice != 0:
+		add("ppv_price", "must be 0 unless is_pay_per_view is true")
+	}
+
+	switch d.Visibility {
+	case "", "everyone", "followers":
+	default:
+		add("visibility", "must be one of \"everyone\", \"followers\"")
+	}
+
+	if len(d.Tags) > MAX_TAGS {
+		add("tags", "must have at most %d tags, got %d", MAX_TAGS, len(d.Tags))
+	}
+	seen := map[string]bool{}
+	for i, tag := range d.Tags {
+		field := fmt.Sprintf("tags[%d]", i)
+		if utf8.RuneCountInString(tag) > MAX_TAG_LENGTH {
+			add(field, "must be at most %d characters", MAX_TAG_LENGTH)
+		}
+		if strings.Contains(tag, ",") {
+			add(field, "must not contain commas")
+		}
+		if seen[strings.ToLower(tag)] {
+			add(field, "duplicates an earlier tag")
+		}
+		seen[strings.ToLower(tag)] = true
+	}
+
+	return errs
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MediaBlob is one stored original, addressed by the SHA-256 of its bytes.
+// Every Content pointing at Key holds a reference; the objects are removed
+// once RefCount drops to zero.
+type MediaBlob struct {
+	Id           primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Hash         string             `json:"hash" bson:"hash"`
+	Bucket       string             `json:"bucket" bson:"bucket"`
+	Key          string             `json:"key" bson:"key"`
+	ThumbnailKey string             `json:"thumbnail_key,omitempty" bson:"thumbnail_key,omitempty"`
+	MasterKey    string             `json:"master_key,omitempty" bson:"master_key,omitempty"`
+	Variants     []ImageVariant     `json:"variants,omitempty" bson:"variants,omitempty"`
+	BlurHash     string             `json:"blurhash,omitempty" bson:"blurhash,omitempty"`
+	Width        int                `json:"width,omitempty" bson:"width,omitempty"`
+	Height       int                `json:"height,omitempty" bson:"height,omitempty"`
+	PHash        string             `json:"phash,omitempty" bson:"phash,omitempty"`
+	DHash        string             `json:"dhash,omitempty" bson:"dhash,omitempty"`
+	Size         int64              `json:"size" bson:"size"`
+	MimeType     string             `json:"mime_type" bson:"mime_type"`
+	RefCount     int64              `json:"refcount" bson:"refcount"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at" bson:"updated_at"`
+}
@@ -42,4 +42,5 @@ type Notification struct {
 	Status              string             `json:"status" bson:"status"`
 	DateCreated         time.Time          `json:"datecreated,omitempty" bson:"datecreated,omitempty"`
 	UpdatedAt           time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	RequestID           string             `json:"request_id,omitempty" bson:"request_id,omitempty"` // the upload-service request that triggered it, for tracing
 }
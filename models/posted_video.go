@@ -29,11 +29,30 @@ type Content struct {
 	Show         bool               `json:"show,omitempty" bson:"show" gorm:"column:show;type:boolean;default:true"`
 	IsPayPerView bool               `json:"ispayperview,omitempty" bson:"ispayperview" gorm:"column:is_payperview;type:boolean;default:false"`
 	IsDeleted    bool               `json:"isdeleted,omitempty" bson:"isdeleted" gorm:"column:isdeleted;type:boolean;default:false"`
+	// when the content was deleted; it's purged for good some time after
+	DateDeleted  *time.Time         `json:"datedeleted,omitempty" bson:"datedeleted,omitempty" gorm:"-"`
 	PPVPrice     float64            `json:"ppvprice" bson:"ppvprice" gorm:"column:ppvprice;type:float"`
 	Tags         []string           `json:"tags" bson:"tags" gorm:"-"`
 	Visibility   string             `json:"visibility" bson:"visibility" gorm:"column:visibility;type:text"`
 	PgTags       string             `gorm:"column:tags;type:varchar[]"` // Used internally for PostgreSQL
 	Transcoding  string             `json:"transcoding,omitempty" bson:"transcoding,omitempty" gorm:"-"`
+	Variants     []ImageVariant     `json:"variants,omitempty" bson:"variants,omitempty" gorm:"-"`
+	MasterKey    string             `json:"master_key,omitempty" bson:"master_key,omitempty" gorm:"-"`
+	BlurHash     string             `json:"blurhash,omitempty" bson:"blurhash,omitempty" gorm:"-"`
+	Width        int                `json:"width,omitempty" bson:"width,omitempty" gorm:"-"`
+	Height       int                `json:"height,omitempty" bson:"height,omitempty" gorm:"-"`
+	Items        []MediaItem        `json:"items,omitempty" bson:"items,omitempty" gorm:"-"`
+	PHash        string             `json:"phash,omitempty" bson:"phash,omitempty" gorm:"-"`
+	DHash        string             `json:"dhash,omitempty" bson:"dhash,omitempty" gorm:"-"`
+	PHashBands   []string           `json:"-" bson:"phash_bands,omitempty" gorm:"-"`
+	Renditions   []Rendition        `json:"renditions,omitempty" bson:"renditions,omitempty" gorm:"-"`
+	Duration     float64            `json:"duration,omitempty" bson:"duration,omitempty" gorm:"-"`
+	MediaInfo    *MediaInfo         `json:"media_info,omitempty" bson:"media_info,omitempty" gorm:"-"`
+	// set on reposts holding their own references on the original's media
+	// blobs; older reposts hold none
+	BlobRef      bool               `json:"-" bson:"blob_ref,omitempty" gorm:"-"`
+	// why the last transcoding failed, one entry per broken file
+	TranscodingReport []TranscodingProblem `json:"transcoding_report,omitempty" bson:"transcoding_report,omitempty" gorm:"-"`
 
 	
 	// FOR LIVE STREAMING
@@ -47,6 +66,62 @@ type Content struct {
 
 }
 
+// ImageVariant is one resized rendition of a picture post, so clients can
+// pick the smallest one that fits instead of downloading the original
+type ImageVariant struct {
+	Width  int    `json:"width" bson:"width"`
+	Height int    `json:"height" bson:"height"`
+	Format string `json:"format" bson:"format"`
+	Key    string `json:"key" bson:"key"`
+	URL    string `json:"url" bson:"url"`
+}
+
+// Rendition is one quality level of a video's HLS ladder
+type Rendition struct {
+	Name        string `json:"name" bson:"name"`
+	Bandwidth   int    `json:"bandwidth" bson:"bandwidth"`
+	Resolution  string `json:"resolution,omitempty" bson:"resolution,omitempty"`
+	PlaylistKey string `json:"playlist_key" bson:"playlist_key"`
+}
+
+// MediaInfo describes a video as probed from its raw upload. Width and
+// Height are the size as played, with Rotation already applied.
+type MediaInfo struct {
+	Duration   float64 `json:"duration" bson:"duration"` // seconds
+	Width      int     `json:"width" bson:"width"`
+	Height     int     `json:"height" bson:"height"`
+	Rotation   int     `json:"rotation,omitempty" bson:"rotation,omitempty"`
+	VideoCodec string  `json:"video_codec" bson:"video_codec"`
+	AudioCodec string  `json:"audio_codec,omitempty" bson:"audio_codec,omitempty"`
+	Bitrate    int64   `json:"bitrate,omitempty" bson:"bitrate,omitempty"` // bits per second
+	FrameRate  float64 `json:"frame_rate,omitempty" bson:"frame_rate,omitempty"`
+	Container  string  `json:"container,omitempty" bson:"container,omitempty"`
+}
+
+// TranscodingProblem is one thing that kept a video's HLS ladder from being
+// published, e.g. a segment its playlist references but that wasn't uploaded
+type TranscodingProblem struct {
+	File    string `json:"file" bson:"file"`
+	Problem string `json:"problem" bson:"problem"`
+}
+
+// MediaItem is one picture of a gallery post, in display order
+type MediaItem struct {
+	ID           string         `json:"id" bson:"id"`
+	Location     string         `json:"location" bson:"location"`
+	Posting      string         `json:"posting" bson:"posting"`
+	S3RawKey     string         `json:"s3_raw_key" bson:"s3_raw_key"`
+	ThumbnailKey string         `json:"thumbnail_key,omitempty" bson:"thumbnail_key,omitempty"`
+	MasterKey    string         `json:"master_key,omitempty" bson:"master_key,omitempty"`
+	AltText      string         `json:"alt_text,omitempty" bson:"alt_text,omitempty"`
+	Variants     []ImageVariant `json:"variants,omitempty" bson:"variants,omitempty"`
+	BlurHash     string         `json:"blurhash,omitempty" bson:"blurhash,omitempty"`
+	Width        int            `json:"width,omitempty" bson:"width,omitempty"`
+	Height       int            `json:"height,omitempty" bson:"height,omitempty"`
+	PHash        string         `json:"phash,omitempty" bson:"phash,omitempty"`
+	DHash        string         `json:"dhash,omitempty" bson:"dhash,omitempty"`
+}
+
 // Before saving the content to PostgreSQL, convert the []string to a PostgreSQL array string
 func (c *Content) BeforeCreate(tx *gorm.DB) (err error) {
 	c.PgTags = fmt.Sprintf("{%s}", strings.Join(c.Tags, ","))
@@ -68,6 +143,11 @@ type ContentBody struct {
 	Description string `json:"description,omitempty"`
 	Title       string `json:"title,omitempty"`
 	Posting     string `json:"posting,omitempty"`
+
+	// GALLERY POSTS
+	AltTexts    []string `json:"alt_texts,omitempty"`    // one per uploaded file, in order
+	ItemsOrder  []string `json:"items_order,omitempty"`  // item ids in their new order
+	RemoveItems []string `json:"remove_items,omitempty"` // item ids to drop
 }
 
 type PostVideo struct {
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TranscodingJob turns one video's raw upload into HLS. Jobs are queued in
+// Mongo and claimed by whichever instance has a free worker; a running job
+// holds a lease its worker keeps extending, so jobs of a crashed instance
+// are picked up again once the lease runs out.
+type TranscodingJob struct {
+	ID              primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ContentID       primitive.ObjectID `json:"content_id" bson:"content_id"`
+	VideoID         string             `json:"video_id" bson:"video_id"`
+	UserID          string             `json:"userID" bson:"userid"`
+	RawKey          string             `json:"raw_key" bson:"raw_key"`
+	State           string             `json:"state" bson:"state"`
+	Attempts        int                `json:"attempts" bson:"attempts"`
+	MaxAttempts     int                `json:"max_attempts" bson:"max_attempts"`
+	RunAt           time.Time          `json:"run_at" bson:"run_at"`
+	Worker          string             `json:"-" bson:"worker,omitempty"`
+	LeaseUntil      time.Time          `json:"-" bson:"lease_until,omitempty"`
+	CancelRequested bool               `json:"cancel_requested,omitempty" bson:"cancel_requested,omitempty"`
+	LastError       string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	HLSURL          string             `json:"hls_url,omitempty" bson:"hls_url,omitempty"`
+	CreatedAt       time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt       time.Time          `json:"updated_at" bson:"updated_at"`
+	StartedAt       *time.Time         `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	FinishedAt      *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
+
+// TranscodingEvent is a change in a video's transcoding, published on Redis
+// and streamed to clients following it. Progress is set while the video is
+// running, Reason when an attempt failed or the job gave up.
+type TranscodingEvent struct {
+	ContentID string    `json:"content_id"`
+	State     string    `json:"state"`
+	Progress  *float64  `json:"progress,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	HLSURL    string    `json:"hls_url,omitempty"`
+	At        time.Time `json:"at"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+// Upload tracks a resumable (tus) or presigned direct-to-S3 video upload
+// until the raw object is complete and its Content record is queued
+type Upload struct {
+	ID           string            `json:"id" bson:"_id"`
+	Method       string            `json:"method" bson:"method"`
+	MultipartID  string            `json:"-" bson:"multipart_id,omitempty"`
+	RawKey       string            `json:"raw_key,omitempty" bson:"raw_key,omitempty"`
+	UserID       string            `json:"userID" bson:"userid"`
+	Length       int64             `json:"length" bson:"length"`
+	Offset       int64             `json:"offset" bson:"offset"`
+	Metadata     map[string]string `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	Parts        []UploadPart      `json:"-" bson:"parts"`
+	MimeType     string            `json:"mime_type,omitempty" bson:"mime_type,omitempty"`
+	Extension    string            `json:"extension,omitempty" bson:"extension,omitempty"`
+	Show         bool              `json:"show" bson:"show"`
+	IsPayPerView bool              `json:"ispayperview" bson:"ispayperview"`
+	IsDeleted    bool              `json:"isdeleted" bson:"isdeleted"`
+	PPVPrice     float64           `json:"ppvprice" bson:"ppvprice"`
+	Tags         []string          `json:"tags" bson:"tags"`
+	Visibility   string            `json:"visibility" bson:"visibility"`
+	Status       string            `json:"status" bson:"status"`
+	ContentID    string            `json:"content_id,omitempty" bson:"content_id,omitempty"`
+	VideoID      string            `json:"video_id,omitempty" bson:"video_id,omitempty"`
+	CreatedAt    time.Time         `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at" bson:"updated_at"`
+	ExpiresAt    time.Time         `json:"expires_at" bson:"expires_at"`
+}
+
+// UploadPart is one PATCH request's worth of bytes, stored as its own object
+type UploadPart struct {
+	Key    string `json:"key" bson:"key"`
+	Offset int64  `json:"offset" bson:"offset"`
+	Size   int64  `json:"size" bson:"size"`
+}
@@ -3,5 +3,6 @@ package responses
 type ContentResponse struct {
     Status  int                    `json:"status"`
     Message string                 `json:"message"`
+    Code    string                 `json:"code,omitempty"` // machine-readable error code, set on errors only
     Data    map[string]interface{} `json:"data"`
-}
\ No newline at end of file
+}
@@ -2,14 +2,15 @@ package routes
 
 import (
 	"upload-service/controllers"
+	"upload-service/middleware"
 
 	"github.com/gorilla/mux"
 )
 
 func CommentRoutes(router *mux.Router) {
-	router.HandleFunc("/uploadmicro/v1/addComment/{UserID}/{ReplyTo}/{Comment}/{ReplyToComment}", controllers.AddComment()).Methods("POST")                                 // implemented notifications
-	router.HandleFunc("/uploadmicro/v1/addComment/{UserID}/{ReplyTo}/{ReplyToComment}", controllers.AddCommentWithBody()).Methods("POST")                                   // implemented notifications
-	router.HandleFunc("/uploadmicro/v1/addComment/{UserID}/{ReplyTo}/{IsReply}/{OwnerUserID}/{ContentID}", controllers.AddCommentWithBodyWithOtherUserID()).Methods("POST") // implemented notifications
+	router.HandleFunc("/uploadmicro/v1/addComment/{UserID}/{ReplyTo}/{Comment}/{ReplyToComment}", middleware.RateLimit(commentsLimit, controllers.AddComment())).Methods("POST")                                                        // implemented notifications
+	router.HandleFunc("/uploadmicro/v1/addComment/{UserID}/{ReplyTo}/{ReplyToComment}", middleware.RateLimit(commentsLimit, middleware.Idempotent(controllers.AddCommentWithBody()))).Methods("POST")                                   // implemented notifications
+	router.HandleFunc("/uploadmicro/v1/addComment/{UserID}/{ReplyTo}/{IsReply}/{OwnerUserID}/{ContentID}", middleware.RateLimit(commentsLimit, middleware.Idempotent(controllers.AddCommentWithBodyWithOtherUserID()))).Methods("POST") // implemented notifications
 	router.HandleFunc("/uploadmicro/v1/editComment/{CommentID}/{Comment}", controllers.EditComment()).Methods("PUT")
 	router.HandleFunc("/uploadmicro/v1/editComment/{CommentID}", controllers.EditCommentWithBody()).Methods("POST")
 	router.HandleFunc("/uploadmicro/v1/deleteComment/{CommentID}", controllers.DeleteComment()).Methods("POST")
@@ -2,34 +2,59 @@ package routes
 
 import (
 	"upload-service/controllers"
+	"upload-service/middleware"
 
 	"github.com/gorilla/mux"
 )
 
 func ContentRoutes(router *mux.Router) {
-	router.HandleFunc("/uploadmicro/v1/postprof/{UserID}/{IsCurrent}", controllers.PostProfilePicBase64()).Methods("POST")
+	router.HandleFunc("/uploadmicro/v1/postprof/{UserID}/{IsCurrent}", middleware.RateLimit(uploadsLimit, controllers.PostProfilePicBase64())).Methods("POST")
 	router.HandleFunc("/uploadmicro/v1/makeCurrentPostProf/{UserID}/{Filename}/{ToBeChanged}", controllers.UpdateOnProfilePic()).Methods("PUT")
-	router.HandleFunc("/uploadmicro/v1/postpic/{UserID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", controllers.PostPic()).Methods("POST")
-	router.HandleFunc("/uploadmicro/v1/postpic/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", controllers.PostPicWithBody()).Methods("POST")
-	router.HandleFunc("/uploadmicro/v1/postvid/{UserID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}", controllers.PostVideo()).Methods("POST")
-	router.HandleFunc("/uploadmicro/v1/postvidnt/{UserID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", controllers.PostVideoNT()).Methods("POST")
-	router.HandleFunc("/uploadmicro/v1/postvidnt/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", controllers.PostVideoNTWithBody()).Methods("POST")
-	router.HandleFunc("/uploadmicro/v1/postText/{UserID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Posting}/{Visibility}", controllers.PostText()).Methods("POST")
-	router.HandleFunc("/uploadmicro/v1/postText/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", controllers.PostTextWithBody()).Methods("POST")
+	router.HandleFunc("/uploadmicro/v1/postpic/{UserID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", middleware.RateLimit(uploadsLimit, controllers.PostPic())).Methods("POST")
+	router.HandleFunc("/uploadmicro/v1/postpic/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", middleware.RateLimit(uploadsLimit, middleware.Idempotent(controllers.PostPicWithBody()))).Methods("POST")
+	router.HandleFunc("/uploadmicro/v1/postgallery/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", middleware.RateLimit(uploadsLimit, controllers.PostGalleryWithBody())).Methods("POST")
+	router.HandleFunc("/uploadmicro/v1/postvid/{UserID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}", middleware.RateLimit(uploadsLimit, controllers.PostVideo())).Methods("POST")
+	router.HandleFunc("/uploadmicro/v1/postvidnt/{UserID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", middleware.RateLimit(uploadsLimit, controllers.PostVideoNT())).Methods("POST")
+	router.HandleFunc("/uploadmicro/v1/postvidnt/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", middleware.RateLimit(uploadsLimit, middleware.Idempotent(controllers.PostVideoNTWithBody()))).Methods("POST")
+	router.HandleFunc("/uploadmicro/v1/postText/{UserID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Posting}/{Visibility}", middleware.RateLimit(uploadsLimit, controllers.PostText())).Methods("POST")
+	router.HandleFunc("/uploadmicro/v1/postText/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", middleware.RateLimit(uploadsLimit, middleware.Idempotent(controllers.PostTextWithBody()))).Methods("POST")
 	router.HandleFunc("/uploadmicro/v1/editcontent/{Type}/{ContentID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Posting}", controllers.EditContent()).Methods("PUT")
 	router.HandleFunc("/uploadmicro/v1/editcontent/{Type}/{ContentID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}", controllers.EditContentWithBody()).Methods("POST")
 	router.HandleFunc("/uploadmicro/v2/editcontent/{Type}/{ContentID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", controllers.EditContentWithBodyV2()).Methods("POST")
 	router.HandleFunc("/uploadmicro/v1/deletecontent/{ContentID}", controllers.DeleteContent()).Methods("DELETE")
 
-	router.HandleFunc("/uploadmicro/v1/repostRequest", controllers.RepostRequest()).Methods("POST")              // implemented notifications
+	// V3: typed, validated JSON documents instead of path segments
+	router.HandleFunc("/uploadmicro/v3/content/pic", middleware.RateLimit(uploadsLimit, middleware.Idempotent(controllers.PostPicV3()))).Methods("POST")
+	router.HandleFunc("/uploadmicro/v3/content/video", middleware.RateLimit(uploadsLimit, middleware.Idempotent(controllers.PostVideoV3()))).Methods("POST")
+	router.HandleFunc("/uploadmicro/v3/content/text", middleware.RateLimit(uploadsLimit, middleware.Idempotent(controllers.PostTextV3()))).Methods("POST")
+	router.HandleFunc("/uploadmicro/v3/content/stream", middleware.RateLimit(uploadsLimit, middleware.Idempotent(controllers.StartStreamV3()))).Methods("POST")
+	router.HandleFunc("/uploadmicro/v3/content/{ContentID}", controllers.EditContentV3()).Methods("PUT")
+	router.HandleFunc("/uploadmicro/v3/content/{ContentID}", controllers.GetContent()).Methods("GET")
+	router.HandleFunc("/uploadmicro/v3/users/{UserID}/content", controllers.GetUserContent()).Methods("GET")
+
+	// RESUMABLE VIDEO UPLOADS (tus 1.0.0)
+	router.HandleFunc("/uploadmicro/v1/tus/postvid/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", middleware.RateLimit(uploadsLimit, controllers.TusCreateUpload())).Methods("POST")
+	router.HandleFunc("/uploadmicro/v1/tus/postvid/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", controllers.TusOptions()).Methods("OPTIONS")
+	router.HandleFunc("/uploadmicro/v1/tus/uploads/{UploadID}", controllers.TusUploadOffset()).Methods("HEAD")
+	router.HandleFunc("/uploadmicro/v1/tus/uploads/{UploadID}", controllers.TusPatchUpload()).Methods("PATCH")
+	router.HandleFunc("/uploadmicro/v1/tus/uploads/{UploadID}", controllers.TusTerminateUpload()).Methods("DELETE")
+	router.HandleFunc("/uploadmicro/v1/tus/uploads/{UploadID}", controllers.TusOptions()).Methods("OPTIONS")
+
+	// PRESIGNED DIRECT-TO-S3 VIDEO UPLOADS
+	router.HandleFunc("/uploadmicro/v1/presigned/postvid/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", middleware.RateLimit(uploadsLimit, controllers.PresignedInitiateUpload())).Methods("POST")
+	router.HandleFunc("/uploadmicro/v1/presigned/complete/{UploadID}", controllers.PresignedCompleteUpload()).Methods("POST")
+
+	router.HandleFunc("/uploadmicro/v1/repostRequest", middleware.Idempotent(controllers.RepostRequest())).Methods("POST")              // implemented notifications
 	router.HandleFunc("/uploadmicro/v1/approveRequest/{requestID}", controllers.ApproveRequest()).Methods("GET") // implemented notifications
 	router.HandleFunc("/uploadmicro/v1/declineRequest/{requestID}", controllers.DeclineRequest()).Methods("GET")
 	router.HandleFunc("/uploadmicro/v1/getFollowRequestsByUserID/{userID}/{limit}/{skip}", controllers.GetRepostRequestsByUserID()).Methods("GET")
 	router.HandleFunc("/uploadmicro/v1/getMyFollowRequests/{userID}/{limit}/{skip}", controllers.GetMyRepostRequests()).Methods("GET")
+	router.HandleFunc("/uploadmicro/v1/repostRequests/received/{userID}", controllers.GetReceivedRepostRequests()).Methods("GET")
+	router.HandleFunc("/uploadmicro/v1/repostRequests/sent/{userID}", controllers.GetSentRepostRequests()).Methods("GET")
 
 	// STREAM
-	router.HandleFunc("/uploadmicro/v1/startstream/{UserID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", controllers.StartStream()).Methods("POST")
-	router.HandleFunc("/uploadmicro/v1/startstream/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", controllers.StartStreamWithBody()).Methods("POST")
+	router.HandleFunc("/uploadmicro/v1/startstream/{UserID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", middleware.RateLimit(uploadsLimit, controllers.StartStream())).Methods("POST")
+	router.HandleFunc("/uploadmicro/v1/startstream/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", middleware.RateLimit(uploadsLimit, middleware.Idempotent(controllers.StartStreamWithBody()))).Methods("POST")
 
 	// NOTIFY WHEN STREAM STARTS | ENDS callback from nginx
 	router.HandleFunc("/uploadmicro/v1/streamstarted", controllers.HandleStreamPublish()).Methods("POST")
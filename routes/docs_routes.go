@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"upload-service/docs"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	API_TITLE   = "API-UploadV2"
+	API_VERSION = "1.0.0"
+)
+
+// DocsRoutes serves the OpenAPI document built from the routes registered on
+// router and the Operations table, plus a browsable docs page
+func DocsRoutes(router *mux.Router) {
+	router.HandleFunc("/openapi.json", docs.SpecHandler(router, API_TITLE, API_VERSION, Operations)).Methods("GET")
+	router.HandleFunc("/docs", docs.UIHandler("/openapi.json")).Methods("GET")
+}
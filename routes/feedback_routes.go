@@ -2,11 +2,12 @@ package routes
 
 import (
 	"upload-service/controllers"
+	"upload-service/middleware"
 
 	"github.com/gorilla/mux"
 )
 
 func FeedbackRoutes(router *mux.Router) {
-	router.HandleFunc("/uploadmicro/v1/feedback", controllers.SubmitFeedback()).Methods("POST")
+	router.HandleFunc("/uploadmicro/v1/feedback", middleware.RateLimit(feedbackLimit, middleware.Idempotent(controllers.SubmitFeedback()))).Methods("POST")
 	router.HandleFunc("/uploadmicro/v1/feedback", controllers.GetFeedback()).Methods("GET")
 }
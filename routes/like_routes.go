@@ -2,10 +2,11 @@ package routes
 
 import (
 	"upload-service/controllers"
+	"upload-service/middleware"
 
 	"github.com/gorilla/mux"
 )
 
 func LikesRoutes(router *mux.Router) {
-	router.HandleFunc("/uploadmicro/v1/like/{UserID}/{LikedContent}", controllers.Like()).Methods("POST") //notifications implemented
+	router.HandleFunc("/uploadmicro/v1/like/{UserID}/{LikedContent}", middleware.RateLimit(likesLimit, controllers.Like())).Methods("POST") //notifications implemented
 }
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"upload-service/controllers"
+
+	"github.com/gorilla/mux"
+)
+
+func ModerationRoutes(router *mux.Router) {
+	router.HandleFunc("/uploadmicro/v1/moderation/bannedhashes", controllers.AddBannedHash()).Methods("POST")
+	router.HandleFunc("/uploadmicro/v1/moderation/bannedhashes/{limit}/{skip}", controllers.GetBannedHashes()).Methods("GET")
+	router.HandleFunc("/uploadmicro/v1/moderation/bannedhashes/{HashID}", controllers.RemoveBannedHash()).Methods("DELETE")
+}
@@ -0,0 +1,134 @@
+package routes
+
+import (
+	"net/http"
+	"upload-service/controllers"
+	"upload-service/docs"
+	"upload-service/middleware"
+	"upload-service/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// idempotent marks create routes that honour an Idempotency-Key
+var idempotent = []string{middleware.IDEMPOTENCY_KEY_HEADER}
+
+// Operations documents every registered route for the OpenAPI document.
+// Add an entry whenever a route is added; routes missing here are logged at
+// startup (see docs.Undocumented).
+var Operations = []docs.Operation{
+	// CONTENT
+	{Method: "POST", Path: "/uploadmicro/v1/postprof/{UserID}/{IsCurrent}", Tag: "profile", Summary: "Upload a profile picture", Files: []string{"file"}},
+	{Method: "PUT", Path: "/uploadmicro/v1/makeCurrentPostProf/{UserID}/{Filename}/{ToBeChanged}", Tag: "profile", Summary: "Make a profile picture the current one", Response: ""},
+	{Method: "POST", Path: "/uploadmicro/v1/postpic/{UserID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", Tag: "content", Summary: "Post a picture (legacy, metadata in path)", Files: []string{"file"}},
+	{Method: "POST", Path: "/uploadmicro/v1/postpic/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", Tag: "content", Summary: "Post a picture (legacy)", Files: []string{"file"}, Body: models.ContentBody{}, Header: idempotent},
+	{Method: "POST", Path: "/uploadmicro/v1/postgallery/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", Tag: "content", Summary: "Post a gallery of pictures", Files: []string{"files"}, Body: models.ContentBody{}},
+	{Method: "POST", Path: "/uploadmicro/v1/postvid/{UserID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}", Tag: "content", Summary: "Post a video (legacy, no visibility)", Files: []string{"video"}},
+	{Method: "POST", Path: "/uploadmicro/v1/postvidnt/{UserID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", Tag: "content", Summary: "Post a video (legacy, metadata in path)", Files: []string{"video"}},
+	{Method: "POST", Path: "/uploadmicro/v1/postvidnt/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", Tag: "content", Summary: "Post a video (legacy)", Files: []string{"video"}, Body: models.ContentBody{}, Header: idempotent},
+	{Method: "POST", Path: "/uploadmicro/v1/postText/{UserID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Posting}/{Visibility}", Tag: "content", Summary: "Post text (legacy, metadata in path)", Response: primitive.ObjectID{}},
+	{Method: "POST", Path: "/uploadmicro/v1/postText/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", Tag: "content", Summary: "Post text (legacy)", Body: models.ContentBody{}, Response: primitive.ObjectID{}, Header: idempotent},
+	{Method: "PUT", Path: "/uploadmicro/v1/editcontent/{Type}/{ContentID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Posting}", Tag: "content", Summary: "Edit content (legacy, metadata in path)", Response: ""},
+	{Method: "POST", Path: "/uploadmicro/v1/editcontent/{Type}/{ContentID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}", Tag: "content", Summary: "Edit content (legacy)", Body: models.ContentBody{}, Response: ""},
+	{Method: "POST", Path: "/uploadmicro/v2/editcontent/{Type}/{ContentID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", Tag: "content", Summary: "Edit content including visibility (legacy)", Body: models.ContentBody{}, Response: ""},
+	{Method: "DELETE", Path: "/uploadmicro/v1/deletecontent/{ContentID}", Tag: "content", Summary: "Delete content; its stored media is purged once CONTENT_PURGE_AFTER has passed", Response: ""},
+
+	// CONTENT V3
+	{Method: "POST", Path: "/uploadmicro/v3/content/pic", Tag: "content v3", Summary: "Post a picture", Files: []string{"file"}, Body: models.ContentDocument{}, Header: idempotent},
+	{Method: "POST", Path: "/uploadmicro/v3/content/video", Tag: "content v3", Summary: "Post a video", Files: []string{"video"}, Body: models.ContentDocument{}, Header: idempotent},
+	{Method: "POST", Path: "/uploadmicro/v3/content/text", Tag: "content v3", Summary: "Post text", Body: models.ContentDocument{}, Response: primitive.ObjectID{}, Header: idempotent},
+	{Method: "POST", Path: "/uploadmicro/v3/content/stream", Tag: "content v3", Summary: "Create a live stream", Body: models.ContentDocument{}, Header: idempotent},
+	{Method: "GET", Path: "/uploadmicro/v3/content/{ContentID}", Tag: "content v3", Summary: "Get one content item", Status: http.StatusOK, Response: models.Content{}},
+	{Method: "GET", Path: "/uploadmicro/v3/users/{UserID}/content", Tag: "content v3", Summary: "List a user's posts and reposts, newest first", Query: []string{"type", "visibility", "show", "isdeleted"}, Status: http.StatusOK, Response: []models.Content{}, Paged: true},
+	{Method: "PUT", Path: "/uploadmicro/v3/content/{ContentID}", Tag: "content v3", Summary: "Edit content", Body: models.ContentDocument{}, Response: ""},
+
+	// RESUMABLE VIDEO UPLOADS
+	{Method: "POST", Path: "/uploadmicro/v1/tus/postvid/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", Tag: "tus", Summary: "Create a tus upload (Upload-Length and Upload-Metadata headers)", Raw: true},
+	{Method: "OPTIONS", Path: "/uploadmicro/v1/tus/postvid/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", Tag: "tus", Summary: "Advertise tus capabilities", Status: http.StatusNoContent, Raw: true},
+	{Method: "HEAD", Path: "/uploadmicro/v1/tus/uploads/{UploadID}", Tag: "tus", Summary: "Get the current Upload-Offset", Status: http.StatusOK, Raw: true},
+	{Method: "PATCH", Path: "/uploadmicro/v1/tus/uploads/{UploadID}", Tag: "tus", Summary: "Append a chunk at Upload-Offset", Status: http.StatusNoContent, Raw: true},
+	{Method: "DELETE", Path: "/uploadmicro/v1/tus/uploads/{UploadID}", Tag: "tus", Summary: "Terminate an upload", Status: http.StatusNoContent, Raw: true},
+	{Method: "OPTIONS", Path: "/uploadmicro/v1/tus/uploads/{UploadID}", Tag: "tus", Summary: "Advertise tus capabilities", Status: http.StatusNoContent, Raw: true},
+
+	// PRESIGNED UPLOADS
+	{Method: "POST", Path: "/uploadmicro/v1/presigned/postvid/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", Tag: "presigned", Summary: "Start a presigned direct-to-storage video upload", Body: controllers.PresignedInitiateRequest{}},
+	{Method: "POST", Path: "/uploadmicro/v1/presigned/complete/{UploadID}", Tag: "presigned", Summary: "Complete a presigned upload", Body: controllers.PresignedCompleteRequest{}, Response: map[string]string{}},
+
+	// REPOSTS
+	{Method: "POST", Path: "/uploadmicro/v1/repostRequest", Tag: "reposts", Summary: "Request (or withdraw a request) to repost content", Body: models.RepostRequest{}, Header: idempotent},
+	{Method: "GET", Path: "/uploadmicro/v1/approveRequest/{requestID}", Tag: "reposts", Summary: "Approve a repost request"},
+	{Method: "GET", Path: "/uploadmicro/v1/declineRequest/{requestID}", Tag: "reposts", Summary: "Decline a repost request"},
+	{Method: "GET", Path: "/uploadmicro/v1/getFollowRequestsByUserID/{userID}/{limit}/{skip}", Tag: "reposts", Summary: "List pending repost requests sent to a user (legacy, skip-based)", Response: []models.RepostRequest{}},
+	{Method: "GET", Path: "/uploadmicro/v1/getMyFollowRequests/{userID}/{limit}/{skip}", Tag: "reposts", Summary: "List pending repost requests a user sent (legacy, skip-based)", Response: []models.RepostRequest{}},
+	{Method: "GET", Path: "/uploadmicro/v1/repostRequests/received/{userID}", Tag: "reposts", Summary: "List repost requests sent to a user, newest first", Query: []string{"status"}, Status: http.StatusOK, Response: []models.RepostRequest{}, Paged: true, Counted: true},
+	{Method: "GET", Path: "/uploadmicro/v1/repostRequests/sent/{userID}", Tag: "reposts", Summary: "List repost requests a user sent, newest first", Query: []string{"status"}, Status: http.StatusOK, Response: []models.RepostRequest{}, Paged: true, Counted: true},
+
+	// STREAMS
+	{Method: "POST", Path: "/uploadmicro/v1/startstream/{UserID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", Tag: "streams", Summary: "Create a live stream (legacy, metadata in path)"},
+	{Method: "POST", Path: "/uploadmicro/v1/startstream/{UserID}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}/{Visibility}", Tag: "streams", Summary: "Create a live stream (legacy)", Body: models.ContentBody{}, Header: idempotent},
+	{Method: "POST", Path: "/uploadmicro/v1/streamstarted", Tag: "streams", Summary: "nginx-rtmp on_publish callback", Fields: []string{"name"}, Status: http.StatusOK, Raw: true},
+	{Method: "POST", Path: "/uploadmicro/v1/streamended", Tag: "streams", Summary: "nginx-rtmp on_publish_done callback", Fields: []string{"name"}, Status: http.StatusOK, Raw: true},
+	{Method: "GET", Path: "/uploadmicro/v1/streamlookup", Tag: "streams", Summary: "Resolve a stream key to its owner", Query: []string{"stream_key"}, Status: http.StatusOK, Raw: true},
+	{Method: "POST", Path: "/uploadmicro/v1/stream/join/{MediaID}/{ViewerID}", Tag: "streams", Summary: "Join a stream as a viewer"},
+	{Method: "POST", Path: "/uploadmicro/v1/stream/heartbeat/{MediaID}/{ViewerID}", Tag: "streams", Summary: "Keep a viewer counted", Status: http.StatusOK, Raw: true},
+	{Method: "POST", Path: "/uploadmicro/v1/stream/leave/{MediaID}/{ViewerID}", Tag: "streams", Summary: "Leave a stream"},
+
+	// MAINTENANCE
+	{Method: "POST", Path: "/uploadmicro/v1/setInitialVisibility", Tag: "maintenance", Summary: "Backfill visibility on all content", Response: ""},
+	{Method: "GET", Path: "/uploadmicro/v1/setTranscodingStatus", Tag: "maintenance", Summary: "Mark every video as transcoded"},
+	{Method: "POST", Path: "/uploadmicro/v1/upload-files", Tag: "maintenance", Summary: "Attach transcoded HLS files to a video; a ladder with missing playlists or segments fails it (422)", Files: []string{"files"}, Fields: []string{"user_id", "video_id"}, Status: http.StatusOK},
+	{Method: "POST", Path: "/transfer", Tag: "maintenance", Summary: "Move base64 profile pictures to storage", Response: ""},
+
+	// COMMENTS
+	{Method: "POST", Path: "/uploadmicro/v1/addComment/{UserID}/{ReplyTo}/{Comment}/{ReplyToComment}", Tag: "comments", Summary: "Comment on content or reply to a comment (legacy, text in path)", Response: primitive.ObjectID{}},
+	{Method: "POST", Path: "/uploadmicro/v1/addComment/{UserID}/{ReplyTo}/{ReplyToComment}", Tag: "comments", Summary: "Comment on content or reply to a comment", Body: models.CommentBody{}, Response: primitive.ObjectID{}, Header: idempotent},
+	{Method: "POST", Path: "/uploadmicro/v1/addComment/{UserID}/{ReplyTo}/{IsReply}/{OwnerUserID}/{ContentID}", Tag: "comments", Summary: "Comment, notifying the content owner", Body: models.CommentBody{}, Response: primitive.ObjectID{}, Header: idempotent},
+	{Method: "PUT", Path: "/uploadmicro/v1/editComment/{CommentID}/{Comment}", Tag: "comments", Summary: "Edit a comment (legacy, text in path)", Response: ""},
+	{Method: "POST", Path: "/uploadmicro/v1/editComment/{CommentID}", Tag: "comments", Summary: "Edit a comment", Body: models.CommentBody{}, Response: ""},
+	{Method: "POST", Path: "/uploadmicro/v1/deleteComment/{CommentID}", Tag: "comments", Summary: "Delete a comment", Response: ""},
+	{Method: "GET", Path: "/uploadmicro/v1/backfillComments", Tag: "maintenance", Summary: "Backfill content ids on comments", Status: http.StatusOK, Raw: true},
+
+	// LIKES
+	{Method: "POST", Path: "/uploadmicro/v1/like/{UserID}/{LikedContent}", Tag: "likes", Summary: "Like content, or unlike it when already liked"},
+
+	// FAVORITES
+	{Method: "POST", Path: "/uploadmicro/v1/addContentToFavorites/{UserID}/{ContentID}", Tag: "favorites", Summary: "Add content to the default favorites album", Response: ""},
+	{Method: "DELETE", Path: "/uploadmicro/v1/removeContentFromFavorites/{UserID}/{ContentID}/{AlbumID}", Tag: "favorites", Summary: "Remove content from an album", Response: ""},
+	{Method: "POST", Path: "/uploadmicro/v1/createNewAlbum/{UserID}", Tag: "favorites", Summary: "Create a favorites album", Body: controllers.CreateAlbumRequest{}},
+	{Method: "DELETE", Path: "/uploadmicro/v1/removeAlbum/{UserID}/{AlbumID}", Tag: "favorites", Summary: "Delete an album and its favorites", Status: http.StatusOK},
+	{Method: "POST", Path: "/uploadmicro/v1/moveFavorite/{UserID}/{ContentID}/{FromAlbumID}/{ToAlbumID}", Tag: "favorites", Summary: "Move a favorite between albums", Status: http.StatusOK},
+	{Method: "GET", Path: "/uploadmicro/v1/getUserFavorites/{UserID}", Tag: "favorites", Summary: "List a user's favorites grouped by album", Status: http.StatusOK},
+	{Method: "GET", Path: "/uploadmicro/v1/getUserAlbums/{UserID}", Tag: "favorites", Summary: "List a user's albums", Status: http.StatusOK},
+
+	// PROMOTIONS
+	{Method: "POST", Path: "/post-promoting/{UserID}/{Title}/{Description}/{Show}/{IsPayPerView}/{PPVPrice}/{IsDeleted}/{Tags}", Tag: "promotions", Summary: "Post a video to the promotions database", Files: []string{"video"}},
+	{Method: "POST", Path: "/promote/v1/{ContentID}/{Start}/{End}/{TargetAge}/{TargetGender}/{Amount}", Tag: "promotions", Summary: "Promote content", Response: ""},
+	{Method: "POST", Path: "/register", Tag: "promotions", Summary: "Register a promotions user", Body: models.User{}, Response: ""},
+
+	// FEEDBACK
+	{Method: "POST", Path: "/uploadmicro/v1/feedback", Tag: "feedback", Summary: "Submit feedback", Body: models.FeedbackRequest{}, Response: primitive.ObjectID{}, Header: idempotent},
+	{Method: "GET", Path: "/uploadmicro/v1/feedback", Tag: "feedback", Summary: "List feedback", Status: http.StatusOK, Response: []models.Feedback{}, Raw: true},
+
+	// MEDIA URLS
+	{Method: "GET", Path: "/media/path-to-url", Tag: "media", Summary: "Convert a file path to a URL", Query: []string{"path"}, Status: http.StatusOK},
+	{Method: "GET", Path: "/media/url-to-path", Tag: "media", Summary: "Convert a URL to a file path", Query: []string{"url"}, Status: http.StatusOK},
+	{Method: "GET", Path: "/media/{userID}/{fileType}/{filename}/info", Tag: "media", Summary: "Describe a stored media file", Status: http.StatusOK},
+	{Method: "GET", Path: "/files/", Tag: "media", Summary: "Serve public media files: the pictures and processed buckets of the local storage backend", Status: http.StatusOK, Raw: true},
+
+	// TRANSCODING
+	{Method: "GET", Path: "/uploadmicro/v1/content/{ContentID}/transcoding", Tag: "transcoding", Summary: "Get the transcoding job of a video", Status: http.StatusOK, Response: models.TranscodingJob{}},
+	{Method: "GET", Path: "/uploadmicro/v1/content/{ContentID}/transcoding/events", Tag: "transcoding", Summary: "Follow a video's transcoding as Server-Sent Events (text/event-stream of TranscodingEvent)", Status: http.StatusOK, Raw: true},
+	{Method: "POST", Path: "/uploadmicro/v1/content/{ContentID}/transcoding/cancel", Tag: "transcoding", Summary: "Cancel a queued or running transcoding job", Status: http.StatusOK, Response: models.TranscodingJob{}},
+	{Method: "POST", Path: "/uploadmicro/v1/transcoding/callback", Tag: "transcoding", Summary: "Signed completion callback from an external transcoder", Body: controllers.TranscoderCallback{}, Header: []string{controllers.TRANSCODER_TIMESTAMP_HEADER, controllers.TRANSCODER_SIGNATURE_HEADER}, Status: http.StatusOK, Response: models.Content{}},
+
+	// MODERATION
+	{Method: "POST", Path: "/uploadmicro/v1/moderation/bannedhashes", Tag: "moderation", Summary: "Ban a perceptual hash, given directly or taken from a post", Body: controllers.BannedHashRequest{}},
+	{Method: "GET", Path: "/uploadmicro/v1/moderation/bannedhashes/{limit}/{skip}", Tag: "moderation", Summary: "List banned hashes", Response: []models.BannedHash{}},
+	{Method: "DELETE", Path: "/uploadmicro/v1/moderation/bannedhashes/{HashID}", Tag: "moderation", Summary: "Lift a ban", Response: ""},
+
+	// SERVICE
+	{Method: "GET", Path: "/healthz", Tag: "service", Summary: "Liveness probe", Status: http.StatusOK, Raw: true},
+	{Method: "GET", Path: "/ready", Tag: "service", Summary: "Readiness probe", Status: http.StatusOK, Raw: true},
+	{Method: "GET", Path: "/openapi.json", Tag: "service", Summary: "This OpenAPI document", Status: http.StatusOK, Raw: true},
+	{Method: "GET", Path: "/docs", Tag: "service", Summary: "Browsable API docs", Status: http.StatusOK, Raw: true},
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"time"
+	"upload-service/middleware"
+)
+
+// Per-route rate limits. Routes sharing a policy share a caller's budget.
+var (
+	likesLimit    = middleware.RateLimitPolicy{Name: "likes", Limit: 60, Window: time.Minute}
+	commentsLimit = middleware.RateLimitPolicy{Name: "comments", Limit: 20, Window: time.Minute}
+	feedbackLimit = middleware.RateLimitPolicy{Name: "feedback", Limit: 5, Window: time.Hour}
+	uploadsLimit  = middleware.RateLimitPolicy{Name: "uploads", Limit: 60, Window: time.Hour}
+)
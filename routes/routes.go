@@ -0,0 +1,73 @@
+package routes
+
+import (
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"upload-service/configs"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// Register adds every route the service serves to router. The docs come
+// last since the OpenAPI document describes the routes registered before.
+func Register(router *mux.Router, logger *logrus.Entry) {
+	// Register all route groups with logging
+	ContentRoutes(router)
+	logger.Info("Content routes registered")
+
+	CommentRoutes(router)
+	logger.Info("Comment routes registered")
+
+	LikesRoutes(router)
+	logger.Info("Likes routes registered")
+
+	FavoritesRoutes(router)
+	logger.Info("Favorites routes registered")
+
+	TransferRoutes(router)
+	logger.Info("Transfer routes registered")
+
+	PromotionRoutes(router)
+	logger.Info("Promotion routes registered")
+
+	FeedbackRoutes(router)
+	logger.Info("Feedback routes registered")
+
+	MediaURLRoutes(router)
+	logger.Info("Media URL routes registered")
+
+	ModerationRoutes(router)
+	logger.Info("Moderation routes registered")
+
+	TranscodingRoutes(router)
+	logger.Info("Transcoding routes registered")
+
+	HealthRoutes(router)
+	logger.Info("Health check routes registered")
+
+	// Add static file serving for media files (also backs the local storage backend)
+	router.PathPrefix("/files/").Handler(http.StripPrefix("/files/",
+		publicFiles(configs.EnvMediaDir(), configs.EnvPicturesBucket(), configs.EnvProcessedBucket())))
+	logger.Info("Static file serving routes registered")
+
+	DocsRoutes(router)
+	logger.Info("API docs routes registered")
+}
+
+// publicFiles serves the given buckets of the local storage backend from
+// root. The raw and archive buckets live under the same root and are never
+// served.
+func publicFiles(root string, buckets ...string) http.Handler {
+	files := http.FileServer(http.Dir(root))
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		bucket, _, _ := strings.Cut(strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/"), "/")
+		if !slices.Contains(buckets, bucket) {
+			http.NotFound(rw, r)
+			return
+		}
+		files.ServeHTTP(rw, r)
+	})
+}
@@ -0,0 +1,66 @@
+package routes
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"upload-service/configs"
+	"upload-service/docs"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// TestOperationsMatchRoutes keeps the OpenAPI document in step with the
+// router: every registered route is documented and every documented
+// operation is served.
+func TestOperationsMatchRoutes(t *testing.T) {
+	configs.Logger = logrus.New()
+	configs.Logger.SetOutput(io.Discard)
+
+	router := mux.NewRouter()
+	Register(router, logrus.NewEntry(configs.Logger))
+
+	for _, route := range docs.Undocumented(router, Operations) {
+		t.Errorf("route %s is missing from Operations", route)
+	}
+	for _, op := range docs.Unrouted(router, Operations) {
+		t.Errorf("operation %s %s has no registered route", op.Method, op.Path)
+	}
+}
+
+// TestPublicFiles checks the static handler never serves the raw or archive
+// buckets that share the local storage root
+func TestPublicFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, key := range []string{"pictures/user/a.jpeg", "raw/user/b.mp4"} {
+		file := filepath.Join(root, filepath.FromSlash(key))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(key), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := http.StripPrefix("/files/", publicFiles(root, "pictures", "processed"))
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/files/pictures/user/a.jpeg", http.StatusOK},
+		{"/files/raw/user/b.mp4", http.StatusNotFound},
+		{"/files/pictures/../raw/user/b.mp4", http.StatusNotFound},
+		{"/files/", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"upload-service/controllers"
+
+	"github.com/gorilla/mux"
+)
+
+func TranscodingRoutes(router *mux.Router) {
+	router.HandleFunc("/uploadmicro/v1/content/{ContentID}/transcoding", controllers.GetTranscodingJob()).Methods("GET")
+	router.HandleFunc("/uploadmicro/v1/content/{ContentID}/transcoding/events", controllers.StreamTranscodingEvents()).Methods("GET")
+	router.HandleFunc("/uploadmicro/v1/content/{ContentID}/transcoding/cancel", controllers.CancelTranscodingJob()).Methods("POST")
+	router.HandleFunc("/uploadmicro/v1/transcoding/callback", controllers.TranscoderCallbackHandler()).Methods("POST")
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage keeps objects on disk under <root>/<bucket>/<key>. The root is
+// MEDIADIR, whose public buckets (pictures and processed) are served through
+// the /files/ static handler.
+type LocalStorage struct {
+	root    string
+	baseURL string
+}
+
+func NewLocalStorage(root, baseURL string) *LocalStorage {
+	return &LocalStorage{root: root, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// path resolves bucket/key to a file below root, refusing keys that escape it
+func (s *LocalStorage) path(bucket, key string) (string, error) {
+	root := filepath.Clean(s.root)
+	full := filepath.Join(root, bucket, filepath.FromSlash(key))
+	if !strings.HasPrefix(full, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return full, nil
+}
+
+func (s *LocalStorage) Put(ctx context.Context, bucket, key string, body io.Reader, contentType string) error {
+	full, err := s.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	// write to a temp file first so readers never see a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(full), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), full)
+}
+
+func (s *LocalStorage) Get(ctx context.Context, bucket, key string) (io.ReadCloser, *ObjectInfo, error) {
+	full, err := s.path(bucket, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(full)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, fileInfo(key, stat), nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, bucket, key string) error {
+	full, err := s.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(full); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStorage) Head(ctx context.Context, bucket, key string) (*ObjectInfo, error) {
+	full, err := s.path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(full)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return fileInfo(key, stat), nil
+}
+
+func (s *LocalStorage) List(ctx context.Context, bucket, prefix string) ([]ObjectInfo, error) {
+	bucketDir := filepath.Join(filepath.Clean(s.root), bucket)
+	var objects []ObjectInfo
+	err := filepath.WalkDir(bucketDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(bucketDir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, *fileInfo(key, stat))
+		return nil
+	})
+	return objects, err
+}
+
+func (s *LocalStorage) PublicURL(bucket, key string) string {
+	return s.baseURL + "/files/" + bucket + "/" + key
+}
+
+func fileInfo(key string, stat os.FileInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(key)),
+		LastModified: stat.ModTime(),
+	}
+}
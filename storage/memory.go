@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps objects in a map. It is meant for tests and CI runs
+// where neither AWS nor a writable media directory is available.
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{objects: make(map[string]memoryObject)}
+}
+
+func memoryKey(bucket, key string) string {
+	return bucket + "/" + key
+}
+
+func (s *MemoryStorage) Put(ctx context.Context, bucket, key string, body io.Reader, contentType string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[memoryKey(bucket, key)] = memoryObject{data: data, contentType: contentType, modified: time.Now()}
+	return nil
+}
+
+func (s *MemoryStorage) Get(ctx context.Context, bucket, key string) (io.ReadCloser, *ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[memoryKey(bucket, key)]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), obj.info(key), nil
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, memoryKey(bucket, key))
+	return nil
+}
+
+func (s *MemoryStorage) Head(ctx context.Context, bucket, key string) (*ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[memoryKey(bucket, key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return obj.info(key), nil
+}
+
+func (s *MemoryStorage) List(ctx context.Context, bucket, prefix string) ([]ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var objects []ObjectInfo
+	for k, obj := range s.objects {
+		key, ok := strings.CutPrefix(k, bucket+"/")
+		if !ok || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		objects = append(objects, *obj.info(key))
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *MemoryStorage) PublicURL(bucket, key string) string {
+	return "memory://" + bucket + "/" + key
+}
+
+func (o memoryObject) info(key string) *ObjectInfo {
+	return &ObjectInfo{
+		Key:          key,
+		Size:         int64(len(o.data)),
+		ContentType:  o.contentType,
+		LastModified: o.modified,
+	}
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"upload-service/configs"
+	"upload-service/utils"
+)
+
+const (
+	BackendS3     = "s3"
+	BackendLocal  = "local"
+	BackendMemory = "memory"
+)
+
+// ErrNotFound is returned by Get and Head when the object does not exist
+var ErrNotFound = errors.New("storage: object not found")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type,omitempty"`
+	LastModified time.Time `json:"last_modified,omitempty"`
+}
+
+// Storage is implemented by every media backend. Buckets map to S3 buckets
+// for the S3 backend and to top-level folders for the local one.
+type Storage interface {
+	Put(ctx context.Context, bucket, key string, body io.Reader, contentType string) error
+	Get(ctx context.Context, bucket, key string) (io.ReadCloser, *ObjectInfo, error)
+	Delete(ctx context.Context, bucket, key string) error
+	Head(ctx context.Context, bucket, key string) (*ObjectInfo, error)
+	List(ctx context.Context, bucket, prefix string) ([]ObjectInfo, error)
+	PublicURL(bucket, key string) string
+}
+
+var backend Storage
+
+// Init selects the backend configured through STORAGE_BACKEND.
+// The S3 backend expects configs.ConnectAWS to have been called.
+func Init() error {
+	switch name := configs.EnvStorageBackend(); name {
+	case BackendS3:
+		if configs.GetS3Client() == nil {
+			return fmt.Errorf("s3 storage selected but AWS is not connected")
+		}
+		backend = NewS3Storage(configs.GetS3Client(), configs.GetS3Uploader())
+	case BackendLocal:
+		backend = NewLocalStorage(configs.EnvMediaDir(), utils.GetBaseURL())
+	case BackendMemory:
+		backend = NewMemoryStorage()
+	default:
+		return fmt.Errorf("unknown storage backend %q", name)
+	}
+	return nil
+}
+
+// Backend returns the configured storage backend
+func Backend() Storage {
+	return backend
+}
+
+// SetBackend replaces the configured backend, e.g. with a MemoryStorage in tests
+func SetBackend(s Storage) {
+	backend = s
+}
+
+// CompletedPart identifies an uploaded part of a multipart upload
+type CompletedPart struct {
+	PartNumber int32  `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+// MultipartPresigner is implemented by backends that let clients upload
+// directly to the bucket through presigned multipart URLs
+type MultipartPresigner interface {
+	CreateMultipartUpload(ctx context.Context, bucket, key, contentType string) (string, error)
+	PresignUploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int32, expires time.Duration) (string, error)
+	CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []CompletedPart) error
+	AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error
+}
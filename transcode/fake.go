@@ -0,0 +1,94 @@
+package transcode
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FakeRunner stands in for ffmpeg in tests and CI runs. It writes a minimal
+// single-rendition ladder, or fails with Err. Delay makes a run take time,
+// e.g. to exercise cancellation.
+type FakeRunner struct {
+	Err   error
+	Delay time.Duration
+
+	mu      sync.Mutex
+	sources []string
+}
+
+func (f *FakeRunner) Run(ctx context.Context, source, outputDir string, progress Progress) error {
+	f.mu.Lock()
+	f.sources = append(f.sources, source)
+	f.mu.Unlock()
+
+	progress(0)
+	if f.Delay > 0 {
+		select {
+		case <-time.After(f.Delay / 2):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		progress(50)
+		select {
+		case <-time.After(f.Delay / 2):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if f.Err != nil {
+		return f.Err
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+	files := map[string]string{
+		MASTER_PLAYLIST: "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-STREAM-INF:BANDWIDTH=400000,RESOLUTION=426x240\n240p.m3u8\n",
+		"240p.m3u8":     "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:10\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXTINF:10.0,\n240p_000.ts\n#EXT-X-ENDLIST\n",
+		"240p_000.ts":   "fake segment",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(outputDir, name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	progress(100)
+	return os.Remove(source)
+}
+
+// Sources lists the files Run was called with
+func (f *FakeRunner) Sources() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.sources...)
+}
+
+// FakeProber stands in for ffprobe in tests, answering every probe with Info,
+// or failing with Err
+type FakeProber struct {
+	Info MediaInfo
+	Err  error
+
+	mu    sync.Mutex
+	files []string
+}
+
+func (f *FakeProber) Probe(ctx context.Context, file string) (MediaInfo, error) {
+	f.mu.Lock()
+	f.files = append(f.files, file)
+	f.mu.Unlock()
+	if f.Err != nil {
+		return MediaInfo{}, f.Err
+	}
+	return f.Info, nil
+}
+
+// Files lists the files Probe was called with
+func (f *FakeProber) Files() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.files...)
+}
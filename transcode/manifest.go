@@ -0,0 +1,246 @@
+package transcode
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Variant is one rendition listed in a master playlist
+type Variant struct {
+	URI        string
+	Bandwidth  int
+	Resolution string
+}
+
+// MediaPlaylist is the part of a variant playlist needed to check it
+type MediaPlaylist struct {
+	Segments []string
+	Duration float64 // sum of the segments' EXTINF durations, in seconds
+	Ended    bool    // has #EXT-X-ENDLIST, as every VOD playlist must
+}
+
+// IsMasterPlaylist tells master playlists from media playlists
+func IsMasterPlaylist(data []byte) bool {
+	return bytes.Contains(data, []byte("#EXT-X-STREAM-INF"))
+}
+
+// ParseMasterPlaylist lists the variants of a master playlist
+func ParseMasterPlaylist(data []byte) ([]Variant, error) {
+	lines, err := playlistLines(data)
+	if err != nil {
+		return nil, err
+	}
+	variants := []Variant{}
+	var pending *Variant
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+			bandwidth, err := strconv.Atoi(attrs["BANDWIDTH"])
+			if err != nil || bandwidth <= 0 {
+				return nil, fmt.Errorf("EXT-X-STREAM-INF without a valid BANDWIDTH: %q", line)
+			}
+			pending = &Variant{Bandwidth: bandwidth, Resolution: attrs["RESOLUTION"]}
+		case strings.HasPrefix(line, "#"):
+		case pending != nil:
+			pending.URI = line
+			variants = append(variants, *pending)
+			pending = nil
+		}
+	}
+	if pending != nil {
+		return nil, fmt.Errorf("last EXT-X-STREAM-INF has no URI")
+	}
+	return variants, nil
+}
+
+// ParseMediaPlaylist lists the segments of a media playlist
+func ParseMediaPlaylist(data []byte) (MediaPlaylist, error) {
+	lines, err := playlistLines(data)
+	if err != nil {
+		return MediaPlaylist{}, err
+	}
+	playlist := MediaPlaylist{Segments: []string{}}
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			duration := strings.TrimPrefix(line, "#EXTINF:")
+			duration, _, _ = strings.Cut(duration, ",")
+			seconds, err := strconv.ParseFloat(duration, 64)
+			if err != nil {
+				return MediaPlaylist{}, fmt.Errorf("invalid EXTINF duration: %q", line)
+			}
+			playlist.Duration += seconds
+		case line == "#EXT-X-ENDLIST":
+			playlist.Ended = true
+		case strings.HasPrefix(line, "#"):
+		default:
+			playlist.Segments = append(playlist.Segments, line)
+		}
+	}
+	return playlist, nil
+}
+
+// playlistLines returns the non-blank lines of a playlist, checking its header
+func playlistLines(data []byte) ([]string, error) {
+	lines := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || lines[0] != "#EXTM3U" {
+		return nil, fmt.Errorf("missing #EXTM3U header")
+	}
+	return lines, nil
+}
+
+// parseAttributes splits an attribute list such as
+// BANDWIDTH=400000,RESOLUTION=426x240,CODECS="avc1.4d401e,mp4a.40.2"
+func parseAttributes(list string) map[string]string {
+	attrs := map[string]string{}
+	for list != "" {
+		name, rest, ok := strings.Cut(list, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				break
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+			rest = strings.TrimPrefix(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		attrs[strings.TrimSpace(name)] = value
+		list = rest
+	}
+	return attrs
+}
+
+// Ladder is a checked HLS ladder: its master playlist and renditions, with
+// the video's duration as told by the first rendition
+type Ladder struct {
+	Master   string
+	Variants []Variant
+	Duration float64
+}
+
+// Problem is one thing wrong with a ladder, tied to the file it concerns
+type Problem struct {
+	File    string `json:"file"`
+	Problem string `json:"problem"`
+}
+
+// LadderError reports everything that keeps a ladder from being published
+type LadderError struct {
+	Problems []Problem
+}
+
+func (e *LadderError) Error() string {
+	first := e.Problems[0]
+	if len(e.Problems) == 1 {
+		return fmt.Sprintf("HLS ladder is broken: %s: %s", first.File, first.Problem)
+	}
+	return fmt.Sprintf("HLS ladder is broken: %s: %s (and %d more problems)", first.File, first.Problem, len(e.Problems)-1)
+}
+
+// CheckLadder checks a ladder is complete before it's published: there is
+// one master playlist, every variant it lists is a VOD media playlist, and
+// every segment those reference is among files. playlists holds the
+// playlists' contents and files the other objects that are in place, both
+// keyed by their path relative to the ladder's root. When anything is wrong
+// the error is a *LadderError listing every problem found.
+func CheckLadder(playlists map[string][]byte, files map[string]bool) (Ladder, error) {
+	problems := []Problem{}
+	masters := []string{}
+	for name, data := range playlists {
+		if IsMasterPlaylist(data) {
+			masters = append(masters, name)
+		}
+	}
+	sort.Strings(masters)
+	switch {
+	case len(masters) == 0:
+		return Ladder{}, &LadderError{Problems: []Problem{{File: MASTER_PLAYLIST, Problem: "no master playlist was uploaded"}}}
+	case len(masters) > 1:
+		return Ladder{}, &LadderError{Problems: []Problem{{File: strings.Join(masters, ", "), Problem: "more than one master playlist"}}}
+	}
+
+	ladder := Ladder{Master: masters[0]}
+	variants, err := ParseMasterPlaylist(playlists[ladder.Master])
+	if err != nil {
+		return Ladder{}, &LadderError{Problems: []Problem{{File: ladder.Master, Problem: err.Error()}}}
+	}
+	if len(variants) == 0 {
+		problems = append(problems, Problem{File: ladder.Master, Problem: "lists no renditions"})
+	}
+
+	for i, variant := range variants {
+		name, ok := resolveURI(ladder.Master, variant.URI)
+		if !ok {
+			problems = append(problems, Problem{File: ladder.Master, Problem: fmt.Sprintf("rendition %q must be a relative path inside the ladder", variant.URI)})
+			continue
+		}
+		data, uploaded := playlists[name]
+		if !uploaded {
+			problems = append(problems, Problem{File: name, Problem: "rendition playlist is missing"})
+			continue
+		}
+		media, err := ParseMediaPlaylist(data)
+		if err != nil {
+			problems = append(problems, Problem{File: name, Problem: err.Error()})
+			continue
+		}
+		if len(media.Segments) == 0 {
+			problems = append(problems, Problem{File: name, Problem: "lists no segments"})
+		}
+		if !media.Ended {
+			problems = append(problems, Problem{File: name, Problem: "has no #EXT-X-ENDLIST"})
+		}
+		for _, segment := range media.Segments {
+			segmentName, ok := resolveURI(name, segment)
+			switch {
+			case !ok:
+				problems = append(problems, Problem{File: name, Problem: fmt.Sprintf("segment %q must be a relative path inside the ladder", segment)})
+			case !files[segmentName]:
+				problems = append(problems, Problem{File: segmentName, Problem: "segment is missing, referenced by " + name})
+			}
+		}
+		if i == 0 {
+			ladder.Duration = media.Duration
+		}
+		variant.URI = name
+		ladder.Variants = append(ladder.Variants, variant)
+	}
+
+	if len(problems) > 0 {
+		return Ladder{}, &LadderError{Problems: problems}
+	}
+	return ladder, nil
+}
+
+// resolveURI resolves uri against the playlist referencing it, refusing
+// anything that would leave the ladder
+func resolveURI(playlist, uri string) (string, bool) {
+	if strings.Contains(uri, "://") || strings.HasPrefix(uri, "/") {
+		return "", false
+	}
+	resolved := path.Join(path.Dir(playlist), uri)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return "", false
+	}
+	return resolved, true
+}
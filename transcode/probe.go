@@ -0,0 +1,152 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ErrProberUnavailable means the probe tool isn't installed, so nothing can
+// be learnt about the file; it says nothing about the file itself
+var ErrProberUnavailable = errors.New("video prober is not installed")
+
+// MediaInfo is what probing a video file tells about it
+type MediaInfo struct {
+	Duration   float64 // seconds
+	Width      int     // coded size, before Rotation is applied
+	Height     int
+	Rotation   int    // degrees the picture is turned clockwise for display: 0, 90, 180 or 270
+	VideoCodec string // "" when the file has no video stream
+	AudioCodec string
+	Bitrate    int64 // bits per second, all streams together
+	FrameRate  float64
+	Container  string
+}
+
+// DisplaySize is the picture's size as played, with Rotation applied
+func (m MediaInfo) DisplaySize() (int, int) {
+	if m.Rotation == 90 || m.Rotation == 270 {
+		return m.Height, m.Width
+	}
+	return m.Width, m.Height
+}
+
+// Prober reads a video file's metadata. A file it can't make sense of is an
+// error; a file without a video stream is not, it just has no VideoCodec.
+type Prober interface {
+	Probe(ctx context.Context, file string) (MediaInfo, error)
+}
+
+// FFprobe probes files with the ffprobe binary at path
+type FFprobe struct {
+	path string
+}
+
+func NewFFprobe(path string) *FFprobe {
+	return &FFprobe{path: path}
+}
+
+// ffprobeOutput is the part of ffprobe's JSON output MediaInfo is built from
+type ffprobeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType    string            `json:"codec_type"`
+		CodecName    string            `json:"codec_name"`
+		Width        int               `json:"width"`
+		Height       int               `json:"height"`
+		AvgFrameRate string            `json:"avg_frame_rate"`
+		RFrameRate   string            `json:"r_frame_rate"`
+		Duration     string            `json:"duration"`
+		Tags         map[string]string `json:"tags"`
+		SideDataList []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+		Disposition struct {
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+	} `json:"streams"`
+}
+
+func (p *FFprobe) Probe(ctx context.Context, file string) (MediaInfo, error) {
+	cmd := exec.CommandContext(ctx, p.path,
+		"-v", "error", "-print_format", "json", "-show_format", "-show_streams", file)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+			return MediaInfo{}, fmt.Errorf("%w: %v", ErrProberUnavailable, err)
+		}
+		if ctx.Err() != nil {
+			return MediaInfo{}, ctx.Err()
+		}
+		return MediaInfo{}, fmt.Errorf("ffprobe could not read the file: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	var output ffprobeOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return MediaInfo{}, fmt.Errorf("parsing ffprobe output: %w", err)
+	}
+	info := MediaInfo{Container: output.Format.FormatName}
+	info.Duration, _ = strconv.ParseFloat(output.Format.Duration, 64)
+	info.Bitrate, _ = strconv.ParseInt(output.Format.BitRate, 10, 64)
+
+	for _, stream := range output.Streams {
+		switch {
+		case stream.CodecType == "audio" && info.AudioCodec == "":
+			info.AudioCodec = stream.CodecName
+		// cover art is reported as a video stream too
+		case stream.CodecType == "video" && stream.Disposition.AttachedPic == 0 && info.VideoCodec == "":
+			info.VideoCodec = stream.CodecName
+			info.Width = stream.Width
+			info.Height = stream.Height
+			info.FrameRate = parseFrameRate(stream.AvgFrameRate)
+			if info.FrameRate == 0 {
+				info.FrameRate = parseFrameRate(stream.RFrameRate)
+			}
+			if info.Duration == 0 {
+				info.Duration, _ = strconv.ParseFloat(stream.Duration, 64)
+			}
+			// older ffprobe reports a rotate tag, newer a display matrix
+			// whose rotation runs counter-clockwise
+			if rotate, err := strconv.Atoi(stream.Tags["rotate"]); err == nil {
+				info.Rotation = normalizeRotation(rotate)
+			}
+			for _, side := range stream.SideDataList {
+				if side.Rotation != 0 {
+					info.Rotation = normalizeRotation(-int(side.Rotation))
+				}
+			}
+		}
+	}
+	return info, nil
+}
+
+// parseFrameRate reads ffprobe's rational frame rates, e.g. "30000/1001"
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		value, _ := strconv.ParseFloat(rate, 64)
+		return value
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+func normalizeRotation(degrees int) int {
+	return ((degrees % 360) + 360) % 360
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package transcode
+
+import "os/exec"
+
+// isolateProcessGroup is a no-op where process groups aren't available;
+// cancellation only kills cmd itself
+func isolateProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package transcode
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// isolateProcessGroup starts cmd in its own process group and makes
+// cancellation kill the group rather than only cmd
+func isolateProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,59 @@
+package transcode
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+)
+
+var (
+	ffmpegDuration = regexp.MustCompile(`Duration: (\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+	ffmpegTime     = regexp.MustCompile(`time=(\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+)
+
+// ffmpegProgress follows ffmpeg's log: the input's duration is printed once
+// up front, then status lines ending in \r report the time encoded so far.
+type ffmpegProgress struct {
+	report   Progress
+	line     []byte
+	duration float64
+}
+
+func (p *ffmpegProgress) Write(b []byte) (int, error) {
+	for _, c := range b {
+		if c != '\r' && c != '\n' {
+			p.line = append(p.line, c)
+			continue
+		}
+		p.parse(p.line)
+		p.line = p.line[:0]
+	}
+	return len(b), nil
+}
+
+func (p *ffmpegProgress) parse(line []byte) {
+	if p.duration == 0 {
+		if m := ffmpegDuration.FindSubmatch(line); m != nil {
+			p.duration = parseTimestamp(m)
+		}
+		return
+	}
+	if !bytes.Contains(line, []byte("time=")) {
+		return
+	}
+	if m := ffmpegTime.FindSubmatch(line); m != nil {
+		percent := parseTimestamp(m) / p.duration * 100
+		if percent > 100 {
+			percent = 100
+		}
+		p.report(percent)
+	}
+}
+
+// parseTimestamp reads the hours, minutes and seconds matched as HH:MM:SS.ss
+func parseTimestamp(m [][]byte) float64 {
+	hours, _ := strconv.ParseFloat(string(m[1]), 64)
+	minutes, _ := strconv.ParseFloat(string(m[2]), 64)
+	seconds, _ := strconv.ParseFloat(string(m[3]), 64)
+	return hours*3600 + minutes*60 + seconds
+}
@@ -0,0 +1,65 @@
+// Package transcode turns raw uploaded videos into HLS renditions and probes
+// their metadata. The controllers drive it through the Runner and Prober
+// interfaces, so ffmpeg and ffprobe can be swapped for fakes in tests.
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// MASTER_PLAYLIST is the playlist a Runner writes at the top of its output,
+// referencing one media playlist per rendition
+const MASTER_PLAYLIST = "playlist.m3u8"
+
+// Progress receives how far a run has got, from 0 to 100 percent
+type Progress func(percent float64)
+
+// Runner transcodes the video at source into an HLS ladder written to
+// outputDir, which it creates, reporting its progress as it goes. Runners
+// must stop when ctx is cancelled.
+type Runner interface {
+	Run(ctx context.Context, source, outputDir string, progress Progress) error
+}
+
+// ScriptRunner runs the ffmpeg ladder in script/create-vod-hls.sh. The
+// script deletes source when it succeeds.
+type ScriptRunner struct {
+	script string
+}
+
+func NewScriptRunner(script string) *ScriptRunner {
+	return &ScriptRunner{script: script}
+}
+
+// maxOutputTail is how much of the script's output a failure reports
+const maxOutputTail = 2048
+
+func (s *ScriptRunner) Run(ctx context.Context, source, outputDir string, progress Progress) error {
+	cmd := exec.CommandContext(ctx, "bash", s.script, source, outputDir)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = io.MultiWriter(&output, &ffmpegProgress{report: progress})
+	// ffmpeg runs as a child of the script, so cancelling has to take the
+	// whole process group down
+	isolateProcessGroup(cmd)
+	cmd.WaitDelay = 10 * time.Second
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		tail := output.String()
+		if len(tail) > maxOutputTail {
+			tail = tail[len(tail)-maxOutputTail:]
+		}
+		return fmt.Errorf("%s failed: %w: %s", s.script, err, strings.TrimSpace(tail))
+	}
+	return nil
+}